@@ -1,16 +1,16 @@
 package atlas
 
 import (
-	"fmt"
 	"strings"
 )
 
 // Atlas API endpoint-related constants.
+// Endpoint paths are relative to the API path on the configured server.
 const (
-	URLBase                       = "https://atlas.ripe.net:443/api/v2"
-	CreditsEndpoint               = URLBase + "/credits"
-	MeasurementsEndpoint          = URLBase + "/measurements"
-	ProbesEndpoint                = URLBase + "/probes"
+	DefaultAPIPath                = "/api/v2"
+	CreditsEndpoint               = "/credits"
+	MeasurementsEndpoint          = "/measurements"
+	ProbesEndpoint                = "/probes"
 	ProbeEndpointFmt              = ProbesEndpoint + "/%d"
 	MeasurementEndpointFmt        = MeasurementsEndpoint + "/%d"
 	MeasurementResultsEndpointFmt = MeasurementEndpointFmt + "/results"
@@ -22,6 +22,7 @@ const (
 	AuthorizationFmt    = "Key %s"
 	ContentTypeHeader   = "Content-Type"
 	ContentType         = "application/json"
+	RetryAfterHeader    = "Retry-After"
 )
 
 // Measurement status IDs.
@@ -43,6 +44,17 @@ const (
 	MeasWiFi       = "wifi"
 )
 
+// Approximate cost of a single result of a measurement, in credits,
+// by measurement type. Costs assume default measurement options.
+var ResultCredits = map[string]int64{
+	MeasHTTP:       10,
+	MeasPing:       3,
+	MeasTraceroute: 30,
+	MeasDNS:        10,
+	MeasSSL:        10,
+	MeasNTP:        3,
+}
+
 // Address family constants.
 const (
 	IPv4 = 4
@@ -55,17 +67,13 @@ var (
 	ValidProbeRequestTypesStr = strings.Join(ValidProbeRequestTypes, ",")
 )
 
-// ProbeURL returns an endpoint for working with a probe resource.
-func ProbeURL(probeId int64) string {
-	return fmt.Sprintf(ProbeEndpointFmt, probeId)
-}
-
-// MeasurementURL returns an endpoint for working with a measurement resource.
-func MeasurementURL(measurementId int64) string {
-	return fmt.Sprintf(MeasurementEndpointFmt, measurementId)
-}
-
-// MeasurementResultsURL returns an endpoint for fetching measurement results.
-func MeasurementResultsURL(measurementId int64) string {
-	return fmt.Sprintf(MeasurementResultsEndpointFmt, measurementId)
-}
+// DNS query classes and types.
+var (
+	ValidDNSQueryClasses    = []string{"IN", "CHAOS"}
+	ValidDNSQueryClassesStr = strings.Join(ValidDNSQueryClasses, ",")
+	ValidDNSQueryTypes      = []string{
+		"A", "AAAA", "ANY", "CNAME", "DNSKEY", "DS", "MX", "NS",
+		"NSEC", "PTR", "RRSIG", "SOA", "SRV", "TXT",
+	}
+	ValidDNSQueryTypesStr = strings.Join(ValidDNSQueryTypes, ",")
+)
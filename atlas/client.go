@@ -0,0 +1,251 @@
+package atlas
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/cicovic-andrija/dante/conf"
+)
+
+// Atlas client-related constants.
+const (
+	DefaultTimeout    = 25 * time.Second
+	DefaultMaxRetries = 3
+	DefaultMinBackoff = 1 * time.Second
+	DefaultMaxBackoff = 30 * time.Second
+
+	// MaxRetryAfter is the longest delay requested by the API in
+	// a Retry-After header that the client waits before retrying.
+	MaxRetryAfter = 5 * time.Minute
+
+	maxDrainedBodySize = 64 * 1024
+)
+
+// Client represents an interface to the Atlas API,
+// or to a server compatible with it.
+type Client struct {
+	urlBase    string
+	key        string
+	httpClient *http.Client
+
+	maxRetries int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	limiter *limiter
+}
+
+// NewClient returns a new Client.
+func NewClient(cfg *conf.AtlasConf) *Client {
+	apiPath := cfg.APIPath
+	if apiPath == "" {
+		apiPath = DefaultAPIPath
+	}
+
+	return &Client{
+		urlBase: cfg.Net.GetURLBase() + apiPath,
+		key:     cfg.Auth.Key,
+		httpClient: &http.Client{
+			Timeout: DefaultTimeout,
+		},
+		maxRetries: DefaultMaxRetries,
+		minBackoff: DefaultMinBackoff,
+		maxBackoff: DefaultMaxBackoff,
+		limiter:    newLimiter(&cfg.RateLimit),
+	}
+}
+
+// SetTransport replaces the transport used to send HTTP requests.
+func (c *Client) SetTransport(transport http.RoundTripper) {
+	c.httpClient.Transport = transport
+}
+
+// LimiterStats returns the state of the rate limiter.
+func (c *Client) LimiterStats() LimiterStats {
+	return c.limiter.stats()
+}
+
+// URLBase returns the base URL of the API.
+func (c *Client) URLBase() string {
+	return c.urlBase
+}
+
+// CreditsURL returns an endpoint for fetching credit reports.
+func (c *Client) CreditsURL() string {
+	return c.urlBase + CreditsEndpoint
+}
+
+// MeasurementsURL returns an endpoint for creating measurements.
+func (c *Client) MeasurementsURL() string {
+	return c.urlBase + MeasurementsEndpoint
+}
+
+// ProbeURL returns an endpoint for working with a probe resource.
+func (c *Client) ProbeURL(probeId int64) string {
+	return c.urlBase + fmt.Sprintf(ProbeEndpointFmt, probeId)
+}
+
+// MeasurementURL returns an endpoint for working with a measurement resource.
+func (c *Client) MeasurementURL(measurementId int64) string {
+	return c.urlBase + fmt.Sprintf(MeasurementEndpointFmt, measurementId)
+}
+
+// MeasurementResultsURL returns an endpoint for fetching measurement results,
+// optionally limited to a time window. Zero start or stop means no limit.
+func (c *Client) MeasurementResultsURL(measurementId int64, start int64, stop int64) string {
+	query := url.Values{}
+	if start > 0 {
+		query.Set("start", strconv.FormatInt(start, 10))
+	}
+	if stop > 0 {
+		query.Set("stop", strconv.FormatInt(stop, 10))
+	}
+
+	endpoint := c.urlBase + fmt.Sprintf(MeasurementResultsEndpointFmt, measurementId)
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+	return endpoint
+}
+
+// Do sends an HTTP request prepared by PrepareRequest, and decodes the
+// response body into v, unless v is nil. Responses with a status code other
+// than 2xx are returned as *StatusError. Rate limited requests, and idempotent
+// requests that failed because of a server error, are retried with exponential
+// backoff, or after the delay requested by the API. All requests, including
+// retries, share the rate limit and the maximum number of requests in flight.
+// The response body is always drained and closed, so the connection can be reused.
+func (c *Client) Do(ctx context.Context, req *http.Request, v interface{}) error {
+	for attempt := 0; ; attempt++ {
+		err := c.do(ctx, req, v)
+
+		var statusErr *StatusError
+		if !errors.As(err, &statusErr) || !c.shouldRetry(req, statusErr, attempt) {
+			return err
+		}
+
+		delay := statusErr.RetryAfter
+		if delay == 0 {
+			delay = c.backoff(attempt)
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+	}
+}
+
+func (c *Client) do(ctx context.Context, req *http.Request, v interface{}) error {
+	req = req.Clone(ctx)
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return err
+		}
+		req.Body = body
+	}
+
+	release, err := c.limiter.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		io.Copy(io.Discard, io.LimitReader(res.Body, maxDrainedBodySize))
+		res.Body.Close()
+	}()
+
+	if res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusMultipleChoices {
+		return newStatusError(res)
+	}
+
+	if v != nil && res.StatusCode != http.StatusNoContent {
+		if err = json.NewDecoder(res.Body).Decode(v); err != nil {
+			return fmt.Errorf("failed to decode response body: %v", err)
+		}
+	}
+
+	return nil
+}
+
+func (c *Client) shouldRetry(req *http.Request, statusErr *StatusError, attempt int) bool {
+	if attempt >= c.maxRetries || !statusErr.Temporary() {
+		return false
+	}
+
+	// the API may have processed a non-idempotent request before failing
+	if statusErr.StatusCode != http.StatusTooManyRequests &&
+		req.Method != http.MethodGet && req.Method != http.MethodDelete {
+		return false
+	}
+
+	// do not wait longer than the API would tolerate
+	return statusErr.RetryAfter <= MaxRetryAfter
+}
+
+// Returns exponential backoff with jitter: a random duration between
+// half of the full backoff and the full backoff.
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.minBackoff << uint(attempt)
+	if d <= 0 || d > c.maxBackoff {
+		d = c.maxBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func newStatusError(res *http.Response) *StatusError {
+	statusErr := &StatusError{
+		StatusCode: res.StatusCode,
+		RetryAfter: parseRetryAfter(res.Header.Get(RetryAfterHeader)),
+	}
+
+	errResp := &struct {
+		Error *Error `json:"error"`
+	}{}
+	body, _ := io.ReadAll(io.LimitReader(res.Body, maxDrainedBodySize))
+	if json.Unmarshal(body, errResp) == nil && errResp.Error != nil {
+		statusErr.Err = errResp.Error
+	} else {
+		// for example, an HTML page served by a proxy
+		statusErr.Err = &Error{
+			Title:  http.StatusText(res.StatusCode),
+			Status: int64(res.StatusCode),
+			Detail: "unexpected response from the API",
+		}
+	}
+
+	return statusErr
+}
+
+// Parses a Retry-After header value, either in seconds or an HTTP date.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if d := time.Until(date); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
@@ -0,0 +1,215 @@
+package atlas
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cicovic-andrija/dante/conf"
+)
+
+// Returns a client of a test server that responds to the n-th request
+// (starting from 0) with the n-th handler, and to later requests with the last one.
+// Returns the number of received requests too.
+func newTestClient(t *testing.T, handlers ...http.HandlerFunc) (*Client, *int64) {
+	var requests int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(atomic.AddInt64(&requests, 1)) - 1
+		if n >= len(handlers) {
+			n = len(handlers) - 1
+		}
+		handlers[n](w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	return &Client{
+		urlBase:    srv.URL,
+		httpClient: srv.Client(),
+		maxRetries: DefaultMaxRetries,
+		minBackoff: time.Millisecond,
+		maxBackoff: 4 * time.Millisecond,
+		limiter:    newLimiter(&conf.RateLimitConf{RequestsPerSec: 1000, Burst: 100}),
+	}, &requests
+}
+
+func respond(status int, header map[string]string, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for k, v := range header {
+			w.Header().Set(k, v)
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}
+}
+
+func doTestRequest(t *testing.T, c *Client, method string, v interface{}) error {
+	req, err := c.PrepareRequest(c.CreditsURL(), &ReqParams{Method: method, Body: struct{}{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c.Do(context.Background(), req, v)
+}
+
+func TestClientRetries(t *testing.T) {
+	var (
+		ok          = respond(http.StatusOK, nil, `{"current_balance": 42}`)
+		serverError = respond(http.StatusBadGateway, nil, "<html>bad gateway</html>")
+		rateLimited = respond(http.StatusTooManyRequests, nil, "")
+		notFound    = respond(http.StatusNotFound, nil, `{"error": {"status": 404, "title": "Not Found", "detail": "no such probe"}}`)
+	)
+
+	tests := []struct {
+		name     string
+		method   string
+		handlers []http.HandlerFunc
+		requests int64
+		failed   bool
+	}{
+		{"success", http.MethodGet, []http.HandlerFunc{ok}, 1, false},
+		{"server error", http.MethodGet, []http.HandlerFunc{serverError, serverError, ok}, 3, false},
+		{"retries exhausted", http.MethodGet, []http.HandlerFunc{serverError}, DefaultMaxRetries + 1, true},
+		{"client error", http.MethodGet, []http.HandlerFunc{notFound, ok}, 1, true},
+		{"rate limited post", http.MethodPost, []http.HandlerFunc{rateLimited, ok}, 2, false},
+		{"server error post", http.MethodPost, []http.HandlerFunc{serverError, ok}, 1, true},
+	}
+
+	for _, test := range tests {
+		c, requests := newTestClient(t, test.handlers...)
+		credits := &Credit{}
+		err := doTestRequest(t, c, test.method, credits)
+		if (err != nil) != test.failed {
+			t.Errorf("%s: error = %v, want failure %t", test.name, err, test.failed)
+		}
+		if *requests != test.requests {
+			t.Errorf("%s: %d requests, want %d", test.name, *requests, test.requests)
+		}
+		if err == nil && credits.CurrentBalance != 42 {
+			t.Errorf("%s: response body not decoded: %+v", test.name, credits)
+		}
+	}
+}
+
+func TestClientRetryAfter(t *testing.T) {
+	c, requests := newTestClient(t,
+		respond(http.StatusServiceUnavailable, map[string]string{RetryAfterHeader: "1"}, ""),
+		respond(http.StatusOK, nil, "{}"),
+	)
+	start := time.Now()
+	if err := doTestRequest(t, c, http.MethodGet, nil); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %v, want 1s", elapsed)
+	}
+	if *requests != 2 {
+		t.Errorf("%d requests, want 2", *requests)
+	}
+
+	// delays longer than MaxRetryAfter are not waited for
+	tooLong := strconv.Itoa(int(MaxRetryAfter.Seconds()) + 1)
+	c, requests = newTestClient(t, respond(http.StatusTooManyRequests, map[string]string{RetryAfterHeader: tooLong}, ""))
+	err := doTestRequest(t, c, http.MethodGet, nil)
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.RetryAfter != MaxRetryAfter+time.Second {
+		t.Errorf("error = %v, want Retry-After %s", err, tooLong)
+	}
+	if *requests != 1 {
+		t.Errorf("%d requests, want 1", *requests)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		value string
+		min   time.Duration
+		max   time.Duration
+	}{
+		{"", 0, 0},
+		{"120", 2 * time.Minute, 2 * time.Minute},
+		{"-5", 0, 0},
+		{"soon", 0, 0},
+		{time.Now().Add(time.Minute).UTC().Format(http.TimeFormat), 58 * time.Second, time.Minute},
+		{time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat), 0, 0},
+	}
+	for _, test := range tests {
+		if d := parseRetryAfter(test.value); d < test.min || d > test.max {
+			t.Errorf("parseRetryAfter(%q) = %v, want between %v and %v", test.value, d, test.min, test.max)
+		}
+	}
+}
+
+func TestClientBackoff(t *testing.T) {
+	c := &Client{minBackoff: time.Second, maxBackoff: 30 * time.Second}
+	for attempt := 0; attempt < 70; attempt++ {
+		full := time.Second << uint(attempt)
+		if attempt >= 5 {
+			full = 30 * time.Second
+		}
+		if d := c.backoff(attempt); d < full/2 || d > full {
+			t.Errorf("backoff(%d) = %v, want between %v and %v", attempt, d, full/2, full)
+		}
+	}
+}
+
+func TestStatusErrors(t *testing.T) {
+	tests := []struct {
+		status    int
+		body      string
+		class     error
+		temporary bool
+		title     string
+	}{
+		{http.StatusBadRequest, `{"error": {"status": 400, "title": "Bad Request", "detail": "invalid target"}}`,
+			ErrBadRequest, false, "Bad Request"},
+		{http.StatusUnauthorized, "", ErrUnauthorized, false, "Unauthorized"},
+		{http.StatusForbidden, "", ErrForbidden, false, "Forbidden"},
+		{http.StatusNotFound, "", ErrNotFound, false, "Not Found"},
+		{http.StatusConflict, "", ErrClientError, false, "Conflict"},
+		{http.StatusTooManyRequests, "", ErrRateLimited, true, "Too Many Requests"},
+		{http.StatusServiceUnavailable, "<html>maintenance</html>", ErrServerError, true, "Service Unavailable"},
+	}
+
+	for _, test := range tests {
+		c, _ := newTestClient(t, respond(test.status, nil, test.body))
+		c.maxRetries = 0
+		err := doTestRequest(t, c, http.MethodGet, nil)
+
+		var statusErr *StatusError
+		if !errors.As(err, &statusErr) {
+			t.Errorf("%d: error = %v, want *StatusError", test.status, err)
+			continue
+		}
+		if !errors.Is(err, test.class) {
+			t.Errorf("%d: error %v is not %v", test.status, err, test.class)
+		}
+		if statusErr.Temporary() != test.temporary {
+			t.Errorf("%d: temporary = %t, want %t", test.status, statusErr.Temporary(), test.temporary)
+		}
+		if statusErr.Err.Title != test.title {
+			t.Errorf("%d: title = %q, want %q", test.status, statusErr.Err.Title, test.title)
+		}
+	}
+}
+
+// Waiting for a retry ends when the context is canceled.
+func TestClientRetryCanceled(t *testing.T) {
+	c, requests := newTestClient(t, respond(http.StatusTooManyRequests, map[string]string{RetryAfterHeader: "60"}, ""))
+	req, err := c.PrepareRequest(c.CreditsURL(), &ReqParams{Method: http.MethodGet})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err = c.Do(ctx, req, nil); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("error = %v, want %v", err, ErrRateLimited)
+	}
+	if *requests != 1 {
+		t.Errorf("%d requests, want 1", *requests)
+	}
+}
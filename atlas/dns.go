@@ -0,0 +1,150 @@
+package atlas
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DNS response codes, as presented in zone files and dig output.
+var rcodeNames = map[dnsmessage.RCode]string{
+	dnsmessage.RCodeSuccess:        "NOERROR",
+	dnsmessage.RCodeFormatError:    "FORMERR",
+	dnsmessage.RCodeServerFailure:  "SERVFAIL",
+	dnsmessage.RCodeNameError:      "NXDOMAIN",
+	dnsmessage.RCodeNotImplemented: "NOTIMP",
+	dnsmessage.RCodeRefused:        "REFUSED",
+}
+
+// DNSMessage contains decoded values of a DNS response message.
+type DNSMessage struct {
+	RCode   string
+	Answers []DNSAnswer
+}
+
+// DNSAnswer represents a single resource record
+// from the answer section of a DNS response message.
+type DNSAnswer struct {
+	Name string
+	Type string
+	TTL  uint32
+	Data string
+}
+
+// Decode decodes the response message from the answer buffer.
+func (r *DNSResponse) Decode() (*DNSMessage, error) {
+	if r.AnswerBuffer == "" {
+		return nil, errors.New("answer buffer is empty")
+	}
+
+	buf, err := base64.StdEncoding.DecodeString(r.AnswerBuffer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode answer buffer: %v", err)
+	}
+
+	var p dnsmessage.Parser
+	header, err := p.Start(buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse message header: %v", err)
+	}
+	if err = p.SkipAllQuestions(); err != nil {
+		return nil, fmt.Errorf("failed to parse question section: %v", err)
+	}
+
+	msg := &DNSMessage{RCode: rcodeName(header.RCode)}
+	for {
+		h, err := p.AnswerHeader()
+		if err == dnsmessage.ErrSectionDone {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse answer section: %v", err)
+		}
+
+		answer := DNSAnswer{
+			Name: h.Name.String(),
+			Type: strings.TrimPrefix(h.Type.String(), "Type"),
+			TTL:  h.TTL,
+		}
+		if answer.Data, err = parseAnswerData(&p, h.Type); err != nil {
+			return nil, fmt.Errorf("failed to parse %s record: %v", answer.Type, err)
+		}
+		msg.Answers = append(msg.Answers, answer)
+	}
+
+	return msg, nil
+}
+
+func parseAnswerData(p *dnsmessage.Parser, t dnsmessage.Type) (string, error) {
+	switch t {
+	case dnsmessage.TypeA:
+		r, err := p.AResource()
+		if err != nil {
+			return "", err
+		}
+		return net.IP(r.A[:]).String(), nil
+	case dnsmessage.TypeAAAA:
+		r, err := p.AAAAResource()
+		if err != nil {
+			return "", err
+		}
+		return net.IP(r.AAAA[:]).String(), nil
+	case dnsmessage.TypeCNAME:
+		r, err := p.CNAMEResource()
+		if err != nil {
+			return "", err
+		}
+		return r.CNAME.String(), nil
+	case dnsmessage.TypeNS:
+		r, err := p.NSResource()
+		if err != nil {
+			return "", err
+		}
+		return r.NS.String(), nil
+	case dnsmessage.TypePTR:
+		r, err := p.PTRResource()
+		if err != nil {
+			return "", err
+		}
+		return r.PTR.String(), nil
+	case dnsmessage.TypeMX:
+		r, err := p.MXResource()
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%d %s", r.Pref, r.MX.String()), nil
+	case dnsmessage.TypeTXT:
+		r, err := p.TXTResource()
+		if err != nil {
+			return "", err
+		}
+		return strings.Join(r.TXT, ""), nil
+	case dnsmessage.TypeSOA:
+		r, err := p.SOAResource()
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s %s %d", r.NS.String(), r.MBox.String(), r.Serial), nil
+	case dnsmessage.TypeSRV:
+		r, err := p.SRVResource()
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%d %d %d %s", r.Priority, r.Weight, r.Port, r.Target.String()), nil
+	default:
+		// record data is not decoded for other types
+		return "", p.SkipAnswer()
+	}
+}
+
+func rcodeName(rcode dnsmessage.RCode) string {
+	if name, ok := rcodeNames[rcode]; ok {
+		return name
+	}
+	return strconv.Itoa(int(rcode))
+}
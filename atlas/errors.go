@@ -0,0 +1,62 @@
+package atlas
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Classes of errors returned by the Atlas API. Errors returned by Client.Do
+// can be matched against them with errors.Is.
+var (
+	ErrBadRequest   = errors.New("bad request")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+	ErrNotFound     = errors.New("not found")
+	ErrRateLimited  = errors.New("rate limited")
+	ErrClientError  = errors.New("client error")
+	ErrServerError  = errors.New("server error")
+)
+
+// StatusError is returned by Client.Do when the API responds with
+// a status code other than 2xx. Err holds the error object returned
+// by the API, or an equivalent one if the response body had none.
+type StatusError struct {
+	StatusCode int
+	RetryAfter time.Duration
+	Err        *Error
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("client request failed (%s %d): %s", e.Err.Title, e.StatusCode, e.Err.Detail)
+}
+
+// Is reports whether the error belongs to a class of errors.
+func (e *StatusError) Is(target error) bool {
+	return target == statusClass(e.StatusCode)
+}
+
+// Temporary reports whether the request may succeed if retried later.
+func (e *StatusError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= http.StatusInternalServerError
+}
+
+func statusClass(statusCode int) error {
+	switch {
+	case statusCode == http.StatusBadRequest:
+		return ErrBadRequest
+	case statusCode == http.StatusUnauthorized:
+		return ErrUnauthorized
+	case statusCode == http.StatusForbidden:
+		return ErrForbidden
+	case statusCode == http.StatusNotFound:
+		return ErrNotFound
+	case statusCode == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case statusCode >= http.StatusInternalServerError:
+		return ErrServerError
+	default:
+		return ErrClientError
+	}
+}
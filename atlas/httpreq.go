@@ -11,13 +11,12 @@ import (
 // an HTTP request for the Atlas API.
 type ReqParams struct {
 	Method string
-	Key    string
 	Body   interface{}
 }
 
 // PrepareRequest creates a new HTTP request, serializes the request body,
 // and set the headers expected by the Atlas API.
-func PrepareRequest(url string, reqParams *ReqParams) (*http.Request, error) {
+func (c *Client) PrepareRequest(url string, reqParams *ReqParams) (*http.Request, error) {
 	var (
 		req *http.Request
 		err error
@@ -42,7 +41,7 @@ func PrepareRequest(url string, reqParams *ReqParams) (*http.Request, error) {
 
 	req.Header.Set(
 		AuthorizationHeader,
-		fmt.Sprintf(AuthorizationFmt, reqParams.Key),
+		fmt.Sprintf(AuthorizationFmt, c.key),
 	)
 	req.Header.Set(
 		ContentTypeHeader,
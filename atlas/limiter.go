@@ -0,0 +1,139 @@
+package atlas
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cicovic-andrija/dante/conf"
+)
+
+// Default rate limiting options, used for options not set in configuration.
+const (
+	DefaultRequestsPerSec = 5.0
+	DefaultBurst          = 10
+	DefaultMaxInFlight    = 8
+)
+
+// LimiterStats reports the state of the limiter shared by all requests
+// sent by a Client. Waits and WaitTime are cumulative.
+type LimiterStats struct {
+	Waiting  int64
+	InFlight int64
+	Waits    int64
+	WaitTime time.Duration
+}
+
+// limiter limits the rate of requests with a token bucket,
+// and the number of requests in flight with a semaphore.
+type limiter struct {
+	sync.Mutex
+
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+
+	slots chan struct{}
+
+	// accessed atomically
+	waiting    int64
+	inFlight   int64
+	waits      int64
+	waitedNano int64
+}
+
+func newLimiter(cfg *conf.RateLimitConf) *limiter {
+	rate := cfg.RequestsPerSec
+	if rate <= 0 {
+		rate = DefaultRequestsPerSec
+	}
+	burst := cfg.Burst
+	if burst <= 0 {
+		burst = DefaultBurst
+	}
+	maxInFlight := cfg.MaxInFlight
+	if maxInFlight <= 0 {
+		maxInFlight = DefaultMaxInFlight
+	}
+
+	return &limiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+		slots:  make(chan struct{}, maxInFlight),
+	}
+}
+
+// acquire waits until a request can be sent, and returns a function
+// that must be called when the request is done.
+func (l *limiter) acquire(ctx context.Context) (release func(), err error) {
+	start := time.Now()
+	atomic.AddInt64(&l.waiting, 1)
+	defer func() {
+		atomic.AddInt64(&l.waiting, -1)
+		atomic.AddInt64(&l.waits, 1)
+		atomic.AddInt64(&l.waitedNano, int64(time.Since(start)))
+	}()
+
+	select {
+	case l.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	if err = l.takeToken(ctx); err != nil {
+		<-l.slots
+		return nil, err
+	}
+
+	atomic.AddInt64(&l.inFlight, 1)
+	return func() {
+		atomic.AddInt64(&l.inFlight, -1)
+		<-l.slots
+	}, nil
+}
+
+// Takes a token from the bucket, waiting for it if the bucket is empty.
+// Tokens are reserved in order of calls, so waiting requests are served
+// in the order they arrived.
+func (l *limiter) takeToken(ctx context.Context) error {
+	l.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	l.tokens--
+	wait := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		// give the reserved token back
+		l.Lock()
+		l.tokens++
+		l.Unlock()
+		return ctx.Err()
+	}
+}
+
+func (l *limiter) stats() LimiterStats {
+	return LimiterStats{
+		Waiting:  atomic.LoadInt64(&l.waiting),
+		InFlight: atomic.LoadInt64(&l.inFlight),
+		Waits:    atomic.LoadInt64(&l.waits),
+		WaitTime: time.Duration(atomic.LoadInt64(&l.waitedNano)),
+	}
+}
@@ -0,0 +1,122 @@
+package atlas
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cicovic-andrija/dante/conf"
+)
+
+// Requests waiting for tokens are served in the order they arrived,
+// at the configured rate.
+func TestLimiterOrder(t *testing.T) {
+	const (
+		rate     = 20
+		requests = 5
+	)
+	l := newLimiter(&conf.RateLimitConf{RequestsPerSec: rate, Burst: 1, MaxInFlight: requests + 1})
+
+	start := time.Now()
+	var (
+		mu    sync.Mutex
+		order []int
+		wg    sync.WaitGroup
+	)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			release, err := l.acquire(context.Background())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer release()
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+		}(i)
+		// let the request reserve its token before the next one arrives
+		time.Sleep(5 * time.Millisecond)
+	}
+	wg.Wait()
+
+	for i, n := range order {
+		if n != i {
+			t.Fatalf("order = %v, want arrival order", order)
+		}
+	}
+	if elapsed, want := time.Since(start), (requests-1)*time.Second/rate; elapsed < want {
+		t.Errorf("%d requests served in %v, want at least %v", requests, elapsed, want)
+	}
+
+	stats := l.stats()
+	if stats.Waits != requests || stats.Waiting != 0 || stats.InFlight != 0 || stats.WaitTime <= 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+// A canceled wait for a token gives the reserved token back.
+func TestLimiterCanceledTokenWait(t *testing.T) {
+	l := newLimiter(&conf.RateLimitConf{RequestsPerSec: 1, Burst: 1})
+
+	release, err := l.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err = l.acquire(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("acquire = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// the next token is not reserved by the canceled request
+	l.Lock()
+	tokens := l.tokens
+	l.Unlock()
+	if tokens < 0 {
+		t.Errorf("%.2f tokens left, want the reserved token given back", tokens)
+	}
+
+	stats := l.stats()
+	if stats.Waits != 2 || stats.Waiting != 0 || stats.InFlight != 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+// Requests wait for a slot when the maximum number of requests is in flight.
+func TestLimiterMaxInFlight(t *testing.T) {
+	l := newLimiter(&conf.RateLimitConf{RequestsPerSec: 1000, Burst: 10, MaxInFlight: 1})
+
+	release, err := l.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats := l.stats(); stats.InFlight != 1 {
+		t.Errorf("%d requests in flight, want 1", stats.InFlight)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err = l.acquire(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("acquire = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	acquired := make(chan struct{})
+	go func() {
+		if next, err := l.acquire(context.Background()); err == nil {
+			next()
+		}
+		close(acquired)
+	}()
+	release()
+	select {
+	case <-acquired:
+	case <-time.After(5 * time.Second):
+		t.Fatal("slot not acquired after release")
+	}
+}
@@ -20,6 +20,15 @@ type Probe struct {
 	ResourceBase
 	CountryCode string `json:"country_code"`
 	ASNv4       int64  `json:"asn_v4"`
+	ASNv6       int64  `json:"asn_v6"`
+}
+
+// ASN returns the AS number of the probe for an address family.
+func (p *Probe) ASN(af int32) int64 {
+	if af == IPv6 {
+		return p.ASNv6
+	}
+	return p.ASNv4
 }
 
 // MeasurementDefinition specifies parameters of a new measurement,
@@ -27,13 +36,19 @@ type Probe struct {
 type MeasurementDefinition struct {
 	Type          string `json:"type"`
 	AddressFamily int32  `json:"af"`
-	Target        string `json:"target"`
+	Target        string `json:"target,omitempty"`
 	Description   string `json:"description"`
 	IsPublic      bool   `json:"is_public"`
 	IsOneOff      bool   `json:"is_oneoff"`
-	StartTime     int64  `json:"start_time"`
-	StopTime      int64  `json:"stop_time"`
+	StartTime     int64  `json:"start_time,omitempty"` // as soon as possible if not set
+	StopTime      int64  `json:"stop_time,omitempty"`  // runs until stopped if not set
 	Interval      int64  `json:"interval"`
+
+	// DNS measurements only.
+	QueryClass       string `json:"query_class,omitempty"`
+	QueryType        string `json:"query_type,omitempty"`
+	QueryArgument    string `json:"query_argument,omitempty"`
+	UseProbeResolver bool   `json:"use_probe_resolver,omitempty"`
 }
 
 // ProbeRequest specifies how the probes are to be selected
@@ -57,8 +72,6 @@ type MeasurementRequest struct {
 // from the Atlas API.
 type MeasurementReqResponse struct {
 	Measurements []int64 `json:"measurements"`
-
-	Error *Error `json:"error"`
 }
 
 // Measurement represents a measurement resource on the Atlas platform.
@@ -68,13 +81,12 @@ type Measurement struct {
 	Description   string `json:"description"`
 	Target        string `json:"target"`
 	TargetIP      string `json:"target_ip"`
+	Interval      int64  `json:"interval"`
 	StartTime     int64  `json:"start_time"`
 	StopTime      int64  `json:"stop_time"`
 	Status        struct {
 		ID int32 `json:"id"`
 	} `json:"status"`
-
-	Error *Error `json:"error"`
 }
 
 // MeasurementResults contains an array of single measurement results
@@ -110,10 +122,188 @@ type Result struct {
 	Method  string `json:"method"`
 }
 
+// PingResults contains an array of ping measurement results,
+// each performed by a single probe.
+type PingResults []ProbePingResults
+
+// ProbePingResults contains a summary and per-packet replies
+// of a single ping measurement performed by a single probe.
+type ProbePingResults struct {
+	FirmwareVersion int32       `json:"fw"`
+	Timestamp       int64       `json:"timestamp"`
+	ProbeID         int64       `json:"prb_id"`
+	SrcAddr         string      `json:"src_addr"`
+	DstAddr         string      `json:"dst_addr"`
+	Sent            int64       `json:"sent"`
+	Received        int64       `json:"rcvd"`
+	Min             float64     `json:"min"`
+	Avg             float64     `json:"avg"`
+	Max             float64     `json:"max"`
+	Replies         []PingReply `json:"result"`
+}
+
+// PingReply represents a reply to a single ICMP echo request.
+// A timed out request has Timeout set to "*", and an RTT of 0.
+type PingReply struct {
+	RTT     float64 `json:"rtt"`
+	Timeout string  `json:"x"`
+	Error   string  `json:"error"`
+}
+
+// TimedOut returns a flag indicating whether the echo request
+// timed out or failed, in which case RTT is not valid.
+func (r *PingReply) TimedOut() bool {
+	return r.Timeout != "" || r.Error != ""
+}
+
+// TracerouteResults contains an array of traceroute measurement results,
+// each performed by a single probe.
+type TracerouteResults []ProbeTracerouteResults
+
+// ProbeTracerouteResults contains hops of a single traceroute measurement
+// performed by a single probe.
+type ProbeTracerouteResults struct {
+	FirmwareVersion int32           `json:"fw"`
+	Timestamp       int64           `json:"timestamp"`
+	EndTime         int64           `json:"endtime"`
+	ProbeID         int64           `json:"prb_id"`
+	SrcAddr         string          `json:"src_addr"`
+	DstAddr         string          `json:"dst_addr"`
+	Protocol        string          `json:"proto"`
+	Hops            []TracerouteHop `json:"result"`
+}
+
+// TracerouteHop contains replies to the packets sent
+// with a single TTL value.
+type TracerouteHop struct {
+	Hop     int64             `json:"hop"`
+	Error   string            `json:"error"`
+	Replies []TracerouteReply `json:"result"`
+}
+
+// TracerouteReply represents a reply to a single traceroute packet.
+// A timed out packet has Timeout set to "*".
+type TracerouteReply struct {
+	From    string  `json:"from"`
+	RTT     float64 `json:"rtt"`
+	Size    int64   `json:"size"`
+	TTL     int64   `json:"ttl"`
+	Timeout string  `json:"x"`
+}
+
+// TimedOut returns a flag indicating whether the packet
+// timed out, in which case From and RTT are not valid.
+func (r *TracerouteReply) TimedOut() bool {
+	return r.Timeout != "" || r.From == ""
+}
+
+// DNSResults contains an array of DNS measurement results,
+// each performed by a single probe.
+type DNSResults []ProbeDNSResults
+
+// ProbeDNSResults contains responses to a single DNS query
+// performed by a single probe. If the probe used its own resolvers,
+// there is an entry in ResultSet for each of them, otherwise
+// Result or Error are set.
+type ProbeDNSResults struct {
+	FirmwareVersion int32                  `json:"fw"`
+	Timestamp       int64                  `json:"timestamp"`
+	ProbeID         int64                  `json:"prb_id"`
+	DstAddr         string                 `json:"dst_addr"`
+	Protocol        string                 `json:"proto"`
+	Result          *DNSResponse           `json:"result"`
+	Error           map[string]interface{} `json:"error"`
+	ResultSet       []DNSResultSetEntry    `json:"resultset"`
+}
+
+// DNSResultSetEntry contains a response from a single resolver.
+type DNSResultSetEntry struct {
+	Timestamp int64                  `json:"time"`
+	DstAddr   string                 `json:"dst_addr"`
+	Result    *DNSResponse           `json:"result"`
+	Error     map[string]interface{} `json:"error"`
+}
+
+// DNSResponse represents a DNS response received by a probe.
+// AnswerBuffer holds the base64-encoded response message.
+type DNSResponse struct {
+	AnswerCount  int64   `json:"ANCOUNT"`
+	AnswerBuffer string  `json:"abuf"`
+	RT           float64 `json:"rt"`
+	Size         int64   `json:"size"`
+}
+
+// Responses returns the responses from all resolvers queried by the probe,
+// regardless of whether they are returned in a result set or not.
+func (r *ProbeDNSResults) Responses() []DNSResultSetEntry {
+	if len(r.ResultSet) > 0 {
+		return r.ResultSet
+	}
+	return []DNSResultSetEntry{
+		{
+			Timestamp: r.Timestamp,
+			DstAddr:   r.DstAddr,
+			Result:    r.Result,
+			Error:     r.Error,
+		},
+	}
+}
+
+// SSLCertResults contains an array of sslcert measurement results,
+// each performed by a single probe.
+type SSLCertResults []ProbeSSLCertResults
+
+// ProbeSSLCertResults contains a certificate chain served to a single probe,
+// in a single sslcert measurement. Certificates are PEM-encoded,
+// with the leaf certificate first.
+type ProbeSSLCertResults struct {
+	FirmwareVersion int32    `json:"fw"`
+	Timestamp       int64    `json:"timestamp"`
+	ProbeID         int64    `json:"prb_id"`
+	DstAddr         string   `json:"dst_addr"`
+	DstName         string   `json:"dst_name"`
+	Method          string   `json:"method"`
+	Version         string   `json:"ver"`
+	RT              float64  `json:"rt"`
+	TTC             float64  `json:"ttc"`
+	Certificates    []string `json:"cert"`
+	Error           string   `json:"err"`
+}
+
+// NTPResults contains an array of NTP measurement results,
+// each performed by a single probe.
+type NTPResults []ProbeNTPResults
+
+// ProbeNTPResults contains server information and per-packet replies
+// of a single NTP measurement performed by a single probe.
+type ProbeNTPResults struct {
+	FirmwareVersion int32      `json:"fw"`
+	Timestamp       int64      `json:"timestamp"`
+	ProbeID         int64      `json:"prb_id"`
+	DstAddr         string     `json:"dst_addr"`
+	DstName         string     `json:"dst_name"`
+	Stratum         int64      `json:"stratum"`
+	ReferenceID     string     `json:"ref-id"`
+	Version         int64      `json:"version"`
+	Replies         []NTPReply `json:"result"`
+}
+
+// NTPReply represents a reply to a single NTP request.
+// Offset and RTT are in seconds. A timed out request has Timeout set to "*".
+type NTPReply struct {
+	Offset  float64 `json:"offset"`
+	RTT     float64 `json:"rtt"`
+	Timeout string  `json:"x"`
+}
+
+// TimedOut returns a flag indicating whether the request
+// timed out, in which case Offset and RTT are not valid.
+func (r *NTPReply) TimedOut() bool {
+	return r.Timeout != ""
+}
+
 // Credit represents a credit report object,
 // fetched from the Atlas API.
 type Credit struct {
 	CurrentBalance int64 `json:"current_balance"`
-
-	Error *Error `json:"error"`
 }
@@ -0,0 +1,30 @@
+package atlas
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// RIPEstat Data API endpoint-related constants.
+// RIPEstat is used to map IP addresses to origin AS numbers.
+const (
+	StatURLBase                = "https://stat.ripe.net/data"
+	StatNetworkInfoEndpointFmt = StatURLBase + "/network-info/data.json?resource=%s"
+	StatStatusOK               = "ok"
+)
+
+// NetworkInfo represents a response object returned by
+// the RIPEstat network-info data call.
+type NetworkInfo struct {
+	Status string `json:"status"`
+	Data   struct {
+		ASNs   []string `json:"asns"`
+		Prefix string   `json:"prefix"`
+	} `json:"data"`
+}
+
+// NetworkInfoURL returns an endpoint for looking up
+// the origin AS numbers of an IP address.
+func NetworkInfoURL(ip string) string {
+	return fmt.Sprintf(StatNetworkInfoEndpointFmt, url.QueryEscape(ip))
+}
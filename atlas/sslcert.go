@@ -0,0 +1,36 @@
+package atlas
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+// LeafCertificate parses and returns the first certificate
+// in the certificate chain.
+func (r *ProbeSSLCertResults) LeafCertificate() (*x509.Certificate, error) {
+	if len(r.Certificates) == 0 {
+		return nil, errors.New("certificate chain is empty")
+	}
+
+	block, _ := pem.Decode([]byte(r.Certificates[0]))
+	if block == nil {
+		return nil, errors.New("failed to decode PEM block")
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %v", err)
+	}
+
+	return cert, nil
+}
+
+// CertificateFingerprint returns a hex-encoded SHA-256 fingerprint of a certificate.
+func CertificateFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/cicovic-andrija/dante/atlas"
+	"github.com/cicovic-andrija/dante/fakeatlas"
+)
+
+func main() {
+	var (
+		addr    = flag.String("addr", "localhost:8443", "Listen address")
+		apiPath = flag.String("api-path", atlas.DefaultAPIPath, "API path prefix")
+		key     = flag.String("key", "", "Required API key (empty to accept any)")
+		credits = flag.Int64("credits", fakeatlas.DefaultCredits, "Initial credit balance")
+		probes  = flag.Int("probes", fakeatlas.DefaultProbes, "Number of simulated probes")
+	)
+	flag.Parse()
+
+	srv := fakeatlas.NewServer(fakeatlas.Options{
+		APIPath: *apiPath,
+		Key:     *key,
+		Credits: *credits,
+		Probes:  *probes,
+	})
+
+	fmt.Printf("serving simulated Atlas API on http://%s%s\n", *addr, *apiPath)
+	if err := http.ListenAndServe(*addr, srv.Handler()); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
@@ -11,31 +11,84 @@ const (
 	MaxPortNumber = 65535
 	HTTPString    = "http"
 	HTTPSString   = "https"
+
+	StorageInfluxDB = "influxdb"
+	StorageSQLite   = "sqlite"
+
+	MinAPIKeyLength = 16
+
+	RoleViewer   = "viewer"
+	RoleOperator = "operator"
+	RoleAdmin    = "admin"
+)
+
+var (
+	// ValidRoles lists roles that can be assigned to API keys,
+	// in order of increasing privileges.
+	ValidRoles = []string{RoleViewer, RoleOperator, RoleAdmin}
 )
 
 // Config specifies server configuration.
 type Config struct {
-	Env    string       `json:"env"`
-	Net    Net          `json:"net"`
-	Atlas  AtlasConf    `json:"atlas"`
-	Influx InfluxDBConf `json:"influxdb"`
-	Log    Log          `json:"log"`
+	Env     string       `json:"env"`
+	Net     Net          `json:"net"`
+	Auth    AuthConf     `json:"auth"`
+	Atlas   AtlasConf    `json:"atlas"`
+	Fetch   FetchConf    `json:"fetch"`
+	Tasks   TasksConf    `json:"tasks"`
+	Storage string       `json:"storage"`
+	Influx  InfluxDBConf `json:"influxdb"`
+	SQLite  SQLiteConf   `json:"sqlite"`
+	Log     Log          `json:"log"`
 
 	path string `json:"-"`
 }
 
 // Net specifies a network configuration.
 type Net struct {
-	Protocol string `json:"protocol"`
-	DNSName  string `json:"dns_name"`
-	Port     int    `json:"port"`
+	Protocol string  `json:"protocol"`
+	DNSName  string  `json:"dns_name"`
+	Port     int     `json:"port"`
+	TLS      TLSConf `json:"tls"`
+}
+
+// TLSConf specifies certificate files of the service when it is served
+// over https. If a CA bundle is set, clients must present a certificate
+// signed by one of its certificate authorities (mutual TLS).
+// Files are read again when the service receives SIGHUP.
+type TLSConf struct {
+	CertFile     string `json:"cert_file"`
+	KeyFile      string `json:"key_file"`
+	ClientCAFile string `json:"client_ca_file"`
+}
+
+// AuthConf specifies the file with API keys of the service clients.
+// Each non-empty line of the file, except comments starting with #,
+// holds a client name, a role and a key, separated by whitespace.
+// Authentication is disabled if the file is not set.
+type AuthConf struct {
+	KeysFile string   `json:"keys_file"`
+	Keys     []APIKey `json:"-"`
+}
+
+// APIKey specifies a key with which a client authenticates,
+// and the role granted to the client.
+type APIKey struct {
+	Client string
+	Role   string
+	Key    string
 }
 
 // AtlasConf specifies configuration values
 // needed for interaction with the Atlas API.
+// In sandbox mode, requests are served by a simulated
+// in-process Atlas API instead.
 type AtlasConf struct {
-	Net  Net       `json:"net"`
-	Auth AtlasAuth `json:"auth"`
+	Net       Net           `json:"net"`
+	APIPath   string        `json:"api_path"`
+	Auth      AtlasAuth     `json:"auth"`
+	RateLimit RateLimitConf `json:"rate_limit"`
+	Sandbox   bool          `json:"sandbox"`
 }
 
 // AtlasAuth specifies configuration values
@@ -46,12 +99,36 @@ type AtlasAuth struct {
 	ValidateKey bool   `json:"validate_key"`
 }
 
+// RateLimitConf specifies limits shared by all requests sent
+// to the Atlas API. Zero values select defaults.
+type RateLimitConf struct {
+	RequestsPerSec float64 `json:"requests_per_sec"`
+	Burst          int     `json:"burst"`
+	MaxInFlight    int     `json:"max_in_flight"`
+}
+
+// FetchConf specifies bounds of the period in which measurement results
+// are fetched from the Atlas API. Zero values select defaults.
+type FetchConf struct {
+	MinPeriodSec int64 `json:"min_period_sec"`
+	MaxPeriodSec int64 `json:"max_period_sec"`
+}
+
+// TasksConf specifies how periodic tasks, such as fetching
+// measurement results, are run. Zero values select defaults.
+type TasksConf struct {
+	Workers    int   `json:"workers"`
+	TimeoutSec int64 `json:"timeout_sec"`
+}
+
 // InfluxDB specifies configuration values
 // needed for interaction with the InfluxDB database.
 type InfluxDBConf struct {
 	Organization string       `json:"organization"`
 	Net          Net          `json:"net"`
 	Auth         InfluxDBAuth `json:"auth"`
+	Batch        BatchConf    `json:"batch"`
+	Spool        SpoolConf    `json:"spool"`
 }
 
 // InfluxDBAuth specifies configuration values
@@ -61,6 +138,27 @@ type InfluxDBAuth struct {
 	Token     string `json:"-"`
 }
 
+// BatchConf specifies how measurement results are written
+// to the database in batches. Zero values select defaults.
+type BatchConf struct {
+	Size               int   `json:"size"`
+	FlushIntervalMilli int64 `json:"flush_interval_ms"`
+	MaxPendingBatches  int   `json:"max_pending_batches"`
+}
+
+// SpoolConf specifies a directory in which measurement results
+// are stored when they cannot be written to the database.
+// Spooling is disabled if the directory is not set.
+type SpoolConf struct {
+	Dir string `json:"dir"`
+}
+
+// SQLiteConf specifies configuration values
+// needed for storing data in an embedded SQLite database.
+type SQLiteConf struct {
+	Path string `json:"path"`
+}
+
 // Log specifies logging configuration.
 type Log struct {
 	Dir string `json:"dir"`
@@ -84,14 +182,29 @@ func (cfg *Config) Init() error {
 		return err
 	}
 
-	if cfg.Net.Protocol != HTTPString {
-		return fmt.Errorf("invalid protocol: http is the only one currently supported")
+	if cfg.Net.Protocol == HTTPSString {
+		if err = validateTLSConf(&cfg.Net.TLS); err != nil {
+			return err
+		}
+	}
+
+	cfg.Auth.Keys = nil
+	if cfg.Auth.KeysFile != "" {
+		keys, err := readAPIKeys(cfg.Auth.KeysFile)
+		if err != nil {
+			return fmt.Errorf("failed to read API keys: %v", err)
+		}
+		cfg.Auth.Keys = keys
 	}
 
 	if err = validateNetConf(&cfg.Atlas.Net); err != nil {
 		return err
 	}
 
+	if rl := cfg.Atlas.RateLimit; rl.RequestsPerSec < 0 || rl.Burst < 0 || rl.MaxInFlight < 0 {
+		return fmt.Errorf("invalid Atlas rate limit config: values cannot be negative")
+	}
+
 	cfg.Atlas.Auth.Key = ""
 	if cfg.Atlas.Auth.KeyFile != "" {
 		key, err := readKey(cfg.Atlas.Auth.KeyFile, cfg.Atlas.Auth.ValidateKey)
@@ -101,17 +214,32 @@ func (cfg *Config) Init() error {
 		cfg.Atlas.Auth.Key = key
 	}
 
-	if cfg.Influx.Organization == "" {
-		return fmt.Errorf("invalid InfluxDB organization: organization cannot be empty")
+	if cfg.Fetch.MinPeriodSec < 0 || cfg.Fetch.MaxPeriodSec < 0 {
+		return fmt.Errorf("invalid fetch config: periods cannot be negative")
+	}
+	if cfg.Fetch.MaxPeriodSec > 0 && cfg.Fetch.MinPeriodSec > cfg.Fetch.MaxPeriodSec {
+		return fmt.Errorf("invalid fetch config: minimum period greater than maximum period")
 	}
 
-	cfg.Influx.Auth.Token = ""
-	if cfg.Influx.Auth.TokenFile != "" {
-		token, err := readToken(cfg.Influx.Auth.TokenFile)
-		if err != nil {
-			return fmt.Errorf("failed to read InfluxDB token: %v", err)
+	if cfg.Tasks.Workers < 0 || cfg.Tasks.TimeoutSec < 0 {
+		return fmt.Errorf("invalid tasks config: values cannot be negative")
+	}
+
+	switch cfg.Storage {
+	case "":
+		cfg.Storage = StorageInfluxDB
+		fallthrough
+	case StorageInfluxDB:
+		if err = validateInfluxDBConf(&cfg.Influx); err != nil {
+			return err
+		}
+	case StorageSQLite:
+		if cfg.SQLite.Path == "" {
+			return fmt.Errorf("invalid SQLite database path: path cannot be empty")
 		}
-		cfg.Influx.Auth.Token = token
+	default:
+		return fmt.Errorf("invalid storage %q: supported are %s and %s",
+			cfg.Storage, StorageInfluxDB, StorageSQLite)
 	}
 
 	if finfo, statErr := os.Stat(cfg.Log.Dir); statErr != nil && os.IsNotExist(statErr) {
@@ -135,6 +263,31 @@ func (net *Net) GetURLBase() string {
 	return fmt.Sprintf("%s://%s:%d", net.Protocol, net.DNSName, net.Port)
 }
 
+func validateInfluxDBConf(influx *InfluxDBConf) error {
+	if influx.Organization == "" {
+		return fmt.Errorf("invalid InfluxDB organization: organization cannot be empty")
+	}
+
+	if err := validateNetConf(&influx.Net); err != nil {
+		return err
+	}
+
+	if influx.Batch.Size < 0 || influx.Batch.FlushIntervalMilli < 0 || influx.Batch.MaxPendingBatches < 0 {
+		return fmt.Errorf("invalid InfluxDB batch config: values cannot be negative")
+	}
+
+	influx.Auth.Token = ""
+	if influx.Auth.TokenFile != "" {
+		token, err := readToken(influx.Auth.TokenFile)
+		if err != nil {
+			return fmt.Errorf("failed to read InfluxDB token: %v", err)
+		}
+		influx.Auth.Token = token
+	}
+
+	return nil
+}
+
 func validateNetConf(net *Net) error {
 	const errorPrefix = "net config validation failed: "
 
@@ -152,3 +305,22 @@ func validateNetConf(net *Net) error {
 
 	return nil
 }
+
+func validateTLSConf(tls *TLSConf) error {
+	const errorPrefix = "TLS config validation failed: "
+
+	if tls.CertFile == "" || tls.KeyFile == "" {
+		return errors.New(errorPrefix + "certificate and key files must be set for https")
+	}
+
+	for _, path := range []string{tls.CertFile, tls.KeyFile, tls.ClientCAFile} {
+		if path == "" {
+			continue
+		}
+		if _, statErr := os.Stat(path); statErr != nil {
+			return fmt.Errorf("%sfile %q not accessible: %v", errorPrefix, path, statErr)
+		}
+	}
+
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/cicovic-andrija/dante/util"
 )
@@ -65,6 +66,55 @@ func readToken(path string) (token string, err error) {
 	return
 }
 
+func readAPIKeys(path string) (keys []APIKey, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close() // ignore returned error
+
+	var (
+		scanner = bufio.NewScanner(file)
+		seen    = make(map[string]bool)
+		lineNum = 0
+	)
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("line %d: expected client name, role and key", lineNum)
+		}
+		key := APIKey{Client: fields[0], Role: fields[1], Key: fields[2]}
+		if !util.SearchForString(key.Role, ValidRoles...) {
+			return nil, fmt.Errorf("line %d: role must be one of: %s", lineNum, strings.Join(ValidRoles, ", "))
+		}
+		if len(key.Key) < MinAPIKeyLength {
+			return nil, fmt.Errorf("line %d: key of client %q is shorter than %d characters",
+				lineNum, key.Client, MinAPIKeyLength)
+		}
+		if seen[key.Key] {
+			return nil, fmt.Errorf("line %d: key of client %q is already assigned", lineNum, key.Client)
+		}
+		seen[key.Key] = true
+
+		keys = append(keys, key)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("no keys found")
+	}
+
+	return
+}
+
 // Load reads configuration from a file.
 // Returns a populated Config struct, or an error. Both cannot be nil.
 func Load() (*Config, error) {
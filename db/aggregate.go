@@ -0,0 +1,207 @@
+package db
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// sample represents a single stored field value, as read by
+// backends that evaluate results queries in-process.
+type sample struct {
+	time  time.Time
+	value interface{}
+	tags  map[string]string
+}
+
+// evaluateResultsQuery evaluates a results query over samples of the queried
+// measurement and field, within the queried time range. Samples are filtered
+// by tags here. The result is equivalent to that of the Flux query built
+// for the same results query.
+func evaluateResultsQuery(q *ResultsQuery, samples []sample) ([]ResultPoint, error) {
+	series, _, _, err := q.selection()
+	if err != nil {
+		return nil, err
+	}
+
+	matched := make([]sample, 0, len(samples))
+	for _, smp := range samples {
+		if q.matchesTags(smp.tags) {
+			matched = append(matched, smp)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		return matched[i].time.Before(matched[j].time)
+	})
+
+	if q.Aggregate == AggregateNone {
+		limit := q.Limit
+		if limit <= 0 {
+			limit = DefaultResultsLimit
+		}
+		if len(matched) > limit {
+			matched = matched[:limit]
+		}
+
+		points := make([]ResultPoint, 0, len(matched))
+		for _, smp := range matched {
+			points = append(points, ResultPoint{
+				Time:  smp.time,
+				Value: smp.value,
+				Tags:  pickTags(smp.tags, resultTags),
+			})
+		}
+		return points, nil
+	}
+
+	aggregate, err := aggregateFunc(q.Aggregate)
+	if err != nil {
+		return nil, err
+	}
+
+	type groupWindow struct {
+		group string
+		time  time.Time
+	}
+
+	var (
+		keys   []groupWindow
+		tags   = make(map[string]map[string]string)
+		values = make(map[groupWindow][]float64)
+	)
+
+	for _, smp := range matched {
+		var v float64
+		if q.Aggregate == AggregateErrorRate {
+			v = series.errValue(smp.value)
+		} else if fv, ok := toFloat(smp.value); ok {
+			v = fv
+		} else if q.Aggregate != AggregateCount {
+			// non-numeric values can only be counted
+			continue
+		}
+
+		key := groupWindow{group: groupKey(smp.tags, q.GroupBy), time: q.windowTime(smp.time)}
+		if _, ok := values[key]; !ok {
+			keys = append(keys, key)
+			tags[key.group] = pickTags(smp.tags, q.GroupBy)
+		}
+		values[key] = append(values[key], v)
+	}
+
+	sort.SliceStable(keys, func(i, j int) bool {
+		if keys[i].group != keys[j].group {
+			return keys[i].group < keys[j].group
+		}
+		return keys[i].time.Before(keys[j].time)
+	})
+
+	points := make([]ResultPoint, 0, len(keys))
+	for _, key := range keys {
+		points = append(points, ResultPoint{
+			Time:  key.time,
+			Value: aggregate(values[key]),
+			Tags:  tags[key.group],
+		})
+	}
+	return points, nil
+}
+
+// Returns the time a sample is aggregated under: the stop time of its window,
+// or the stop time of the queried range if values are not windowed.
+func (q *ResultsQuery) windowTime(t time.Time) time.Time {
+	if q.Window <= 0 {
+		return q.Stop
+	}
+	stop := t.Truncate(q.Window).Add(q.Window)
+	if stop.After(q.Stop) {
+		stop = q.Stop
+	}
+	return stop
+}
+
+func aggregateFunc(aggregate string) (func([]float64) interface{}, error) {
+	switch aggregate {
+	case AggregateMean, AggregateErrorRate:
+		return func(values []float64) interface{} {
+			sum := 0.0
+			for _, v := range values {
+				sum += v
+			}
+			return sum / float64(len(values))
+		}, nil
+	case AggregateMedian:
+		return func(values []float64) interface{} { return quantile(values, 0.5) }, nil
+	case AggregateP95:
+		return func(values []float64) interface{} { return quantile(values, 0.95) }, nil
+	case AggregateP99:
+		return func(values []float64) interface{} { return quantile(values, 0.99) }, nil
+	case AggregateCount:
+		return func(values []float64) interface{} { return int64(len(values)) }, nil
+	default:
+		return nil, fmt.Errorf("invalid aggregate %q", aggregate)
+	}
+}
+
+// Returns the q-th quantile of values, interpolating between closest ranks.
+func quantile(values []float64, q float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	pos := q * float64(len(sorted)-1)
+	lower := int(math.Floor(pos))
+	upper := int(math.Ceil(pos))
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(pos-float64(lower))
+}
+
+func groupKey(tags map[string]string, groupBy []string) string {
+	parts := make([]string, 0, len(groupBy))
+	for _, tag := range groupBy {
+		parts = append(parts, tag+"="+tags[tag])
+	}
+	return strings.Join(parts, ",")
+}
+
+// Returns a subset of tags, or nil if none of the keys is present.
+func pickTags(tags map[string]string, keys []string) map[string]string {
+	var picked map[string]string
+	for _, key := range keys {
+		if v, ok := tags[key]; ok {
+			if picked == nil {
+				picked = make(map[string]string, len(keys))
+			}
+			picked[key] = v
+		}
+	}
+	return picked
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case bool:
+		return boolToFloat(v), true
+	default:
+		return 0, false
+	}
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
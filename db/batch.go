@@ -0,0 +1,257 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cicovic-andrija/dante/conf"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+// Default batching options, used for options not set in configuration.
+const (
+	DefaultBatchSize          = 500
+	DefaultFlushIntervalMilli = 1000
+	DefaultMaxPendingBatches  = 16
+)
+
+var errWriterClosed = errors.New("batch writer closed")
+
+// flushFunc writes a batch of data points to a bucket.
+type flushFunc func(bucketName string, dataPoints ...*write.Point) error
+
+// batchWriter collects data points per bucket, and writes them in batches
+// asynchronously, when a batch is full or when the flush interval elapses.
+// Full batches wait in a bounded queue; when the queue is full, adding
+// data points blocks until a batch is written (backpressure).
+// Errors of asynchronous writes are kept per bucket, until reported by flush.
+type batchWriter struct {
+	sync.Mutex
+	flushed *sync.Cond
+
+	size    int
+	flushFn flushFunc
+	observe WriteObserver
+
+	pending  map[string][]*write.Point
+	inFlight map[string]int
+	errs     map[string]error
+	closed   bool
+
+	// full batches that are being queued; close waits for them
+	adding sync.WaitGroup
+
+	queue chan pointBatch
+	quit  chan struct{}
+	done  chan struct{}
+}
+
+type pointBatch struct {
+	bucketName string
+	dataPoints []*write.Point
+}
+
+func newBatchWriter(cfg *conf.BatchConf, flushFn flushFunc) *batchWriter {
+	size := cfg.Size
+	if size <= 0 {
+		size = DefaultBatchSize
+	}
+	flushIntervalMilli := cfg.FlushIntervalMilli
+	if flushIntervalMilli <= 0 {
+		flushIntervalMilli = DefaultFlushIntervalMilli
+	}
+	maxPending := cfg.MaxPendingBatches
+	if maxPending <= 0 {
+		maxPending = DefaultMaxPendingBatches
+	}
+
+	b := &batchWriter{
+		size:     size,
+		flushFn:  flushFn,
+		pending:  make(map[string][]*write.Point),
+		inFlight: make(map[string]int),
+		errs:     make(map[string]error),
+		queue:    make(chan pointBatch, maxPending),
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	b.flushed = sync.NewCond(b)
+
+	go b.run(time.Duration(flushIntervalMilli) * time.Millisecond)
+
+	return b
+}
+
+// add adds data points to the pending batch of a bucket.
+// It blocks if the batch is full and the queue of full batches is full too.
+func (b *batchWriter) add(bucketName string, dataPoints ...*write.Point) error {
+	b.Lock()
+	if b.closed {
+		b.Unlock()
+		return errWriterClosed
+	}
+
+	b.pending[bucketName] = append(b.pending[bucketName], dataPoints...)
+	if len(b.pending[bucketName]) < b.size {
+		b.Unlock()
+		return nil
+	}
+
+	batch := b.take(bucketName)
+	b.adding.Add(1)
+	b.Unlock()
+
+	// the queue is read until all batches added before close are queued
+	b.queue <- batch
+	b.adding.Done()
+	return nil
+}
+
+// flush writes pending data points of a bucket, waits until all queued
+// batches of the bucket are written, and returns the first error of writes
+// to the bucket that failed since the last call.
+func (b *batchWriter) flush(bucketName string) error {
+	b.Lock()
+	batch := b.take(bucketName)
+	b.Unlock()
+
+	b.write(batch)
+
+	b.Lock()
+	defer b.Unlock()
+	for b.inFlight[bucketName] > 0 {
+		b.flushed.Wait()
+	}
+	err := b.errs[bucketName]
+	delete(b.errs, bucketName)
+	return err
+}
+
+// discard drops pending data points and errors of a bucket.
+// Queued batches are still written.
+func (b *batchWriter) discard(bucketName string) {
+	b.Lock()
+	delete(b.pending, bucketName)
+	delete(b.errs, bucketName)
+	b.Unlock()
+}
+
+// close stops accepting data points, and writes all pending
+// and queued data points.
+func (b *batchWriter) close() {
+	b.Lock()
+	if b.closed {
+		b.Unlock()
+		return
+	}
+	b.closed = true
+	b.Unlock()
+
+	close(b.quit)
+	<-b.done
+}
+
+func (b *batchWriter) run(flushInterval time.Duration) {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case batch := <-b.queue:
+			b.write(batch)
+		case <-ticker.C:
+			b.flushAll()
+		case <-b.quit:
+			// no batches are added after close, but batches that
+			// are being added in the meantime must still be queued
+			added := make(chan struct{})
+			go func() {
+				b.adding.Wait()
+				close(added)
+			}()
+			for {
+				select {
+				case batch := <-b.queue:
+					b.write(batch)
+				case <-added:
+					for len(b.queue) > 0 {
+						b.write(<-b.queue)
+					}
+					b.flushAll()
+					close(b.done)
+					return
+				}
+			}
+		}
+	}
+}
+
+// setObserver sets a function that is called with the outcome
+// of each write, once per measurement of the written data points.
+func (b *batchWriter) setObserver(observe WriteObserver) {
+	b.Lock()
+	b.observe = observe
+	b.Unlock()
+}
+
+func (b *batchWriter) flushAll() {
+	b.Lock()
+	batches := make([]pointBatch, 0, len(b.pending))
+	for bucketName := range b.pending {
+		batches = append(batches, b.take(bucketName))
+	}
+	b.Unlock()
+
+	for _, batch := range batches {
+		b.write(batch)
+	}
+}
+
+// Takes the pending data points of a bucket as a batch, and marks
+// the batch as in flight. Must be called with the lock held.
+func (b *batchWriter) take(bucketName string) pointBatch {
+	batch := pointBatch{bucketName: bucketName, dataPoints: b.pending[bucketName]}
+	delete(b.pending, bucketName)
+	b.inFlight[bucketName]++
+	return batch
+}
+
+func (b *batchWriter) write(batch pointBatch) {
+	var err error
+	if len(batch.dataPoints) > 0 {
+		err = b.flushFn(batch.bucketName, batch.dataPoints...)
+	}
+
+	b.Lock()
+	if err != nil && b.errs[batch.bucketName] == nil {
+		b.errs[batch.bucketName] = fmt.Errorf("failed to write %d data points: %v", len(batch.dataPoints), err)
+	}
+	b.inFlight[batch.bucketName]--
+	if b.inFlight[batch.bucketName] == 0 {
+		delete(b.inFlight, batch.bucketName)
+	}
+	observe := b.observe
+	b.Unlock()
+	b.flushed.Broadcast()
+
+	if observe != nil {
+		for _, measurement := range measurementNames(batch.dataPoints) {
+			observe(measurement, err)
+		}
+	}
+}
+
+// Returns distinct measurement names of data points.
+func measurementNames(dataPoints []*write.Point) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, p := range dataPoints {
+		if !seen[p.Name()] {
+			seen[p.Name()] = true
+			names = append(names, p.Name())
+		}
+	}
+	return names
+}
@@ -0,0 +1,228 @@
+package db
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cicovic-andrija/dante/conf"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+// testFlusher records written batches. Writes to buckets with an error set fail,
+// and writes block while the gate is closed.
+type testFlusher struct {
+	sync.Mutex
+	errs    map[string]error
+	batches map[string][]int
+	gate    chan struct{}
+	started chan string
+}
+
+func newTestFlusher() *testFlusher {
+	gate := make(chan struct{})
+	close(gate)
+	return &testFlusher{
+		errs:    make(map[string]error),
+		batches: make(map[string][]int),
+		gate:    gate,
+		started: make(chan string, 100),
+	}
+}
+
+func (f *testFlusher) flush(bucketName string, dataPoints ...*write.Point) error {
+	f.started <- bucketName
+	<-f.gate
+
+	f.Lock()
+	defer f.Unlock()
+	if err := f.errs[bucketName]; err != nil {
+		return err
+	}
+	f.batches[bucketName] = append(f.batches[bucketName], len(dataPoints))
+	return nil
+}
+
+// Returns sizes of batches written to a bucket.
+func (f *testFlusher) written(bucketName string) []int {
+	f.Lock()
+	defer f.Unlock()
+	return append([]int(nil), f.batches[bucketName]...)
+}
+
+// Waits until a write of a batch starts.
+func waitStarted(t *testing.T, f *testFlusher) {
+	select {
+	case <-f.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no batch written")
+	}
+}
+
+func TestBatchWriterSizeFlush(t *testing.T) {
+	f := newTestFlusher()
+	b := newBatchWriter(&conf.BatchConf{Size: 3, FlushIntervalMilli: time.Hour.Milliseconds()}, f.flush)
+	defer b.close()
+
+	if err := b.add("a", testSpoolPoints(2)...); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.add("a", testSpoolPoints(2)...); err != nil {
+		t.Fatal(err)
+	}
+	waitStarted(t, f)
+	if err := b.add("a", testSpoolPoints(1)...); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.flush("a"); err != nil {
+		t.Fatal(err)
+	}
+	// the full batch, and the rest written by flush
+	if batches := f.written("a"); len(batches) != 2 || batches[0]+batches[1] != 5 || batches[0]*batches[1] != 4 {
+		t.Errorf("batches = %v, want batches of 4 and 1", batches)
+	}
+}
+
+func TestBatchWriterIntervalFlush(t *testing.T) {
+	f := newTestFlusher()
+	b := newBatchWriter(&conf.BatchConf{Size: 100, FlushIntervalMilli: 10}, f.flush)
+	defer b.close()
+
+	if err := b.add("a", testSpoolPoints(2)...); err != nil {
+		t.Fatal(err)
+	}
+	waitStarted(t, f)
+	if err := b.flush("a"); err != nil {
+		t.Fatal(err)
+	}
+	if batches := f.written("a"); len(batches) != 1 || batches[0] != 2 {
+		t.Errorf("batches = %v, want [2]", batches)
+	}
+}
+
+// Adding data points blocks while the queue of full batches is full.
+func TestBatchWriterBackpressure(t *testing.T) {
+	f := newTestFlusher()
+	f.gate = make(chan struct{})
+	b := newBatchWriter(&conf.BatchConf{Size: 1, FlushIntervalMilli: time.Hour.Milliseconds(), MaxPendingBatches: 1}, f.flush)
+	defer b.close()
+
+	// the first batch is being written, and the second one is queued
+	if err := b.add("a", testSpoolPoints(1)...); err != nil {
+		t.Fatal(err)
+	}
+	waitStarted(t, f)
+	if err := b.add("a", testSpoolPoints(1)...); err != nil {
+		t.Fatal(err)
+	}
+
+	added := make(chan struct{})
+	go func() {
+		b.add("a", testSpoolPoints(1)...)
+		close(added)
+	}()
+	select {
+	case <-added:
+		t.Fatal("add did not block with the queue full")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(f.gate)
+	select {
+	case <-added:
+	case <-time.After(5 * time.Second):
+		t.Fatal("add blocked after batches were written")
+	}
+
+	if err := b.flush("a"); err != nil {
+		t.Fatal(err)
+	}
+	if batches := f.written("a"); len(batches) != 3 {
+		t.Errorf("batches = %v, want 3 batches", batches)
+	}
+}
+
+// Errors of asynchronous writes are reported once, by flush of the bucket.
+func TestBatchWriterErrors(t *testing.T) {
+	f := newTestFlusher()
+	f.errs["b"] = errors.New("write failed")
+	b := newBatchWriter(&conf.BatchConf{Size: 2, FlushIntervalMilli: time.Hour.Milliseconds()}, f.flush)
+	defer b.close()
+
+	var observed []error
+	var mu sync.Mutex
+	b.setObserver(func(measurement string, err error) {
+		mu.Lock()
+		observed = append(observed, err)
+		mu.Unlock()
+	})
+
+	for _, bucketName := range []string{"a", "b"} {
+		if err := b.add(bucketName, testSpoolPoints(2)...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := b.flush("a"); err != nil {
+		t.Errorf("flush(a) = %v, want nil", err)
+	}
+	if err := b.flush("b"); err == nil {
+		t.Error("flush(b) did not report the failed write")
+	}
+	if err := b.flush("b"); err != nil {
+		t.Errorf("flush(b) = %v after the error was reported", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	failed := 0
+	for _, err := range observed {
+		if err != nil {
+			failed++
+		}
+	}
+	if len(observed) != 2 || failed != 1 {
+		t.Errorf("observed %v, want one success and one failure", observed)
+	}
+}
+
+// close writes queued and pending data points, and rejects new ones.
+func TestBatchWriterCloseDrains(t *testing.T) {
+	f := newTestFlusher()
+	f.gate = make(chan struct{})
+	b := newBatchWriter(&conf.BatchConf{Size: 2, FlushIntervalMilli: time.Hour.Milliseconds(), MaxPendingBatches: 4}, f.flush)
+
+	for i := 0; i < 3; i++ {
+		if err := b.add("a", testSpoolPoints(2)...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.add("b", testSpoolPoints(1)...); err != nil {
+		t.Fatal(err)
+	}
+	waitStarted(t, f)
+
+	closed := make(chan struct{})
+	go func() {
+		b.close()
+		close(closed)
+	}()
+	close(f.gate)
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("close did not return")
+	}
+
+	if batches := f.written("a"); len(batches) != 3 {
+		t.Errorf("batches of a = %v, want 3 batches", batches)
+	}
+	if batches := f.written("b"); len(batches) != 1 || batches[0] != 1 {
+		t.Errorf("batches of b = %v, want [1]", batches)
+	}
+	if err := b.add("a", testSpoolPoints(1)...); err != errWriterClosed {
+		t.Errorf("add after close = %v, want %v", err, errWriterClosed)
+	}
+}
@@ -17,20 +17,51 @@ const (
 // It wraps the library-provided database client.
 type Client struct {
 	Org          *domain.Organization
-	MeasBucket   *domain.Bucket
 	SystemBucket *domain.Bucket
 
+	orgName      string
 	influxClient influxdb2.Client
+	batch        *batchWriter
+	spoolDir     string
+	spool        *spool
 }
 
+// Client is the InfluxDB storage backend.
+var (
+	_ Store       = (*Client)(nil)
+	_ AsyncWriter = (*Client)(nil)
+)
+
 // NewClient returns a new Client.
+// Measurement results are written in batches, as configured.
 func NewClient(cfg *conf.InfluxDBConf) *Client {
-	return &Client{
+	c := &Client{
+		orgName:      cfg.Organization,
 		influxClient: influxdb2.NewClient(cfg.Net.GetURLBase(), cfg.Auth.Token),
+		spoolDir:     cfg.Spool.Dir,
 	}
+	c.batch = newBatchWriter(&cfg.Batch, c.writeOrSpool)
+	return c
 }
 
-// Close closes the underlying library-provided database client.
+// Close writes pending measurement results
+// and closes the underlying library-provided database client.
 func (c *Client) Close() {
+	c.batch.close()
+	if c.spool != nil {
+		c.spool.close()
+	}
 	c.influxClient.Close()
 }
+
+// ObserveWrites sets a function that is called with the outcome of each
+// batched write of measurement results.
+func (c *Client) ObserveWrites(observe WriteObserver) {
+	c.batch.setObserver(observe)
+}
+
+// Flush writes pending measurement results to a bucket, and returns
+// an error if any write of results to the bucket failed since the last call.
+func (c *Client) Flush(bucketName string) error {
+	return c.batch.flush(bucketName)
+}
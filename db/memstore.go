@@ -0,0 +1,354 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+// MemStore is an in-memory storage backend, intended for tests and demos.
+// Stored data is lost when the process exits. Like in InfluxDB, a data point
+// replaces fields of an existing data point with the same measurement, tags
+// and timestamp.
+type MemStore struct {
+	sync.RWMutex
+
+	buckets map[string]map[string]*memPoint
+}
+
+type memPoint struct {
+	measurement string
+	tags        map[string]string
+	fields      map[string]interface{}
+	time        time.Time
+}
+
+// MemStore is an in-memory storage backend.
+var _ Store = (*MemStore)(nil)
+
+// NewMemStore returns a new MemStore.
+func NewMemStore() *MemStore {
+	return &MemStore{
+		buckets: make(map[string]map[string]*memPoint),
+	}
+}
+
+// Init creates the SystemBucket.
+func (m *MemStore) Init() error {
+	return m.EnsureBucket(SystemBucket)
+}
+
+// Close does nothing.
+func (m *MemStore) Close() {}
+
+// Health always reports that the store is healthy.
+func (m *MemStore) Health() (*HealthReport, error) {
+	return &HealthReport{Status: HealthStatusPass, Message: "in-memory store"}, nil
+}
+
+// Location returns a description of the store location.
+func (m *MemStore) Location() string {
+	return "memory"
+}
+
+// DataExplorerURL returns an empty string.
+func (m *MemStore) DataExplorerURL(bucket string) string {
+	return ""
+}
+
+// LookupBucket returns an error if a bucket does not exist.
+func (m *MemStore) LookupBucket(name string) error {
+	m.RLock()
+	defer m.RUnlock()
+	if _, ok := m.buckets[name]; !ok {
+		return bucketNotFoundErr(name)
+	}
+	return nil
+}
+
+// EnsureBucket creates a bucket if it does not exist.
+func (m *MemStore) EnsureBucket(name string) error {
+	m.Lock()
+	defer m.Unlock()
+	if _, ok := m.buckets[name]; !ok {
+		m.buckets[name] = make(map[string]*memPoint)
+	}
+	return nil
+}
+
+// DeleteBucket deletes a bucket and all data in it.
+func (m *MemStore) DeleteBucket(name string) error {
+	m.Lock()
+	defer m.Unlock()
+	if _, ok := m.buckets[name]; !ok {
+		return bucketNotFoundErr(name)
+	}
+	delete(m.buckets, name)
+	return nil
+}
+
+// WriteMeasurementMetadata writes measurement metadata to the SystemBucket.
+func (m *MemStore) WriteMeasurementMetadata(md MeasurementMetadata) error {
+	return m.write(SystemBucket, metadataPoint(md))
+}
+
+// QueryMeasurementMetadata reads measurement metadata from the SystemBucket.
+func (m *MemStore) QueryMeasurementMetadata() ([]MeasurementMetadata, error) {
+	md := []MeasurementMetadata{}
+	for _, p := range m.points(SystemBucket, MetadataMeasurement) {
+		md = append(md, MeasurementMetadata{
+			ID:            p.tags[tagID],
+			Description:   p.tags[tagDescription],
+			BackendIDsStr: p.tags[tagBackendIDs],
+		})
+	}
+	return md, nil
+}
+
+// WriteMeasurementTemplate writes a measurement template to the SystemBucket.
+func (m *MemStore) WriteMeasurementTemplate(tmpl MeasurementTemplate) error {
+	return m.write(SystemBucket, templatePoint(tmpl))
+}
+
+// DeleteMeasurementTemplate deletes a measurement template from the SystemBucket.
+func (m *MemStore) DeleteMeasurementTemplate(name string) error {
+	m.Lock()
+	defer m.Unlock()
+	for key, p := range m.buckets[SystemBucket] {
+		if p.measurement == TemplateMeasurement && p.tags[tagName] == name {
+			delete(m.buckets[SystemBucket], key)
+		}
+	}
+	return nil
+}
+
+// QueryMeasurementTemplates reads measurement templates from the SystemBucket.
+func (m *MemStore) QueryMeasurementTemplates() ([]MeasurementTemplate, error) {
+	templates := []MeasurementTemplate{}
+	for _, p := range m.points(SystemBucket, TemplateMeasurement) {
+		spec, ok := p.fields[fieldSpec].(string)
+		if !ok {
+			return nil, errTemplateCorrupted
+		}
+		templates = append(templates, MeasurementTemplate{Name: p.tags[tagName], Spec: spec})
+	}
+	return templates, nil
+}
+
+// WriteMeasurementCampaign writes a measurement campaign to the SystemBucket.
+func (m *MemStore) WriteMeasurementCampaign(camp MeasurementCampaign) error {
+	return m.write(SystemBucket, campaignPoint(camp))
+}
+
+// DeleteMeasurementCampaign deletes a measurement campaign from the SystemBucket.
+func (m *MemStore) DeleteMeasurementCampaign(name string) error {
+	m.Lock()
+	defer m.Unlock()
+	for key, p := range m.buckets[SystemBucket] {
+		if p.measurement == CampaignMeasurement && p.tags[tagName] == name {
+			delete(m.buckets[SystemBucket], key)
+		}
+	}
+	return nil
+}
+
+// QueryMeasurementCampaigns reads measurement campaigns from the SystemBucket.
+func (m *MemStore) QueryMeasurementCampaigns() ([]MeasurementCampaign, error) {
+	campaigns := []MeasurementCampaign{}
+	for _, p := range m.points(SystemBucket, CampaignMeasurement) {
+		state, ok := p.fields[fieldState].(string)
+		if !ok {
+			return nil, errCampaignCorrupted
+		}
+		campaigns = append(campaigns, MeasurementCampaign{Name: p.tags[tagName], State: state})
+	}
+	return campaigns, nil
+}
+
+// WriteCheckpoint writes a checkpoint of a backend measurement to the SystemBucket.
+func (m *MemStore) WriteCheckpoint(measID string, backendID int64, lastResultUnix int64) error {
+	return m.write(SystemBucket, checkpointPoint(measID, backendID, lastResultUnix))
+}
+
+// DeleteCheckpoints deletes checkpoints of all backend measurements
+// of a measurement from the SystemBucket.
+func (m *MemStore) DeleteCheckpoints(measID string) error {
+	m.Lock()
+	defer m.Unlock()
+	for key, p := range m.buckets[SystemBucket] {
+		if p.measurement == CheckpointMeasurement && p.tags[tagID] == measID {
+			delete(m.buckets[SystemBucket], key)
+		}
+	}
+	return nil
+}
+
+// QueryCheckpoints reads checkpoints of all backend measurements from the SystemBucket.
+func (m *MemStore) QueryCheckpoints() (map[int64]int64, error) {
+	checkpoints := make(map[int64]int64)
+	for _, p := range m.points(SystemBucket, CheckpointMeasurement) {
+		backendID, err := strconv.ParseInt(p.tags[tagBackendID], 10, 64)
+		if err != nil {
+			return nil, errCheckpointCorrupted
+		}
+		lastResultUnix, ok := p.fields[fieldLastResult].(int64)
+		if !ok {
+			return nil, errCheckpointCorrupted
+		}
+		checkpoints[backendID] = lastResultUnix
+	}
+	return checkpoints, nil
+}
+
+// WriteHTTPMeasurementResult writes an HTTP measurement result to a bucket.
+func (m *MemStore) WriteHTTPMeasurementResult(bucketName string, httpData *HTTPData) error {
+	return m.write(bucketName, httpPoints(httpData)...)
+}
+
+// WritePingMeasurementResult writes a ping measurement result to a bucket.
+func (m *MemStore) WritePingMeasurementResult(bucketName string, pingData *PingData) error {
+	return m.write(bucketName, pingPoints(pingData)...)
+}
+
+// WriteTracerouteMeasurementResult writes a traceroute measurement result to a bucket.
+func (m *MemStore) WriteTracerouteMeasurementResult(bucketName string, trData *TracerouteData) error {
+	return m.write(bucketName, traceroutePoints(trData)...)
+}
+
+// WriteDNSMeasurementResult writes a DNS measurement result to a bucket.
+func (m *MemStore) WriteDNSMeasurementResult(bucketName string, dnsData *DNSData) error {
+	return m.write(bucketName, dnsPoints(dnsData)...)
+}
+
+// WriteSSLCertMeasurementResult writes an sslcert measurement result to a bucket.
+func (m *MemStore) WriteSSLCertMeasurementResult(bucketName string, certData *SSLCertData) error {
+	return m.write(bucketName, sslCertPoints(certData)...)
+}
+
+// WriteNTPMeasurementResult writes an NTP measurement result to a bucket.
+func (m *MemStore) WriteNTPMeasurementResult(bucketName string, ntpData *NTPData) error {
+	return m.write(bucketName, ntpPoints(ntpData)...)
+}
+
+// Flush does nothing, as all writes are synchronous.
+func (m *MemStore) Flush(bucketName string) error {
+	return nil
+}
+
+// ReplaySpool does nothing, as results are never spooled.
+func (m *MemStore) ReplaySpool() (replayed int, remaining int, err error) {
+	return 0, 0, nil
+}
+
+// QueryResults runs a results query and returns the resulting data points.
+func (m *MemStore) QueryResults(q *ResultsQuery) ([]ResultPoint, error) {
+	_, measurement, field, err := q.selection()
+	if err != nil {
+		return nil, err
+	}
+
+	return evaluateResultsQuery(q, m.samples(q.Bucket, measurement, field, q.Start, q.Stop))
+}
+
+// WriteCreditBalance writes a credit balance data point to the SystemBucket.
+func (m *MemStore) WriteCreditBalance(creditBalance int64) error {
+	return m.write(SystemBucket, creditBalancePoint(creditBalance))
+}
+
+func (m *MemStore) write(bucketName string, dataPoints ...*write.Point) error {
+	m.Lock()
+	defer m.Unlock()
+
+	bucket, ok := m.buckets[bucketName]
+	if !ok {
+		return bucketNotFoundErr(bucketName)
+	}
+
+	for _, dp := range dataPoints {
+		p := &memPoint{
+			measurement: dp.Name(),
+			tags:        make(map[string]string, len(dp.TagList())),
+			fields:      make(map[string]interface{}, len(dp.FieldList())),
+			time:        dp.Time(),
+		}
+		for _, tag := range dp.TagList() {
+			p.tags[tag.Key] = tag.Value
+		}
+		for _, field := range dp.FieldList() {
+			p.fields[field.Key] = field.Value
+		}
+
+		key := seriesKey(p)
+		if existing, ok := bucket[key]; ok {
+			for k, v := range p.fields {
+				existing.fields[k] = v
+			}
+		} else {
+			bucket[key] = p
+		}
+	}
+
+	return nil
+}
+
+// Returns copies of data points of a measurement in a bucket,
+// as fields of stored data points are replaced by writes.
+// Tags of returned points must not be modified.
+func (m *MemStore) points(bucketName string, measurement string) []*memPoint {
+	m.RLock()
+	defer m.RUnlock()
+
+	points := []*memPoint{}
+	for _, p := range m.buckets[bucketName] {
+		if p.measurement != measurement {
+			continue
+		}
+		fields := make(map[string]interface{}, len(p.fields))
+		for k, v := range p.fields {
+			fields[k] = v
+		}
+		points = append(points, &memPoint{measurement: p.measurement, tags: p.tags, fields: fields, time: p.time})
+	}
+	return points
+}
+
+// Returns values of a field of a measurement in a bucket, in time range [start, stop).
+// Tags of returned samples must not be modified.
+func (m *MemStore) samples(bucketName string, measurement string, field string, start time.Time, stop time.Time) []sample {
+	m.RLock()
+	defer m.RUnlock()
+
+	samples := []sample{}
+	for _, p := range m.buckets[bucketName] {
+		if p.measurement != measurement || p.time.Before(start) || !p.time.Before(stop) {
+			continue
+		}
+		if v, ok := p.fields[field]; ok {
+			samples = append(samples, sample{time: p.time, value: v, tags: p.tags})
+		}
+	}
+	return samples
+}
+
+func seriesKey(p *memPoint) string {
+	return fmt.Sprintf("%s,%s@%d", p.measurement, tagsKey(p.tags), p.time.UnixNano())
+}
+
+// Returns a string that identifies a series of a measurement by its tags.
+func tagsKey(tags map[string]string) string {
+	pairs := make([]string, 0, len(tags))
+	for k, v := range tags {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func bucketNotFoundErr(name string) error {
+	return fmt.Errorf("bucket %q not found", name)
+}
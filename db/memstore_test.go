@@ -0,0 +1,54 @@
+package db
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// Queries run concurrently with writes that replace fields of
+// the queried data points. Run with -race.
+func TestMemStoreConcurrentQuery(t *testing.T) {
+	m := NewMemStore()
+	if err := m.Init(); err != nil {
+		t.Fatal(err)
+	}
+	const bucket = "results"
+	if err := m.EnsureBucket(bucket); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			m.WriteHTTPMeasurementResult(bucket, &HTTPData{
+				ProbeID:       1000,
+				RoundTripTime: float64(i),
+				StatusCode:    200,
+				Timestamp:     time.Unix(100, 0),
+			})
+			m.WriteCheckpoint("a", 1, int64(i))
+		}
+	}()
+
+	q := &ResultsQuery{Bucket: bucket, Type: HTTPMeasurement, Start: time.Unix(0, 0), Stop: time.Unix(200, 0)}
+	for i := 0; i < 1000; i++ {
+		if _, err := m.QueryResults(q); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := m.QueryCheckpoints(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+
+	points, err := m.QueryResults(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 1 || points[0].Value != float64(999) {
+		t.Errorf("points = %v, want the last written value", points)
+	}
+}
@@ -3,6 +3,8 @@ package db
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/influxdata/influxdb-client-go/v2/domain"
@@ -12,8 +14,8 @@ import (
 const (
 	SystemBucket = "system"
 
-	HealthEndpointPath = "/health"
-	HealthStatusPass   = "pass"
+	HealthStatusPass = "pass"
+	HealthStatusFail = "fail"
 )
 
 // MeasurementMetadata specifies measurement details
@@ -24,6 +26,22 @@ type MeasurementMetadata struct {
 	BackendIDsStr string
 }
 
+// MeasurementTemplate specifies a named, reusable fragment of
+// a measurement request that will be persisted in the database.
+// Spec holds the fragment encoded as JSON.
+type MeasurementTemplate struct {
+	Name string
+	Spec string
+}
+
+// MeasurementCampaign specifies a named, recurring measurement
+// that will be persisted in the database. State holds the campaign
+// definition and its history encoded as JSON.
+type MeasurementCampaign struct {
+	Name  string
+	State string
+}
+
 // HealthReport represents a response object returned
 // by the database API health endpoint.
 type HealthReport struct {
@@ -57,21 +75,78 @@ func (c *Client) EnsureOrganization(name string) (org *domain.Organization, err
 	return nil, err
 }
 
-// LookupBucket looks up a bucket by name and returns it.
-func (c *Client) LookupBucket(name string) (bck *domain.Bucket, err error) {
+// Init ensures that the configured organization and the SystemBucket exist,
+// and opens the spool, if configured.
+func (c *Client) Init() (err error) {
+	if c.spoolDir != "" {
+		if c.spool, err = openSpool(c.spoolDir); err != nil {
+			return fmt.Errorf("failed to open spool: %v", err)
+		}
+	}
+
+	if c.Org, err = c.EnsureOrganization(c.orgName); err != nil {
+		return
+	}
+
+	c.SystemBucket, err = c.ensureBucket(SystemBucket)
+	return
+}
+
+// LookupBucket looks up a bucket by name.
+// Returns an error if the bucket does not exist.
+func (c *Client) LookupBucket(name string) error {
+	_, err := c.findBucket(name)
+	return err
+}
+
+// EnsureBucket looks up a bucket by name.
+// If the bucket does not exists, it is created.
+// It assumes that c.Org is not nil.
+func (c *Client) EnsureBucket(name string) error {
+	_, err := c.ensureBucket(name)
+	return err
+}
+
+// DeleteBucket deletes a bucket.
+func (c *Client) DeleteBucket(name string) error {
 	var (
 		bckAPI = c.influxClient.BucketsAPI()
 	)
 
+	bck, err := c.findBucket(name)
+	if err != nil {
+		return err
+	}
+
+	c.batch.discard(name)
+
 	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
 	defer cancel()
-	bck, err = bckAPI.FindBucketByName(ctx, name)
-	return
+	return bckAPI.DeleteBucket(ctx, bck)
 }
 
-// EnsureBucket looks up a bucket by name and returns it.
-// If the bucket does not exists, it is created.
-func (c *Client) EnsureBucket(name string) (bck *domain.Bucket, err error) {
+// Health checks the health of the database.
+func (c *Client) Health() (*HealthReport, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+	check, err := c.influxClient.Health(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &HealthReport{Status: string(check.Status)}
+	if check.Message != nil {
+		report.Message = *check.Message
+	}
+	return report, nil
+}
+
+// Location returns the database API base URL.
+func (c *Client) Location() string {
+	return c.ServerURL()
+}
+
+func (c *Client) findBucket(name string) (bck *domain.Bucket, err error) {
 	var (
 		bckAPI = c.influxClient.BucketsAPI()
 	)
@@ -79,12 +154,17 @@ func (c *Client) EnsureBucket(name string) (bck *domain.Bucket, err error) {
 	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
 	defer cancel()
 	bck, err = bckAPI.FindBucketByName(ctx, name)
+	return
+}
+
+func (c *Client) ensureBucket(name string) (bck *domain.Bucket, err error) {
+	bck, err = c.findBucket(name)
 	if err == nil {
 		return
 	}
 
 	if isNotFoundErr(err) {
-		bck, err = c.CreateBucket(name)
+		bck, err = c.createBucket(name)
 		if err == nil {
 			return
 		}
@@ -93,9 +173,8 @@ func (c *Client) EnsureBucket(name string) (bck *domain.Bucket, err error) {
 	return nil, err
 }
 
-// CreateBucket creates a bucket.
 // It assumes that c.Org is not nil.
-func (c *Client) CreateBucket(name string) (bck *domain.Bucket, err error) {
+func (c *Client) createBucket(name string) (bck *domain.Bucket, err error) {
 	var (
 		bckAPI = c.influxClient.BucketsAPI()
 	)
@@ -110,27 +189,11 @@ func (c *Client) CreateBucket(name string) (bck *domain.Bucket, err error) {
 	return
 }
 
-// DeleteBucket deletes a bucket.
-func (c *Client) DeleteBucket(bck *domain.Bucket) error {
-	var (
-		bckAPI = c.influxClient.BucketsAPI()
-	)
-
-	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
-	defer cancel()
-	return bckAPI.DeleteBucket(ctx, bck)
-}
-
 // ServerURL returns the database API base URL.
 func (c *Client) ServerURL() string {
 	return c.influxClient.ServerURL()
 }
 
-// HealthEndpoint the database API health endpoint.
-func (c *Client) HealthEndpoint() string {
-	return c.ServerURL() + HealthEndpointPath
-}
-
 // DataExplorerURL returns a formatted URL to InfluxDB Data Explorer,
 // for a specified bucket.
 func (c *Client) DataExplorerURL(bucket string) string {
@@ -145,3 +208,43 @@ func (c *Client) DataExplorerURL(bucket string) string {
 func isNotFoundErr(err error) bool {
 	return err != nil && strings.Contains(err.Error(), "not found")
 }
+
+// Error codes of write requests rejected because of the data points they carry,
+// which are rejected again whenever they are written.
+var rejectedWriteErrCodes = []string{
+	"invalid",
+	"unprocessable entity",
+	"empty value",
+	"request too large",
+}
+
+// Returns true if the database rejected written data points, as opposed to
+// failing to accept them for the time being (network errors, 5xx and 429
+// responses), or refusing the credentials. The blocking write API returns
+// errors as text only, either "code: message" or, for responses that carry
+// no error code, "<status line>: message".
+func isRejectedWriteErr(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	msg := err.Error()
+	for _, code := range rejectedWriteErrCodes {
+		if strings.HasPrefix(msg, code+":") {
+			return true
+		}
+	}
+
+	if len(msg) > 4 && msg[3] == ' ' {
+		if status, convErr := strconv.Atoi(msg[:3]); convErr == nil {
+			switch status {
+			case http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound,
+				http.StatusRequestTimeout, http.StatusTooManyRequests:
+				return false
+			}
+			return status >= 400 && status < 500
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,342 @@
+package db
+
+import (
+	"strconv"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+// Data point constructors, shared by all storage backends.
+
+func httpPoints(httpData *HTTPData) []*write.Point {
+	// specify data point
+	dataPoint := influxdb2.NewPoint(
+		HTTPMeasurement,
+		map[string]string{
+			tagBackendID: strconv.FormatInt(httpData.BackendID, 10),
+			tagAF:        strconv.FormatInt(int64(httpData.AddressFamily), 10),
+			tagProbeID:   strconv.FormatInt(httpData.ProbeID, 10),
+			tagASN:       strconv.FormatInt(httpData.ASN, 10),
+			tagCountry:   httpData.Country,
+			tagTarget:    httpData.Target,
+			tagTargetIP:  httpData.TargetIP,
+		},
+		map[string]interface{}{
+			fieldRT:         httpData.RoundTripTime,
+			fieldBodySize:   httpData.BodySize,
+			fieldHeaderSize: httpData.HeaderSize,
+			fieldStatusCode: httpData.StatusCode,
+		},
+		httpData.Timestamp,
+	)
+
+	return []*write.Point{dataPoint}
+}
+
+func pingPoints(pingData *PingData) []*write.Point {
+	tags := map[string]string{
+		tagBackendID: strconv.FormatInt(pingData.BackendID, 10),
+		tagAF:        strconv.FormatInt(int64(pingData.AddressFamily), 10),
+		tagProbeID:   strconv.FormatInt(pingData.ProbeID, 10),
+		tagASN:       strconv.FormatInt(pingData.ASN, 10),
+		tagCountry:   pingData.Country,
+		tagTarget:    pingData.Target,
+		tagTargetIP:  pingData.TargetIP,
+	}
+
+	fields := map[string]interface{}{
+		fieldSent:     pingData.Sent,
+		fieldReceived: pingData.Received,
+		fieldLoss:     pingData.LossPercentage(),
+	}
+	if pingData.Received > 0 {
+		fields[fieldRTTMin] = pingData.MinRTT
+		fields[fieldRTTAvg] = pingData.AvgRTT
+		fields[fieldRTTMax] = pingData.MaxRTT
+	}
+
+	dataPoints := make([]*write.Point, 0, len(pingData.RTTs)+1)
+	dataPoints = append(dataPoints, influxdb2.NewPoint(
+		PingMeasurement,
+		tags,
+		fields,
+		pingData.Timestamp,
+	))
+
+	for i, rtt := range pingData.RTTs {
+		if rtt < 0 {
+			continue
+		}
+		packetTags := withTags(tags, tagPacket, strconv.Itoa(i))
+		dataPoints = append(dataPoints, influxdb2.NewPoint(
+			PingPacketMeasurement,
+			packetTags,
+			map[string]interface{}{fieldRT: rtt},
+			pingData.Timestamp,
+		))
+	}
+
+	return dataPoints
+}
+
+func traceroutePoints(trData *TracerouteData) []*write.Point {
+	tags := map[string]string{
+		tagBackendID: strconv.FormatInt(trData.BackendID, 10),
+		tagAF:        strconv.FormatInt(int64(trData.AddressFamily), 10),
+		tagProbeID:   strconv.FormatInt(trData.ProbeID, 10),
+		tagASN:       strconv.FormatInt(trData.ASN, 10),
+		tagCountry:   trData.Country,
+		tagTarget:    trData.Target,
+		tagTargetIP:  trData.TargetIP,
+	}
+
+	fields := map[string]interface{}{
+		fieldHops:    len(trData.Hops),
+		fieldReached: trData.DestinationReached,
+	}
+	if trData.ASPathKnown {
+		fields[fieldASPath] = trData.ASPath
+		if trData.ASPathChangeKnown {
+			fields[fieldPathChange] = trData.ASPathChanged
+		}
+	}
+
+	dataPoints := make([]*write.Point, 0, len(trData.Hops)+1)
+	dataPoints = append(dataPoints, influxdb2.NewPoint(
+		TracerouteMeasurement,
+		tags,
+		fields,
+		trData.Timestamp,
+	))
+
+	for _, hop := range trData.Hops {
+		hopTags := withTags(tags, tagHop, strconv.FormatInt(hop.Hop, 10), tagHopIP, hop.IP)
+		fields := map[string]interface{}{fieldLoss: hop.LossPercentage()}
+		if hop.Received > 0 {
+			fields[fieldRT] = hop.RTT
+		}
+		dataPoints = append(dataPoints, influxdb2.NewPoint(
+			TracerouteHopMeasurement,
+			hopTags,
+			fields,
+			trData.Timestamp,
+		))
+	}
+
+	return dataPoints
+}
+
+func dnsPoints(dnsData *DNSData) []*write.Point {
+	tags := map[string]string{
+		tagBackendID: strconv.FormatInt(dnsData.BackendID, 10),
+		tagAF:        strconv.FormatInt(int64(dnsData.AddressFamily), 10),
+		tagProbeID:   strconv.FormatInt(dnsData.ProbeID, 10),
+		tagASN:       strconv.FormatInt(dnsData.ASN, 10),
+		tagCountry:   dnsData.Country,
+		tagTarget:    dnsData.Target,
+		tagTargetIP:  dnsData.TargetIP,
+		tagResolver:  dnsData.Resolver,
+	}
+
+	fields := map[string]interface{}{fieldError: dnsData.Error}
+	if dnsData.Error == "" {
+		fields[fieldRT] = dnsData.ResponseTime
+		fields[fieldSize] = dnsData.ResponseSize
+		fields[fieldRCode] = dnsData.RCode
+		fields[fieldAnswers] = len(dnsData.Answers)
+	}
+
+	dataPoints := make([]*write.Point, 0, len(dnsData.Answers)+1)
+	dataPoints = append(dataPoints, influxdb2.NewPoint(
+		DNSMeasurement,
+		tags,
+		fields,
+		dnsData.Timestamp,
+	))
+
+	for _, answer := range dnsData.Answers {
+		answerTags := withTags(tags, tagRecordType, answer.Type, tagRecordName, answer.Name)
+		dataPoints = append(dataPoints, influxdb2.NewPoint(
+			DNSAnswerMeasurement,
+			answerTags,
+			map[string]interface{}{
+				fieldData: answer.Data,
+				fieldTTL:  answer.TTL,
+			},
+			dnsData.Timestamp,
+		))
+	}
+
+	return dataPoints
+}
+
+func sslCertPoints(certData *SSLCertData) []*write.Point {
+	fields := map[string]interface{}{fieldError: certData.Error}
+	if certData.Error == "" {
+		fields[fieldRT] = certData.HandshakeTime
+		fields[fieldTTC] = certData.ConnectTime
+		fields[fieldVersion] = certData.TLSVersion
+		fields[fieldSubject] = certData.Subject
+		fields[fieldIssuer] = certData.Issuer
+		fields[fieldNotBefore] = certData.NotBefore.Unix()
+		fields[fieldNotAfter] = certData.NotAfter.Unix()
+		fields[fieldDaysLeft] = certData.DaysUntilExpiry()
+	}
+
+	dataPoint := influxdb2.NewPoint(
+		SSLCertMeasurement,
+		map[string]string{
+			tagBackendID:   strconv.FormatInt(certData.BackendID, 10),
+			tagAF:          strconv.FormatInt(int64(certData.AddressFamily), 10),
+			tagProbeID:     strconv.FormatInt(certData.ProbeID, 10),
+			tagASN:         strconv.FormatInt(certData.ASN, 10),
+			tagCountry:     certData.Country,
+			tagTarget:      certData.Target,
+			tagTargetIP:    certData.TargetIP,
+			tagFingerprint: certData.Fingerprint,
+		},
+		fields,
+		certData.Timestamp,
+	)
+
+	return []*write.Point{dataPoint}
+}
+
+func ntpPoints(ntpData *NTPData) []*write.Point {
+	tags := map[string]string{
+		tagBackendID: strconv.FormatInt(ntpData.BackendID, 10),
+		tagAF:        strconv.FormatInt(int64(ntpData.AddressFamily), 10),
+		tagProbeID:   strconv.FormatInt(ntpData.ProbeID, 10),
+		tagASN:       strconv.FormatInt(ntpData.ASN, 10),
+		tagCountry:   ntpData.Country,
+		tagTarget:    ntpData.Target,
+		tagTargetIP:  ntpData.TargetIP,
+	}
+
+	sent := int64(len(ntpData.Packets))
+	offset, rtt, received := ntpData.Averages()
+	fields := map[string]interface{}{
+		fieldSent:     sent,
+		fieldReceived: received,
+		fieldLoss:     lossPercentage(sent, received),
+		fieldStratum:  ntpData.Stratum,
+		fieldRefID:    ntpData.ReferenceID,
+	}
+	if received > 0 {
+		fields[fieldOffsetAvg] = offset
+		fields[fieldRTTAvg] = rtt
+	}
+
+	dataPoints := make([]*write.Point, 0, len(ntpData.Packets)+1)
+	dataPoints = append(dataPoints, influxdb2.NewPoint(
+		NTPMeasurement,
+		tags,
+		fields,
+		ntpData.Timestamp,
+	))
+
+	for i, packet := range ntpData.Packets {
+		if packet.TimedOut {
+			continue
+		}
+		dataPoints = append(dataPoints, influxdb2.NewPoint(
+			NTPPacketMeasurement,
+			withTags(tags, tagPacket, strconv.Itoa(i)),
+			map[string]interface{}{
+				fieldOffset: packet.Offset,
+				fieldRT:     packet.RTT,
+			},
+			ntpData.Timestamp,
+		))
+	}
+
+	return dataPoints
+}
+
+func metadataPoint(md MeasurementMetadata) *write.Point {
+	dataPoint := influxdb2.NewPoint(
+		MetadataMeasurement,
+		map[string]string{
+			tagID:          md.ID,
+			tagDescription: md.Description,
+			tagBackendIDs:  md.BackendIDsStr,
+		},
+		map[string]interface{}{
+			"dummy-value": 42,
+		},
+		nullTimestamp,
+	)
+
+	return dataPoint
+}
+
+func templatePoint(tmpl MeasurementTemplate) *write.Point {
+	dataPoint := influxdb2.NewPoint(
+		TemplateMeasurement,
+		map[string]string{
+			tagName: tmpl.Name,
+		},
+		map[string]interface{}{
+			fieldSpec: tmpl.Spec,
+		},
+		nullTimestamp,
+	)
+
+	return dataPoint
+}
+
+func campaignPoint(camp MeasurementCampaign) *write.Point {
+	dataPoint := influxdb2.NewPoint(
+		CampaignMeasurement,
+		map[string]string{
+			tagName: camp.Name,
+		},
+		map[string]interface{}{
+			fieldState: camp.State,
+		},
+		nullTimestamp,
+	)
+
+	return dataPoint
+}
+
+func checkpointPoint(measID string, backendID int64, lastResultUnix int64) *write.Point {
+	dataPoint := influxdb2.NewPoint(
+		CheckpointMeasurement,
+		map[string]string{
+			tagID:        measID,
+			tagBackendID: strconv.FormatInt(backendID, 10),
+		},
+		map[string]interface{}{
+			fieldLastResult: lastResultUnix,
+		},
+		nullTimestamp,
+	)
+
+	return dataPoint
+}
+
+func creditBalancePoint(creditBalance int64) *write.Point {
+	dataPoint := influxdb2.NewPoint(
+		CreditBalanceMeasurement,
+		nil, /* tags */
+		map[string]interface{}{fieldValue: creditBalance},
+		time.Now(),
+	)
+
+	return dataPoint
+}
+
+// Returns a copy of tags extended with additional key-value pairs.
+func withTags(tags map[string]string, kv ...string) map[string]string {
+	extended := make(map[string]string, len(tags)+len(kv)/2)
+	for k, v := range tags {
+		extended[k] = v
+	}
+	for i := 0; i+1 < len(kv); i += 2 {
+		extended[kv[i]] = kv[i+1]
+	}
+	return extended
+}
@@ -0,0 +1,46 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+// RTTs of a ping without replies, reported as -1 by Atlas, are not written.
+func TestPingPointsWithoutReplies(t *testing.T) {
+	tests := []struct {
+		received int64
+		hasRTT   bool
+	}{
+		{0, false},
+		{2, true},
+	}
+
+	for _, test := range tests {
+		points := pingPoints(&PingData{
+			ProbeID:   1000,
+			MinRTT:    -1,
+			AvgRTT:    -1,
+			MaxRTT:    -1,
+			Sent:      3,
+			Received:  test.received,
+			RTTs:      []float64{-1, -1, -1},
+			Timestamp: time.Unix(100, 0),
+		})
+		if len(points) != 1 {
+			t.Fatalf("%d points, want 1", len(points))
+		}
+
+		fields := make(map[string]bool)
+		for _, field := range points[0].FieldList() {
+			fields[field.Key] = true
+		}
+		for _, field := range []string{fieldRTTMin, fieldRTTAvg, fieldRTTMax} {
+			if fields[field] != test.hasRTT {
+				t.Errorf("received %d: field %s written = %t, want %t", test.received, field, fields[field], test.hasRTT)
+			}
+		}
+		if !fields[fieldLoss] {
+			t.Errorf("received %d: field %s not written", test.received, fieldLoss)
+		}
+	}
+}
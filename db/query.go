@@ -4,10 +4,155 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/cicovic-andrija/dante/util"
 	"github.com/influxdata/influxdb-client-go/v2/api"
 )
 
+// Aggregate functions supported in results queries.
+const (
+	AggregateNone      = ""
+	AggregateMean      = "mean"
+	AggregateMedian    = "median"
+	AggregateP95       = "p95"
+	AggregateP99       = "p99"
+	AggregateCount     = "count"
+	AggregateErrorRate = "error-rate"
+
+	// DefaultResultsLimit is the maximum number of raw data points
+	// returned by a results query, unless specified otherwise.
+	DefaultResultsLimit = 10000
+)
+
+// Aggregates and tags that can be used in results queries.
+var (
+	ValidAggregates = []string{
+		AggregateMean, AggregateMedian, AggregateP95,
+		AggregateP99, AggregateCount, AggregateErrorRate,
+	}
+	ValidGroupByTags = []string{tagProbeID, tagCountry, tagASN, tagTarget, tagAF}
+)
+
+// resultSeries specifies which measurement field is read by default for
+// a measurement type, and how failed results are recognized in error rate
+// aggregates: errExpr (in Flux) and errValue (in Go) map a value of the error
+// field to 1.0 for a failed result, and to 0.0 otherwise. Fields of the
+// measurement that can be read instead are either numeric, or other fields
+// that cannot be aggregated.
+type resultSeries struct {
+	measurement    string
+	field          string
+	errMeasurement string
+	errField       string
+	errExpr        string
+	errValue       func(v interface{}) float64
+	numericFields  []string
+	otherFields    []string
+}
+
+// Measurement types are named the same as the measurements
+// their results are stored in.
+var resultSeriesByType = map[string]resultSeries{
+	HTTPMeasurement: {
+		HTTPMeasurement, fieldRT,
+		HTTPMeasurement, fieldStatusCode, `if r._value >= 400 or r._value == 0 then 1.0 else 0.0`,
+		func(v interface{}) float64 {
+			code, _ := toFloat(v)
+			return boolToFloat(code >= 400 || code == 0)
+		},
+		[]string{fieldRT, fieldBodySize, fieldHeaderSize, fieldStatusCode},
+		nil,
+	},
+	PingMeasurement: {
+		PingMeasurement, fieldRTTAvg,
+		PingMeasurement, fieldLoss, `r._value / 100.0`,
+		func(v interface{}) float64 {
+			loss, _ := toFloat(v)
+			return loss / 100
+		},
+		[]string{fieldRTTMin, fieldRTTAvg, fieldRTTMax, fieldSent, fieldReceived, fieldLoss},
+		nil,
+	},
+	TracerouteMeasurement: {
+		TracerouteHopMeasurement, fieldRT,
+		TracerouteMeasurement, fieldReached, `if r._value then 0.0 else 1.0`,
+		func(v interface{}) float64 {
+			reached, _ := v.(bool)
+			return boolToFloat(!reached)
+		},
+		[]string{fieldRT, fieldLoss},
+		nil,
+	},
+	DNSMeasurement: {
+		DNSMeasurement, fieldRT,
+		DNSMeasurement, fieldError, `if r._value != "" then 1.0 else 0.0`,
+		func(v interface{}) float64 {
+			return boolToFloat(v != "")
+		},
+		[]string{fieldRT, fieldSize, fieldAnswers},
+		[]string{fieldRCode, fieldError},
+	},
+	SSLCertMeasurement: {
+		SSLCertMeasurement, fieldRT,
+		SSLCertMeasurement, fieldError, `if r._value != "" then 1.0 else 0.0`,
+		func(v interface{}) float64 {
+			return boolToFloat(v != "")
+		},
+		[]string{fieldRT, fieldTTC, fieldNotBefore, fieldNotAfter, fieldDaysLeft},
+		[]string{fieldVersion, fieldSubject, fieldIssuer, fieldError},
+	},
+	NTPMeasurement: {
+		NTPMeasurement, fieldOffsetAvg,
+		NTPMeasurement, fieldLoss, `r._value / 100.0`,
+		func(v interface{}) float64 {
+			loss, _ := toFloat(v)
+			return loss / 100
+		},
+		[]string{fieldOffsetAvg, fieldRTTAvg, fieldSent, fieldReceived, fieldLoss, fieldStratum},
+		[]string{fieldRefID},
+	},
+}
+
+// ResultFields returns the fields of results of a measurement type that can be
+// read by results queries: numeric fields, which can also be aggregated,
+// and other fields, which can only be read as raw data points.
+func ResultFields(measType string) (numeric []string, other []string) {
+	series := resultSeriesByType[measType]
+	return series.numericFields, series.otherFields
+}
+
+// ResultsQuery specifies a query for measurement results stored in a bucket.
+// Empty filters match all values. If Aggregate is AggregateNone, raw data points
+// are returned, otherwise values are aggregated per Window (or over the whole
+// time range if Window is 0), in groups defined by GroupBy tags.
+type ResultsQuery struct {
+	Bucket    string
+	Type      string
+	Field     string
+	Start     time.Time
+	Stop      time.Time
+	ProbeIDs  []string
+	Countries []string
+	ASNs      []string
+	Targets   []string
+	Aggregate string
+	Window    time.Duration
+	GroupBy   []string
+	Limit     int
+}
+
+// ResultPoint represents a single data point returned by a results query.
+// Tags contain only the tags the data point is grouped by,
+// or all measurement tags for raw data points.
+type ResultPoint struct {
+	Time  time.Time         `json:"time"`
+	Value interface{}       `json:"value"`
+	Tags  map[string]string `json:"tags,omitempty"`
+}
+
 var (
 	mdQuery = fmt.Sprintf(
 		`from(bucket:"%s")|>range(start:0,stop:1)|>filter(fn:(r)=>r["_measurement"]=="%s")`,
@@ -15,7 +160,31 @@ var (
 		MetadataMeasurement,
 	)
 
-	errCorrupted = errors.New("measurement metadata corrupted")
+	checkpointQuery = fmt.Sprintf(
+		`from(bucket:"%s")|>range(start:0,stop:1)|>filter(fn:(r)=>r["_measurement"]=="%s" and r["_field"]=="%s")`,
+		SystemBucket,
+		CheckpointMeasurement,
+		fieldLastResult,
+	)
+
+	templateQuery = fmt.Sprintf(
+		`from(bucket:"%s")|>range(start:0,stop:1)|>filter(fn:(r)=>r["_measurement"]=="%s" and r["_field"]=="%s")`,
+		SystemBucket,
+		TemplateMeasurement,
+		fieldSpec,
+	)
+
+	campaignQuery = fmt.Sprintf(
+		`from(bucket:"%s")|>range(start:0,stop:1)|>filter(fn:(r)=>r["_measurement"]=="%s" and r["_field"]=="%s")`,
+		SystemBucket,
+		CampaignMeasurement,
+		fieldState,
+	)
+
+	errCorrupted           = errors.New("measurement metadata corrupted")
+	errCheckpointCorrupted = errors.New("checkpoint corrupted")
+	errTemplateCorrupted   = errors.New("measurement template corrupted")
+	errCampaignCorrupted   = errors.New("measurement campaign corrupted")
 )
 
 // QueryMeasurementMetadata reads measurement metadata from the SystemBucket.
@@ -54,3 +223,282 @@ func (c *Client) QueryMeasurementMetadata() ([]MeasurementMetadata, error) {
 
 	return md, nil
 }
+
+// QueryMeasurementTemplates reads measurement templates from the SystemBucket.
+// It assumes c.Org is not nil.
+func (c *Client) QueryMeasurementTemplates() ([]MeasurementTemplate, error) {
+	var (
+		queryAPI = c.influxClient.QueryAPI(c.Org.Name)
+		result   *api.QueryTableResult
+		ok       bool
+		err      error
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+	if result, err = queryAPI.Query(ctx, templateQuery); err != nil {
+		return nil, err
+	}
+
+	templates := []MeasurementTemplate{}
+	for result.Next() {
+		tmpl := MeasurementTemplate{}
+		if tmpl.Name, ok = result.Record().ValueByKey(tagName).(string); !ok {
+			return nil, errTemplateCorrupted
+		}
+		if tmpl.Spec, ok = result.Record().Value().(string); !ok {
+			return nil, errTemplateCorrupted
+		}
+		templates = append(templates, tmpl)
+	}
+	if result.Err() != nil {
+		return nil, result.Err()
+	}
+
+	return templates, nil
+}
+
+// QueryMeasurementCampaigns reads measurement campaigns from the SystemBucket.
+// It assumes c.Org is not nil.
+func (c *Client) QueryMeasurementCampaigns() ([]MeasurementCampaign, error) {
+	var (
+		queryAPI = c.influxClient.QueryAPI(c.Org.Name)
+		result   *api.QueryTableResult
+		ok       bool
+		err      error
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+	if result, err = queryAPI.Query(ctx, campaignQuery); err != nil {
+		return nil, err
+	}
+
+	campaigns := []MeasurementCampaign{}
+	for result.Next() {
+		camp := MeasurementCampaign{}
+		if camp.Name, ok = result.Record().ValueByKey(tagName).(string); !ok {
+			return nil, errCampaignCorrupted
+		}
+		if camp.State, ok = result.Record().Value().(string); !ok {
+			return nil, errCampaignCorrupted
+		}
+		campaigns = append(campaigns, camp)
+	}
+	if result.Err() != nil {
+		return nil, result.Err()
+	}
+
+	return campaigns, nil
+}
+
+// QueryCheckpoints reads checkpoints of all backend measurements
+// from the SystemBucket, mapped by backend measurement ID.
+// It assumes c.Org is not nil.
+func (c *Client) QueryCheckpoints() (map[int64]int64, error) {
+	var (
+		queryAPI = c.influxClient.QueryAPI(c.Org.Name)
+		result   *api.QueryTableResult
+		err      error
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+	if result, err = queryAPI.Query(ctx, checkpointQuery); err != nil {
+		return nil, err
+	}
+
+	checkpoints := make(map[int64]int64)
+	for result.Next() {
+		backendIDStr, ok := result.Record().ValueByKey(tagBackendID).(string)
+		if !ok {
+			return nil, errCheckpointCorrupted
+		}
+		backendID, err := strconv.ParseInt(backendIDStr, 10, 64)
+		if err != nil {
+			return nil, errCheckpointCorrupted
+		}
+		lastResultUnix, ok := result.Record().Value().(int64)
+		if !ok {
+			return nil, errCheckpointCorrupted
+		}
+		checkpoints[backendID] = lastResultUnix
+	}
+	if result.Err() != nil {
+		return nil, result.Err()
+	}
+
+	return checkpoints, nil
+}
+
+// QueryResults runs a results query and returns the resulting data points.
+// It assumes c.Org is not nil.
+func (c *Client) QueryResults(q *ResultsQuery) ([]ResultPoint, error) {
+	var (
+		queryAPI = c.influxClient.QueryAPI(c.Org.Name)
+		result   *api.QueryTableResult
+		query    string
+		err      error
+	)
+
+	if query, err = q.flux(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+	if result, err = queryAPI.Query(ctx, query); err != nil {
+		return nil, err
+	}
+
+	points := []ResultPoint{}
+	for result.Next() {
+		record := result.Record()
+		point := ResultPoint{
+			Time:  record.Time(),
+			Value: record.Value(),
+		}
+		if point.Time.IsZero() {
+			// aggregated over the whole time range
+			point.Time = record.Stop()
+		}
+		for _, tag := range resultTags {
+			if v, ok := record.ValueByKey(tag).(string); ok {
+				if point.Tags == nil {
+					point.Tags = make(map[string]string)
+				}
+				point.Tags[tag] = v
+			}
+		}
+		points = append(points, point)
+	}
+	if result.Err() != nil {
+		return nil, result.Err()
+	}
+
+	return points, nil
+}
+
+// Tags reported in results query data points.
+var resultTags = []string{tagBackendID, tagProbeID, tagASN, tagCountry, tagTarget, tagTargetIP, tagAF}
+
+// Returns the result series of the queried measurement type,
+// and the measurement and field the query reads.
+func (q *ResultsQuery) selection() (series resultSeries, measurement string, field string, err error) {
+	series, ok := resultSeriesByType[q.Type]
+	if !ok {
+		err = fmt.Errorf("results of measurement type %q cannot be queried", q.Type)
+		return
+	}
+
+	measurement, field = series.measurement, series.field
+	if q.Aggregate == AggregateErrorRate {
+		measurement, field = series.errMeasurement, series.errField
+	} else if q.Field != "" {
+		field = q.Field
+	}
+	return
+}
+
+// Returns a flag indicating whether tags match the tag filters of the query.
+func (q *ResultsQuery) matchesTags(tags map[string]string) bool {
+	filters := []struct {
+		tag    string
+		values []string
+	}{
+		{tagProbeID, q.ProbeIDs},
+		{tagCountry, q.Countries},
+		{tagASN, q.ASNs},
+		{tagTarget, q.Targets},
+	}
+
+	for _, filter := range filters {
+		if len(filter.values) > 0 && !util.SearchForString(tags[filter.tag], filter.values...) {
+			return false
+		}
+	}
+	return true
+}
+
+func (q *ResultsQuery) flux() (string, error) {
+	series, measurement, field, err := q.selection()
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `from(bucket:%s)`, fluxString(q.Bucket))
+	fmt.Fprintf(&b, `|>range(start:%s,stop:%s)`,
+		q.Start.UTC().Format(time.RFC3339), q.Stop.UTC().Format(time.RFC3339))
+	fmt.Fprintf(&b, `|>filter(fn:(r)=>r["_measurement"]==%s and r["_field"]==%s)`,
+		fluxString(measurement), fluxString(field))
+
+	writeTagFilter(&b, tagProbeID, q.ProbeIDs)
+	writeTagFilter(&b, tagCountry, q.Countries)
+	writeTagFilter(&b, tagASN, q.ASNs)
+	writeTagFilter(&b, tagTarget, q.Targets)
+
+	if q.Aggregate == AggregateNone {
+		limit := q.Limit
+		if limit <= 0 {
+			limit = DefaultResultsLimit
+		}
+		fmt.Fprintf(&b, `|>group()|>sort(columns:["_time"])|>limit(n:%d)`, limit)
+		return b.String(), nil
+	}
+
+	groupColumns := make([]string, 0, len(q.GroupBy))
+	for _, tag := range q.GroupBy {
+		groupColumns = append(groupColumns, fluxString(tag))
+	}
+	fmt.Fprintf(&b, `|>group(columns:[%s])`, strings.Join(groupColumns, ","))
+
+	// aggregate function for windows, and a call for the whole time range
+	var fn, call string
+	switch q.Aggregate {
+	case AggregateMean:
+		fn, call = "mean", "mean()"
+	case AggregateMedian:
+		fn, call = "median", "median()"
+	case AggregateP95:
+		fn, call = "(column, tables=<-) => tables |> quantile(q: 0.95, column: column)", "quantile(q: 0.95)"
+	case AggregateP99:
+		fn, call = "(column, tables=<-) => tables |> quantile(q: 0.99, column: column)", "quantile(q: 0.99)"
+	case AggregateCount:
+		fn, call = "count", "count()"
+	case AggregateErrorRate:
+		fmt.Fprintf(&b, `|>map(fn:(r)=>({r with _value: %s}))`, series.errExpr)
+		fn, call = "mean", "mean()"
+	default:
+		return "", fmt.Errorf("invalid aggregate %q", q.Aggregate)
+	}
+
+	if q.Window > 0 {
+		fmt.Fprintf(&b, `|>aggregateWindow(every:%ds,fn:%s,createEmpty:false)`, int64(q.Window.Seconds()), fn)
+	} else {
+		fmt.Fprintf(&b, `|>%s`, call)
+	}
+
+	return b.String(), nil
+}
+
+// Writes a filter that matches any of the values of a tag.
+func writeTagFilter(b *strings.Builder, tag string, values []string) {
+	if len(values) == 0 {
+		return
+	}
+
+	conds := make([]string, 0, len(values))
+	for _, v := range values {
+		conds = append(conds, fmt.Sprintf(`r[%s]==%s`, fluxString(tag), fluxString(v)))
+	}
+	fmt.Fprintf(b, `|>filter(fn:(r)=>%s)`, strings.Join(conds, " or "))
+}
+
+// Returns a Flux string literal.
+func fluxString(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "${", `\${`)
+	return `"` + s + `"`
+}
@@ -0,0 +1,269 @@
+package db
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+// Spool file names.
+const (
+	spoolFileName         = "spool.lp"
+	spoolReplayFileName   = "spool.lp.replay"
+	spoolRejectedFileName = "spool.lp.rejected"
+
+	// maximum number of lines written to the database in one request during replay
+	spoolReplayBatchSize = 1000
+)
+
+// spool is an append-only file that stores data points which could not be
+// written to the database, so that they can be written later. Each line holds
+// a bucket name and a data point in line protocol, separated by a tab.
+type spool struct {
+	sync.Mutex
+	replaying sync.Mutex
+
+	dir   string
+	file  *os.File
+	lines int
+}
+
+// Returns a spool backed by files in dir, creating the directory if needed.
+// Lines left by a previous process are replayed too.
+func openSpool(dir string) (*spool, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	sp := &spool{dir: dir}
+	for _, name := range []string{spoolFileName, spoolReplayFileName} {
+		n, err := countLines(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		sp.lines += n
+	}
+
+	return sp, nil
+}
+
+// Returns the path to the spool file.
+func (sp *spool) path() string {
+	return filepath.Join(sp.dir, spoolFileName)
+}
+
+// size returns the number of spooled data points.
+func (sp *spool) size() int {
+	sp.Lock()
+	defer sp.Unlock()
+	return sp.lines
+}
+
+// append stores data points of a bucket, and syncs the file to disk.
+func (sp *spool) append(bucketName string, dataPoints ...*write.Point) error {
+	var b strings.Builder
+	for _, dp := range dataPoints {
+		b.WriteString(bucketName)
+		b.WriteByte('\t')
+		write.PointToLineProtocolBuffer(dp, &b, time.Nanosecond)
+	}
+
+	sp.Lock()
+	defer sp.Unlock()
+	return sp.appendLocked(b.String(), len(dataPoints))
+}
+
+// Must be called with the lock held.
+func (sp *spool) appendLocked(lines string, n int) (err error) {
+	if sp.file == nil {
+		sp.file, err = os.OpenFile(sp.path(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return
+		}
+	}
+
+	if _, err = sp.file.WriteString(lines); err != nil {
+		return
+	}
+	if err = sp.file.Sync(); err != nil {
+		return
+	}
+
+	sp.lines += n
+	return
+}
+
+// replay writes spooled data points using writeFn, which receives lines
+// in line protocol. Lines of buckets that no longer exist are dropped,
+// lines the database rejected are moved to the rejected file to be inspected,
+// and lines that could not be written for other reasons are spooled again.
+// Returns the number of data points written.
+func (sp *spool) replay(writeFn func(bucketName string, lines ...string) error) (replayed int, err error) {
+	sp.replaying.Lock()
+	defer sp.replaying.Unlock()
+
+	replayPath := filepath.Join(sp.dir, spoolReplayFileName)
+
+	// move spooled lines aside, so that appends can continue meanwhile;
+	// if a previous replay was interrupted, its file is replayed first
+	sp.Lock()
+	if _, statErr := os.Stat(replayPath); os.IsNotExist(statErr) {
+		if sp.file != nil {
+			sp.file.Close()
+			sp.file = nil
+		}
+		if err = os.Rename(sp.path(), replayPath); err != nil {
+			sp.Unlock()
+			if os.IsNotExist(err) {
+				return 0, nil
+			}
+			return 0, err
+		}
+	}
+	sp.Unlock()
+
+	byBucket, order, err := readSpoolFile(replayPath)
+	if err != nil {
+		return 0, err
+	}
+
+	var failedLines []string
+	for _, bucketName := range order {
+		lines := byBucket[bucketName]
+		for start := 0; start < len(lines); start += spoolReplayBatchSize {
+			end := start + spoolReplayBatchSize
+			if end > len(lines) {
+				end = len(lines)
+			}
+
+			// once a write fails, do not try the rest
+			if err != nil {
+				failedLines = append(failedLines, spoolLines(bucketName, lines[start:end])...)
+				continue
+			}
+
+			writeErr := writeFn(bucketName, lines[start:end]...)
+			switch {
+			case writeErr == nil:
+				replayed += end - start
+			case isNotFoundErr(writeErr):
+			case isRejectedWriteErr(writeErr):
+				if rejectErr := sp.reject(spoolLines(bucketName, lines[start:end])); rejectErr != nil {
+					err = fmt.Errorf("%v (failed to move rejected data points aside: %v)", writeErr, rejectErr)
+					failedLines = append(failedLines, spoolLines(bucketName, lines[start:end])...)
+				}
+			default:
+				err = writeErr
+				failedLines = append(failedLines, spoolLines(bucketName, lines[start:end])...)
+			}
+		}
+	}
+
+	sp.Lock()
+	defer sp.Unlock()
+
+	if len(failedLines) > 0 {
+		if appendErr := sp.appendLocked(strings.Join(failedLines, ""), len(failedLines)); appendErr != nil {
+			// keep the replay file, it is replayed again the next time
+			return replayed, fmt.Errorf("failed to spool %d data points again: %v", len(failedLines), appendErr)
+		}
+	}
+	if removeErr := os.Remove(replayPath); removeErr != nil {
+		return replayed, removeErr
+	}
+
+	// data points of deleted buckets and malformed lines were dropped
+	lines, countErr := countLines(sp.path())
+	if countErr != nil {
+		return replayed, countErr
+	}
+	sp.lines = lines
+	return replayed, err
+}
+
+// reject appends spooled lines the database rejected to the rejected file,
+// and syncs the file to disk.
+func (sp *spool) reject(lines []string) error {
+	file, err := os.OpenFile(filepath.Join(sp.dir, spoolRejectedFileName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err = file.WriteString(strings.Join(lines, "")); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// close closes the spool file.
+func (sp *spool) close() {
+	sp.Lock()
+	defer sp.Unlock()
+	if sp.file != nil {
+		sp.file.Close()
+		sp.file = nil
+	}
+}
+
+// Reads a spool file, and returns lines in line protocol grouped by bucket,
+// and bucket names in order of appearance.
+func readSpoolFile(path string) (map[string][]string, []string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	var (
+		byBucket = make(map[string][]string)
+		order    []string
+		scanner  = bufio.NewScanner(file)
+	)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), "\t", 2)
+		if len(fields) != 2 {
+			// skip a line possibly cut short by a crash
+			continue
+		}
+		if _, ok := byBucket[fields[0]]; !ok {
+			order = append(order, fields[0])
+		}
+		byBucket[fields[0]] = append(byBucket[fields[0]], fields[1])
+	}
+
+	return byBucket, order, scanner.Err()
+}
+
+func spoolLines(bucketName string, lines []string) []string {
+	spooled := make([]string, len(lines))
+	for i, line := range lines {
+		spooled[i] = bucketName + "\t" + line + "\n"
+	}
+	return spooled
+}
+
+func countLines(path string) (int, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	n := 0
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		n++
+	}
+	return n, scanner.Err()
+}
@@ -0,0 +1,231 @@
+package db
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+func testSpoolPoints(n int) []*write.Point {
+	points := make([]*write.Point, n)
+	for i := range points {
+		points[i] = write.NewPoint(
+			HTTPMeasurement,
+			map[string]string{tagProbeID: "1000"},
+			map[string]interface{}{"rt": float64(i)},
+			time.Unix(int64(100+i), 0),
+		)
+	}
+	return points
+}
+
+// recordingWriter records lines written during replay,
+// and fails writes to buckets with an error set.
+type recordingWriter struct {
+	errs    map[string]error
+	written map[string][]string
+}
+
+func newRecordingWriter() *recordingWriter {
+	return &recordingWriter{errs: make(map[string]error), written: make(map[string][]string)}
+}
+
+func (w *recordingWriter) write(bucketName string, lines ...string) error {
+	if err := w.errs[bucketName]; err != nil {
+		return err
+	}
+	w.written[bucketName] = append(w.written[bucketName], lines...)
+	return nil
+}
+
+func TestSpoolAppendReplay(t *testing.T) {
+	sp, err := openSpool(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sp.close()
+
+	if err := sp.append("a", testSpoolPoints(3)...); err != nil {
+		t.Fatal(err)
+	}
+	if err := sp.append("b", testSpoolPoints(2)...); err != nil {
+		t.Fatal(err)
+	}
+	if n := sp.size(); n != 5 {
+		t.Fatalf("size = %d, want 5", n)
+	}
+
+	w := newRecordingWriter()
+	replayed, err := sp.replay(w.write)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayed != 5 || len(w.written["a"]) != 3 || len(w.written["b"]) != 2 {
+		t.Errorf("replayed %d, written %v", replayed, w.written)
+	}
+	if !strings.HasPrefix(w.written["a"][0], HTTPMeasurement+",") {
+		t.Errorf("unexpected line %q", w.written["a"][0])
+	}
+	if n := sp.size(); n != 0 {
+		t.Errorf("size = %d after replay, want 0", n)
+	}
+
+	// nothing left to replay
+	if replayed, err := sp.replay(w.write); replayed != 0 || err != nil {
+		t.Errorf("replay = (%d, %v), want (0, nil)", replayed, err)
+	}
+}
+
+// Once a write fails, the rest of the lines are spooled again,
+// and written by the next replay.
+func TestSpoolReplayPartialFailure(t *testing.T) {
+	sp, err := openSpool(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sp.close()
+
+	for _, bucketName := range []string{"a", "b", "c"} {
+		if err := sp.append(bucketName, testSpoolPoints(2)...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := newRecordingWriter()
+	w.errs["b"] = errors.New("unavailable: service temporarily unavailable")
+	replayed, err := sp.replay(w.write)
+	if err == nil {
+		t.Fatal("replay did not fail")
+	}
+	if replayed != 2 || len(w.written["a"]) != 2 || len(w.written["c"]) != 0 {
+		t.Errorf("replayed %d, written %v", replayed, w.written)
+	}
+	if n := sp.size(); n != 4 {
+		t.Errorf("size = %d, want 4", n)
+	}
+
+	delete(w.errs, "b")
+	replayed, err = sp.replay(w.write)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayed != 4 || len(w.written["b"]) != 2 || len(w.written["c"]) != 2 {
+		t.Errorf("replayed %d, written %v", replayed, w.written)
+	}
+	if n := sp.size(); n != 0 {
+		t.Errorf("size = %d, want 0", n)
+	}
+}
+
+// Lines of a replay interrupted by a crash are replayed before lines spooled later.
+func TestSpoolInterruptedReplay(t *testing.T) {
+	dir := t.TempDir()
+	lines := strings.Join(spoolLines("a", []string{"http rt=1 100", "http rt=2 200"}), "") + "a\thttp rt=3"
+	if err := os.WriteFile(filepath.Join(dir, spoolReplayFileName), []byte(lines), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	sp, err := openSpool(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sp.close()
+
+	if err := sp.append("b", testSpoolPoints(1)...); err != nil {
+		t.Fatal(err)
+	}
+	if n := sp.size(); n != 4 {
+		t.Fatalf("size = %d, want 4", n)
+	}
+
+	w := newRecordingWriter()
+	if _, err := sp.replay(w.write); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.written["a"]) != 3 || len(w.written["b"]) != 0 {
+		t.Errorf("written %v, want the interrupted replay", w.written)
+	}
+	if n := sp.size(); n != 1 {
+		t.Errorf("size = %d, want 1", n)
+	}
+
+	if _, err := sp.replay(w.write); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.written["b"]) != 1 {
+		t.Errorf("written %v, want lines spooled later", w.written)
+	}
+}
+
+// Lines of deleted buckets are dropped, and lines the database rejects are
+// set aside, without failing the replay.
+func TestSpoolReplayDropsPermanentFailures(t *testing.T) {
+	dir := t.TempDir()
+	sp, err := openSpool(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sp.close()
+
+	for _, bucketName := range []string{"deleted", "rejected", "a"} {
+		if err := sp.append(bucketName, testSpoolPoints(2)...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := newRecordingWriter()
+	w.errs["deleted"] = errors.New(`not found: bucket "deleted" not found`)
+	w.errs["rejected"] = errors.New("unprocessable entity: failure writing points to database: partial write")
+	replayed, err := sp.replay(w.write)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayed != 2 || len(w.written["a"]) != 2 {
+		t.Errorf("replayed %d, written %v", replayed, w.written)
+	}
+	if n := sp.size(); n != 0 {
+		t.Errorf("size = %d, want 0", n)
+	}
+
+	rejected, err := os.ReadFile(filepath.Join(dir, spoolRejectedFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	byBucket, order, err := readSpoolFile(filepath.Join(dir, spoolRejectedFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(order) != 1 || len(byBucket["rejected"]) != 2 {
+		t.Errorf("rejected lines:\n%s", rejected)
+	}
+}
+
+func TestIsRejectedWriteErr(t *testing.T) {
+	tests := map[string]bool{
+		"invalid: unable to parse 'http rt=': missing field value": true,
+		"unprocessable entity: failure writing points to database": true,
+		"request too large: points batch is too large":             true,
+		"400 Bad Request: ":                                           true,
+		"413 Request Entity Too Large: ":                              true,
+		"not found: bucket \"a\" not found":                           false,
+		"unauthorized: unauthorized access":                           false,
+		"401 Unauthorized: ":                                          false,
+		"too many requests: exceeded rate limit":                      false,
+		"429 Too Many Requests: ":                                     false,
+		"unavailable: service temporarily unavailable":                false,
+		"internal error: unexpected error writing points to database": false,
+		"502 Bad Gateway: ":                                           false,
+		"dial tcp 127.0.0.1:8086: connect: connection refused":        false,
+		"context deadline exceeded":                                   false,
+	}
+	for msg, want := range tests {
+		if got := isRejectedWriteErr(errors.New(msg)); got != want {
+			t.Errorf("isRejectedWriteErr(%q) = %t, want %t", msg, got, want)
+		}
+	}
+}
@@ -0,0 +1,544 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/cicovic-andrija/dante/conf"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+
+	// register the sqlite3 database/sql driver
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// SQLite schema. Timestamps are stored as Unix nanoseconds.
+// HTTP results, the most common ones, are stored in a dedicated table,
+// and data points of all other measurements in a generic results table.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS buckets (
+	name TEXT PRIMARY KEY
+);
+
+CREATE TABLE IF NOT EXISTS measurement_metadata (
+	id          TEXT PRIMARY KEY,
+	description TEXT NOT NULL,
+	backend_ids TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS measurement_templates (
+	name TEXT PRIMARY KEY,
+	spec TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS measurement_campaigns (
+	name  TEXT PRIMARY KEY,
+	state TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS checkpoints (
+	backend_id     INTEGER PRIMARY KEY,
+	measurement_id TEXT NOT NULL,
+	last_result    INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS credit_balance (
+	time    INTEGER PRIMARY KEY,
+	balance INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS http_results (
+	bucket      TEXT NOT NULL,
+	time        INTEGER NOT NULL,
+	backend_id  INTEGER NOT NULL,
+	af          INTEGER NOT NULL,
+	probe_id    INTEGER NOT NULL,
+	asn         INTEGER NOT NULL,
+	country     TEXT NOT NULL,
+	target      TEXT NOT NULL,
+	target_ip   TEXT NOT NULL,
+	rt          REAL NOT NULL,
+	body_size   INTEGER NOT NULL,
+	header_size INTEGER NOT NULL,
+	status_code INTEGER NOT NULL,
+	PRIMARY KEY (bucket, backend_id, probe_id, time)
+);
+
+CREATE INDEX IF NOT EXISTS http_results_time ON http_results (bucket, time);
+
+CREATE TABLE IF NOT EXISTS results (
+	bucket      TEXT NOT NULL,
+	measurement TEXT NOT NULL,
+	series      TEXT NOT NULL,
+	time        INTEGER NOT NULL,
+	tags        TEXT NOT NULL,
+	fields      TEXT NOT NULL,
+	PRIMARY KEY (bucket, measurement, series, time)
+);
+
+CREATE INDEX IF NOT EXISTS results_time ON results (bucket, measurement, time);
+`
+
+// Columns of the http_results table that hold HTTPMeasurement fields.
+var httpFieldColumns = map[string]string{
+	fieldRT:         "rt",
+	fieldBodySize:   "body_size",
+	fieldHeaderSize: "header_size",
+	fieldStatusCode: "status_code",
+}
+
+// SQLiteStore is a storage backend that keeps data in an embedded
+// SQLite database file, intended for single-node deployments.
+type SQLiteStore struct {
+	path string
+	db   *sql.DB
+}
+
+// SQLiteStore is an embedded SQLite storage backend.
+var _ Store = (*SQLiteStore)(nil)
+
+// NewSQLiteStore returns a new SQLiteStore.
+func NewSQLiteStore(cfg *conf.SQLiteConf) *SQLiteStore {
+	return &SQLiteStore{path: cfg.Path}
+}
+
+// Init opens the database file, creating it if it does not exist,
+// and ensures that the schema and the SystemBucket exist.
+func (s *SQLiteStore) Init() (err error) {
+	// WAL allows reads concurrent to a write, and the busy timeout
+	// makes concurrent writers wait instead of failing
+	dsn := fmt.Sprintf("file:%s?_journal_mode=WAL&_busy_timeout=%d",
+		url.PathEscape(s.path), DefaultTimeout.Milliseconds())
+	if s.db, err = sql.Open("sqlite3", dsn); err != nil {
+		return
+	}
+
+	if _, err = s.db.Exec(sqliteSchema); err != nil {
+		return fmt.Errorf("failed to create schema: %v", err)
+	}
+
+	return s.EnsureBucket(SystemBucket)
+}
+
+// Close closes the database.
+func (s *SQLiteStore) Close() {
+	if s.db != nil {
+		s.db.Close()
+	}
+}
+
+// Health checks whether the database can be queried.
+func (s *SQLiteStore) Health() (*HealthReport, error) {
+	var result int
+	if err := s.db.QueryRow("SELECT 1").Scan(&result); err != nil {
+		return nil, err
+	}
+	return &HealthReport{Status: HealthStatusPass, Message: "ready for queries and writes"}, nil
+}
+
+// Location returns the path to the database file.
+func (s *SQLiteStore) Location() string {
+	return s.path
+}
+
+// DataExplorerURL returns an empty string.
+func (s *SQLiteStore) DataExplorerURL(bucket string) string {
+	return ""
+}
+
+// LookupBucket returns an error if a bucket does not exist.
+func (s *SQLiteStore) LookupBucket(name string) error {
+	var found string
+	err := s.db.QueryRow("SELECT name FROM buckets WHERE name = ?", name).Scan(&found)
+	if err == sql.ErrNoRows {
+		return bucketNotFoundErr(name)
+	}
+	return err
+}
+
+// EnsureBucket creates a bucket if it does not exist.
+func (s *SQLiteStore) EnsureBucket(name string) error {
+	_, err := s.db.Exec("INSERT OR IGNORE INTO buckets (name) VALUES (?)", name)
+	return err
+}
+
+// DeleteBucket deletes a bucket and all data in it.
+func (s *SQLiteStore) DeleteBucket(name string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("DELETE FROM buckets WHERE name = ?", name)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return bucketNotFoundErr(name)
+	}
+
+	for _, table := range []string{"http_results", "results"} {
+		if _, err = tx.Exec("DELETE FROM "+table+" WHERE bucket = ?", name); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// WriteMeasurementMetadata writes measurement metadata.
+func (s *SQLiteStore) WriteMeasurementMetadata(md MeasurementMetadata) error {
+	_, err := s.db.Exec(
+		"INSERT OR REPLACE INTO measurement_metadata (id, description, backend_ids) VALUES (?, ?, ?)",
+		md.ID, md.Description, md.BackendIDsStr,
+	)
+	return err
+}
+
+// QueryMeasurementMetadata reads metadata of all measurements.
+func (s *SQLiteStore) QueryMeasurementMetadata() ([]MeasurementMetadata, error) {
+	rows, err := s.db.Query("SELECT id, description, backend_ids FROM measurement_metadata")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	md := []MeasurementMetadata{}
+	for rows.Next() {
+		var m MeasurementMetadata
+		if err = rows.Scan(&m.ID, &m.Description, &m.BackendIDsStr); err != nil {
+			return nil, err
+		}
+		md = append(md, m)
+	}
+
+	return md, rows.Err()
+}
+
+// WriteMeasurementTemplate writes a measurement template,
+// replacing the template with the same name.
+func (s *SQLiteStore) WriteMeasurementTemplate(tmpl MeasurementTemplate) error {
+	_, err := s.db.Exec(
+		"INSERT OR REPLACE INTO measurement_templates (name, spec) VALUES (?, ?)",
+		tmpl.Name, tmpl.Spec,
+	)
+	return err
+}
+
+// DeleteMeasurementTemplate deletes a measurement template.
+func (s *SQLiteStore) DeleteMeasurementTemplate(name string) error {
+	_, err := s.db.Exec("DELETE FROM measurement_templates WHERE name = ?", name)
+	return err
+}
+
+// QueryMeasurementTemplates reads all measurement templates.
+func (s *SQLiteStore) QueryMeasurementTemplates() ([]MeasurementTemplate, error) {
+	rows, err := s.db.Query("SELECT name, spec FROM measurement_templates")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	templates := []MeasurementTemplate{}
+	for rows.Next() {
+		var tmpl MeasurementTemplate
+		if err = rows.Scan(&tmpl.Name, &tmpl.Spec); err != nil {
+			return nil, err
+		}
+		templates = append(templates, tmpl)
+	}
+
+	return templates, rows.Err()
+}
+
+// WriteMeasurementCampaign writes a measurement campaign,
+// replacing the campaign with the same name.
+func (s *SQLiteStore) WriteMeasurementCampaign(camp MeasurementCampaign) error {
+	_, err := s.db.Exec(
+		"INSERT OR REPLACE INTO measurement_campaigns (name, state) VALUES (?, ?)",
+		camp.Name, camp.State,
+	)
+	return err
+}
+
+// DeleteMeasurementCampaign deletes a measurement campaign.
+func (s *SQLiteStore) DeleteMeasurementCampaign(name string) error {
+	_, err := s.db.Exec("DELETE FROM measurement_campaigns WHERE name = ?", name)
+	return err
+}
+
+// QueryMeasurementCampaigns reads all measurement campaigns.
+func (s *SQLiteStore) QueryMeasurementCampaigns() ([]MeasurementCampaign, error) {
+	rows, err := s.db.Query("SELECT name, state FROM measurement_campaigns")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	campaigns := []MeasurementCampaign{}
+	for rows.Next() {
+		var camp MeasurementCampaign
+		if err = rows.Scan(&camp.Name, &camp.State); err != nil {
+			return nil, err
+		}
+		campaigns = append(campaigns, camp)
+	}
+
+	return campaigns, rows.Err()
+}
+
+// WriteCheckpoint writes a checkpoint of a backend measurement.
+func (s *SQLiteStore) WriteCheckpoint(measID string, backendID int64, lastResultUnix int64) error {
+	_, err := s.db.Exec(
+		"INSERT OR REPLACE INTO checkpoints (backend_id, measurement_id, last_result) VALUES (?, ?, ?)",
+		backendID, measID, lastResultUnix,
+	)
+	return err
+}
+
+// DeleteCheckpoints deletes checkpoints of all backend measurements of a measurement.
+func (s *SQLiteStore) DeleteCheckpoints(measID string) error {
+	_, err := s.db.Exec("DELETE FROM checkpoints WHERE measurement_id = ?", measID)
+	return err
+}
+
+// QueryCheckpoints reads checkpoints of all backend measurements.
+func (s *SQLiteStore) QueryCheckpoints() (map[int64]int64, error) {
+	rows, err := s.db.Query("SELECT backend_id, last_result FROM checkpoints")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	checkpoints := make(map[int64]int64)
+	for rows.Next() {
+		var backendID, lastResultUnix int64
+		if err = rows.Scan(&backendID, &lastResultUnix); err != nil {
+			return nil, err
+		}
+		checkpoints[backendID] = lastResultUnix
+	}
+
+	return checkpoints, rows.Err()
+}
+
+// WriteHTTPMeasurementResult writes an HTTP measurement result to a bucket.
+func (s *SQLiteStore) WriteHTTPMeasurementResult(bucketName string, httpData *HTTPData) error {
+	if err := s.LookupBucket(bucketName); err != nil {
+		return err
+	}
+
+	_, err := s.db.Exec(
+		`INSERT OR REPLACE INTO http_results (
+			bucket, time, backend_id, af, probe_id, asn, country, target, target_ip,
+			rt, body_size, header_size, status_code
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		bucketName, httpData.Timestamp.UnixNano(), httpData.BackendID, httpData.AddressFamily,
+		httpData.ProbeID, httpData.ASN, httpData.Country, httpData.Target, httpData.TargetIP,
+		httpData.RoundTripTime, httpData.BodySize, httpData.HeaderSize, httpData.StatusCode,
+	)
+	return err
+}
+
+// WritePingMeasurementResult writes a ping measurement result to a bucket.
+func (s *SQLiteStore) WritePingMeasurementResult(bucketName string, pingData *PingData) error {
+	return s.write(bucketName, pingPoints(pingData)...)
+}
+
+// WriteTracerouteMeasurementResult writes a traceroute measurement result to a bucket.
+func (s *SQLiteStore) WriteTracerouteMeasurementResult(bucketName string, trData *TracerouteData) error {
+	return s.write(bucketName, traceroutePoints(trData)...)
+}
+
+// WriteDNSMeasurementResult writes a DNS measurement result to a bucket.
+func (s *SQLiteStore) WriteDNSMeasurementResult(bucketName string, dnsData *DNSData) error {
+	return s.write(bucketName, dnsPoints(dnsData)...)
+}
+
+// WriteSSLCertMeasurementResult writes an sslcert measurement result to a bucket.
+func (s *SQLiteStore) WriteSSLCertMeasurementResult(bucketName string, certData *SSLCertData) error {
+	return s.write(bucketName, sslCertPoints(certData)...)
+}
+
+// WriteNTPMeasurementResult writes an NTP measurement result to a bucket.
+func (s *SQLiteStore) WriteNTPMeasurementResult(bucketName string, ntpData *NTPData) error {
+	return s.write(bucketName, ntpPoints(ntpData)...)
+}
+
+// Flush does nothing, as all writes are synchronous.
+func (s *SQLiteStore) Flush(bucketName string) error {
+	return nil
+}
+
+// ReplaySpool does nothing, as results are never spooled.
+func (s *SQLiteStore) ReplaySpool() (replayed int, remaining int, err error) {
+	return 0, 0, nil
+}
+
+// QueryResults runs a results query and returns the resulting data points.
+// Data points are selected by time range in the database, and filtered
+// and aggregated in-process.
+func (s *SQLiteStore) QueryResults(q *ResultsQuery) ([]ResultPoint, error) {
+	_, measurement, field, err := q.selection()
+	if err != nil {
+		return nil, err
+	}
+
+	var samples []sample
+	if measurement == HTTPMeasurement {
+		samples, err = s.httpSamples(q.Bucket, field, q.Start, q.Stop)
+	} else {
+		samples, err = s.samples(q.Bucket, measurement, field, q.Start, q.Stop)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return evaluateResultsQuery(q, samples)
+}
+
+// WriteCreditBalance writes the current credit balance.
+func (s *SQLiteStore) WriteCreditBalance(creditBalance int64) error {
+	_, err := s.db.Exec(
+		"INSERT OR REPLACE INTO credit_balance (time, balance) VALUES (?, ?)",
+		time.Now().UnixNano(), creditBalance,
+	)
+	return err
+}
+
+func (s *SQLiteStore) write(bucketName string, dataPoints ...*write.Point) error {
+	if err := s.LookupBucket(bucketName); err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(
+		`INSERT OR REPLACE INTO results (bucket, measurement, series, time, tags, fields)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+	)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, dp := range dataPoints {
+		tags := make(map[string]string, len(dp.TagList()))
+		for _, tag := range dp.TagList() {
+			tags[tag.Key] = tag.Value
+		}
+		fields := make(map[string]interface{}, len(dp.FieldList()))
+		for _, field := range dp.FieldList() {
+			fields[field.Key] = field.Value
+		}
+
+		tagsJSON, err := json.Marshal(tags)
+		if err != nil {
+			return err
+		}
+		fieldsJSON, err := json.Marshal(fields)
+		if err != nil {
+			return err
+		}
+
+		_, err = stmt.Exec(bucketName, dp.Name(), tagsKey(tags),
+			dp.Time().UnixNano(), string(tagsJSON), string(fieldsJSON))
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) httpSamples(bucketName string, field string, start time.Time, stop time.Time) ([]sample, error) {
+	column, ok := httpFieldColumns[field]
+	if !ok {
+		return nil, nil
+	}
+
+	rows, err := s.db.Query(
+		`SELECT time, backend_id, af, probe_id, asn, country, target, target_ip, `+column+`
+		FROM http_results WHERE bucket = ? AND time >= ? AND time < ?`,
+		bucketName, start.UnixNano(), stop.UnixNano(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	samples := []sample{}
+	for rows.Next() {
+		var (
+			ts                          int64
+			backendID, af, probeID, asn int64
+			country, target, targetIP   string
+			value                       interface{}
+		)
+		err = rows.Scan(&ts, &backendID, &af, &probeID, &asn, &country, &target, &targetIP, &value)
+		if err != nil {
+			return nil, err
+		}
+
+		samples = append(samples, sample{
+			time:  time.Unix(0, ts).UTC(),
+			value: value,
+			tags: map[string]string{
+				tagBackendID: strconv.FormatInt(backendID, 10),
+				tagAF:        strconv.FormatInt(af, 10),
+				tagProbeID:   strconv.FormatInt(probeID, 10),
+				tagASN:       strconv.FormatInt(asn, 10),
+				tagCountry:   country,
+				tagTarget:    target,
+				tagTargetIP:  targetIP,
+			},
+		})
+	}
+
+	return samples, rows.Err()
+}
+
+func (s *SQLiteStore) samples(bucketName string, measurement string, field string, start time.Time, stop time.Time) ([]sample, error) {
+	rows, err := s.db.Query(
+		`SELECT time, tags, fields FROM results
+		WHERE bucket = ? AND measurement = ? AND time >= ? AND time < ?`,
+		bucketName, measurement, start.UnixNano(), stop.UnixNano(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	samples := []sample{}
+	for rows.Next() {
+		var (
+			ts                   int64
+			tagsJSON, fieldsJSON string
+			tags                 map[string]string
+			fields               map[string]interface{}
+		)
+		if err = rows.Scan(&ts, &tagsJSON, &fieldsJSON); err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal([]byte(tagsJSON), &tags); err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal([]byte(fieldsJSON), &fields); err != nil {
+			return nil, err
+		}
+
+		if value, ok := fields[field]; ok {
+			samples = append(samples, sample{time: time.Unix(0, ts).UTC(), value: value, tags: tags})
+		}
+	}
+
+	return samples, rows.Err()
+}
@@ -0,0 +1,166 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cicovic-andrija/dante/conf"
+)
+
+// Returns an initialized store backed by a file in a temporary directory.
+// The file name contains characters that have a meaning in URIs.
+func newTestSQLiteStore(t *testing.T) *SQLiteStore {
+	path := filepath.Join(t.TempDir(), "results #1?.db")
+	s := NewSQLiteStore(&conf.SQLiteConf{Path: path})
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("database file not created: %v", err)
+	}
+	return s
+}
+
+func TestSQLiteHealth(t *testing.T) {
+	s := newTestSQLiteStore(t)
+
+	report, err := s.Health()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Status != HealthStatusPass {
+		t.Errorf("status = %q, want %q", report.Status, HealthStatusPass)
+	}
+}
+
+func TestSQLiteResults(t *testing.T) {
+	s := newTestSQLiteStore(t)
+
+	const bucket = "results"
+	if err := s.EnsureBucket(bucket); err != nil {
+		t.Fatal(err)
+	}
+
+	httpResults := []HTTPData{
+		{BackendID: 1, ProbeID: 1000, Target: "example.com", RoundTripTime: 10, StatusCode: 200, Timestamp: time.Unix(100, 0)},
+		{BackendID: 1, ProbeID: 1001, Target: "example.com", RoundTripTime: 30, StatusCode: 200, Timestamp: time.Unix(100, 0)},
+		{BackendID: 1, ProbeID: 1000, Target: "example.com", RoundTripTime: 20, StatusCode: 200, Timestamp: time.Unix(200, 0)},
+		// written again
+		{BackendID: 1, ProbeID: 1000, Target: "example.com", RoundTripTime: 20, StatusCode: 200, Timestamp: time.Unix(200, 0)},
+		// outside of the queried time range
+		{BackendID: 1, ProbeID: 1000, Target: "example.com", RoundTripTime: 90, StatusCode: 200, Timestamp: time.Unix(900, 0)},
+	}
+	for i := range httpResults {
+		if err := s.WriteHTTPMeasurementResult(bucket, &httpResults[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pingResults := []PingData{
+		{BackendID: 2, ProbeID: 1000, AvgRTT: 5, Sent: 3, Received: 3, Timestamp: time.Unix(100, 0)},
+		{BackendID: 2, ProbeID: 1000, AvgRTT: -1, Sent: 3, Received: 0, Timestamp: time.Unix(200, 0)},
+	}
+	for i := range pingResults {
+		if err := s.WritePingMeasurementResult(bucket, &pingResults[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		query  ResultsQuery
+		values []float64
+	}{
+		{"raw", ResultsQuery{Type: HTTPMeasurement}, []float64{10, 30, 20}},
+		{"filtered", ResultsQuery{Type: HTTPMeasurement, ProbeIDs: []string{"1000"}}, []float64{10, 20}},
+		{"aggregated", ResultsQuery{Type: HTTPMeasurement, Aggregate: AggregateMean}, []float64{20}},
+		{"ping", ResultsQuery{Type: PingMeasurement}, []float64{5}},
+		{"error rate", ResultsQuery{Type: PingMeasurement, Aggregate: AggregateErrorRate}, []float64{0.5}},
+	}
+
+	for _, test := range tests {
+		q := test.query
+		q.Bucket, q.Start, q.Stop = bucket, time.Unix(0, 0), time.Unix(500, 0)
+		points, err := s.QueryResults(&q)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		values := make([]float64, len(points))
+		for i, p := range points {
+			values[i], _ = toFloat(p.Value)
+		}
+		if !equalValues(values, test.values) {
+			t.Errorf("%s: values %v, want %v", test.name, values, test.values)
+		}
+	}
+
+	if err := s.WriteHTTPMeasurementResult("missing", &httpResults[0]); !isNotFoundErr(err) {
+		t.Errorf("write to a missing bucket: %v, want not found", err)
+	}
+}
+
+// Checkpoints are kept in the database file across restarts.
+func TestSQLiteCheckpoints(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoints.db")
+	s := NewSQLiteStore(&conf.SQLiteConf{Path: path})
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, cp := range []struct {
+		measID    string
+		backendID int64
+		last      int64
+	}{
+		{"a", 1, 100},
+		{"a", 2, 200},
+		{"b", 3, 300},
+		{"a", 1, 150},
+	} {
+		if err := s.WriteCheckpoint(cp.measID, cp.backendID, cp.last); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Close()
+
+	s = NewSQLiteStore(&conf.SQLiteConf{Path: path})
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	checkpoints, err := s.QueryCheckpoints()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checkpoints) != 3 || checkpoints[1] != 150 || checkpoints[2] != 200 || checkpoints[3] != 300 {
+		t.Errorf("checkpoints = %v", checkpoints)
+	}
+
+	if err := s.DeleteCheckpoints("a"); err != nil {
+		t.Fatal(err)
+	}
+	if checkpoints, err = s.QueryCheckpoints(); err != nil {
+		t.Fatal(err)
+	}
+	if len(checkpoints) != 1 || checkpoints[3] != 300 {
+		t.Errorf("checkpoints after delete = %v", checkpoints)
+	}
+}
+
+func equalValues(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
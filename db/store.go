@@ -0,0 +1,61 @@
+package db
+
+// Store represents a storage backend for measurement metadata,
+// measurement results and credit balance. Results of each
+// measurement are kept in a separate bucket, identified by name.
+// Backends may write measurement results asynchronously; Flush
+// must be called to make sure they are stored.
+type Store interface {
+	// Init prepares the backend for use, and must be called first.
+	Init() error
+	Close()
+	Health() (*HealthReport, error)
+	Location() string
+	// DataExplorerURL returns a URL to a user interface for exploring
+	// data in a bucket, or an empty string if the backend has none.
+	DataExplorerURL(bucket string) string
+
+	LookupBucket(name string) error
+	EnsureBucket(name string) error
+	DeleteBucket(name string) error
+
+	WriteMeasurementMetadata(md MeasurementMetadata) error
+	QueryMeasurementMetadata() ([]MeasurementMetadata, error)
+	WriteMeasurementTemplate(tmpl MeasurementTemplate) error
+	DeleteMeasurementTemplate(name string) error
+	QueryMeasurementTemplates() ([]MeasurementTemplate, error)
+	WriteMeasurementCampaign(camp MeasurementCampaign) error
+	DeleteMeasurementCampaign(name string) error
+	QueryMeasurementCampaigns() ([]MeasurementCampaign, error)
+	WriteCheckpoint(measID string, backendID int64, lastResultUnix int64) error
+	DeleteCheckpoints(measID string) error
+	QueryCheckpoints() (map[int64]int64, error)
+
+	WriteHTTPMeasurementResult(bucketName string, httpData *HTTPData) error
+	WritePingMeasurementResult(bucketName string, pingData *PingData) error
+	WriteTracerouteMeasurementResult(bucketName string, trData *TracerouteData) error
+	WriteDNSMeasurementResult(bucketName string, dnsData *DNSData) error
+	WriteSSLCertMeasurementResult(bucketName string, certData *SSLCertData) error
+	WriteNTPMeasurementResult(bucketName string, ntpData *NTPData) error
+	// Flush writes pending measurement results to a bucket, and returns
+	// an error if any write of results to the bucket failed since the last call.
+	Flush(bucketName string) error
+	QueryResults(q *ResultsQuery) ([]ResultPoint, error)
+	// ReplaySpool writes measurement results that were stored locally while
+	// the backend was unavailable. Returns the number of written data points,
+	// and the number of data points still stored locally.
+	ReplaySpool() (replayed int, remaining int, err error)
+
+	WriteCreditBalance(creditBalance int64) error
+}
+
+// WriteObserver is called with the outcome of a write of data points
+// of a measurement.
+type WriteObserver func(measurement string, err error)
+
+// AsyncWriter is implemented by backends that write measurement results
+// asynchronously. Writes of results are reported to the observer when
+// they happen, instead of by the methods that write results.
+type AsyncWriter interface {
+	ObserveWrites(observe WriteObserver)
+}
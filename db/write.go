@@ -2,10 +2,10 @@ package db
 
 import (
 	"context"
-	"strconv"
+	"fmt"
+	"strings"
 	"time"
 
-	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
 	"github.com/influxdata/influxdb-client-go/v2/api/write"
 )
 
@@ -13,11 +13,24 @@ import (
 const (
 	MetadataMeasurement      = "md"
 	HTTPMeasurement          = "http"
+	PingMeasurement          = "ping"
+	PingPacketMeasurement    = "ping-packet"
+	TracerouteMeasurement    = "traceroute"
+	TracerouteHopMeasurement = "traceroute-hop"
+	DNSMeasurement           = "dns"
+	DNSAnswerMeasurement     = "dns-answer"
+	SSLCertMeasurement       = "sslcert"
+	NTPMeasurement           = "ntp"
+	NTPPacketMeasurement     = "ntp-packet"
 	CreditBalanceMeasurement = "credit-balance"
+	CheckpointMeasurement    = "checkpoint"
+	TemplateMeasurement      = "template"
+	CampaignMeasurement      = "campaign"
 )
 
 const (
 	tagID          = "id"
+	tagName        = "name"
 	tagDescription = "description"
 	tagBackendIDs  = "backend-ids"
 	tagBackendID   = "backend-id"
@@ -26,12 +39,50 @@ const (
 	tagCountry     = "country"
 	tagTarget      = "target"
 	tagTargetIP    = "target-ip"
+	tagAF          = "af"
+	tagPacket      = "packet"
+	tagHop         = "hop"
+	tagHopIP       = "hop-ip"
+	tagResolver    = "resolver"
+	tagRecordType  = "record-type"
+	tagRecordName  = "record-name"
+	tagFingerprint = "fingerprint"
 
 	fieldValue      = "value"
+	fieldLastResult = "last-result"
+	fieldSpec       = "spec"
+	fieldState      = "state"
 	fieldRT         = "rt"
 	fieldBodySize   = "body-size"
 	fieldHeaderSize = "header-size"
 	fieldStatusCode = "status-code"
+	fieldRTTMin     = "rtt-min"
+	fieldRTTAvg     = "rtt-avg"
+	fieldRTTMax     = "rtt-max"
+	fieldSent       = "sent"
+	fieldReceived   = "received"
+	fieldLoss       = "loss"
+	fieldHops       = "hops"
+	fieldASPath     = "as-path"
+	fieldPathChange = "as-path-changed"
+	fieldReached    = "destination-reached"
+	fieldSize       = "size"
+	fieldRCode      = "rcode"
+	fieldAnswers    = "answer-count"
+	fieldError      = "error"
+	fieldData       = "data"
+	fieldTTL        = "ttl"
+	fieldTTC        = "ttc"
+	fieldVersion    = "tls-version"
+	fieldSubject    = "subject"
+	fieldIssuer     = "issuer"
+	fieldNotBefore  = "not-before"
+	fieldNotAfter   = "not-after"
+	fieldDaysLeft   = "days-until-expiry"
+	fieldOffset     = "offset"
+	fieldOffsetAvg  = "offset-avg"
+	fieldStratum    = "stratum"
+	fieldRefID      = "ref-id"
 )
 
 var nullTimestamp = time.Unix(0, 0)
@@ -40,6 +91,7 @@ var nullTimestamp = time.Unix(0, 0)
 // that is written to the HTTPMeasurement bucket.
 type HTTPData struct {
 	BackendID     int64
+	AddressFamily int32
 	ProbeID       int64
 	ASN           int64
 	Country       string
@@ -52,70 +104,339 @@ type HTTPData struct {
 	Timestamp     time.Time
 }
 
-// WriteMeasurementResult writes a single data point
+// PingData specifies values of data points
+// of the PingMeasurement and PingPacketMeasurement measurements.
+// RTT values are not valid if no replies were received.
+type PingData struct {
+	BackendID     int64
+	AddressFamily int32
+	ProbeID       int64
+	ASN           int64
+	Country       string
+	Target        string
+	TargetIP      string
+	MinRTT        float64
+	AvgRTT        float64
+	MaxRTT        float64
+	Sent          int64
+	Received      int64
+	RTTs          []float64 // per-packet RTTs, negative value marks a lost packet
+	Timestamp     time.Time
+}
+
+// LossPercentage returns the percentage of sent packets
+// that did not receive a reply.
+func (d *PingData) LossPercentage() float64 {
+	return lossPercentage(d.Sent, d.Received)
+}
+
+// TracerouteData specifies values of data points
+// of the TracerouteMeasurement and TracerouteHopMeasurement measurements.
+// AS path values are not valid if ASPathKnown is false,
+// and ASPathChanged is not valid if ASPathChangeKnown is false.
+type TracerouteData struct {
+	BackendID          int64
+	AddressFamily      int32
+	ProbeID            int64
+	ASN                int64
+	Country            string
+	Target             string
+	TargetIP           string
+	Hops               []TracerouteHopData
+	ASPath             string
+	ASPathKnown        bool
+	ASPathChanged      bool
+	ASPathChangeKnown  bool
+	DestinationReached bool
+	Timestamp          time.Time
+}
+
+// TracerouteHopData specifies values of a single traceroute hop.
+// IP is empty if no replies were received for the hop.
+type TracerouteHopData struct {
+	Hop      int64
+	IP       string
+	RTT      float64
+	Sent     int64
+	Received int64
+}
+
+// LossPercentage returns the percentage of packets sent
+// for the hop that did not receive a reply.
+func (d *TracerouteHopData) LossPercentage() float64 {
+	return lossPercentage(d.Sent, d.Received)
+}
+
+// DNSData specifies values of data points
+// of the DNSMeasurement and DNSAnswerMeasurement measurements.
+// If Error is not empty, the query failed and
+// response-related values are not valid.
+type DNSData struct {
+	BackendID     int64
+	AddressFamily int32
+	ProbeID       int64
+	ASN           int64
+	Country       string
+	Target        string
+	TargetIP      string
+	Resolver      string
+	ResponseTime  float64
+	ResponseSize  int64
+	RCode         string
+	Answers       []DNSAnswerData
+	Error         string
+	Timestamp     time.Time
+}
+
+// DNSAnswerData specifies values of a single resource record
+// from the answer section of a DNS response.
+type DNSAnswerData struct {
+	Name string
+	Type string
+	TTL  int64
+	Data string
+}
+
+// SSLCertData specifies values of a data point
+// of the SSLCertMeasurement measurement.
+// If Error is not empty, the handshake failed and
+// certificate-related values are not valid.
+type SSLCertData struct {
+	BackendID     int64
+	AddressFamily int32
+	ProbeID       int64
+	ASN           int64
+	Country       string
+	Target        string
+	TargetIP      string
+	TLSVersion    string
+	HandshakeTime float64
+	ConnectTime   float64
+	Subject       string
+	Issuer        string
+	NotBefore     time.Time
+	NotAfter      time.Time
+	Fingerprint   string
+	Error         string
+	Timestamp     time.Time
+}
+
+// DaysUntilExpiry returns the number of days between the time
+// the certificate was served and its expiry time.
+// The value is negative for expired certificates.
+func (d *SSLCertData) DaysUntilExpiry() float64 {
+	return d.NotAfter.Sub(d.Timestamp).Hours() / 24
+}
+
+// NTPData specifies values of data points
+// of the NTPMeasurement and NTPPacketMeasurement measurements.
+type NTPData struct {
+	BackendID     int64
+	AddressFamily int32
+	ProbeID       int64
+	ASN           int64
+	Country       string
+	Target        string
+	TargetIP      string
+	Stratum       int64
+	ReferenceID   string
+	Packets       []NTPPacketData
+	Timestamp     time.Time
+}
+
+// NTPPacketData specifies values of a single NTP reply.
+// Offset and RTT are not valid if the request timed out.
+type NTPPacketData struct {
+	Offset   float64
+	RTT      float64
+	TimedOut bool
+}
+
+// Averages returns the average offset and RTT of the replied packets,
+// and the number of replied packets.
+func (d *NTPData) Averages() (offset float64, rtt float64, received int64) {
+	for _, packet := range d.Packets {
+		if packet.TimedOut {
+			continue
+		}
+		offset += packet.Offset
+		rtt += packet.RTT
+		received++
+	}
+	if received > 0 {
+		offset /= float64(received)
+		rtt /= float64(received)
+	}
+	return
+}
+
+// WriteHTTPMeasurementResult writes a single data point
 // of the HTTPMeasurement measurement to a specified bucket.
 // It assumes c.Org is not nil.
 func (c *Client) WriteHTTPMeasurementResult(bucketName string, httpData *HTTPData) error {
-	// specify data point
-	dataPoint := influxdb2.NewPoint(
-		HTTPMeasurement,
-		map[string]string{
-			tagBackendID: strconv.FormatInt(httpData.BackendID, 10),
-			tagProbeID:   strconv.FormatInt(httpData.ProbeID, 10),
-			tagASN:       strconv.FormatInt(httpData.ASN, 10),
-			tagCountry:   httpData.Country,
-			tagTarget:    httpData.Target,
-			tagTargetIP:  httpData.TargetIP,
-		},
-		map[string]interface{}{
-			fieldRT:         httpData.RoundTripTime,
-			fieldBodySize:   httpData.BodySize,
-			fieldHeaderSize: httpData.HeaderSize,
-			fieldStatusCode: httpData.StatusCode,
-		},
-		httpData.Timestamp,
-	)
+	return c.batch.add(bucketName, httpPoints(httpData)...)
+}
+
+// WritePingMeasurementResult writes a single summary data point
+// of the PingMeasurement measurement, and a data point
+// of the PingPacketMeasurement measurement for each replied packet,
+// to a specified bucket.
+// It assumes c.Org is not nil.
+func (c *Client) WritePingMeasurementResult(bucketName string, pingData *PingData) error {
+	return c.batch.add(bucketName, pingPoints(pingData)...)
+}
+
+// WriteTracerouteMeasurementResult writes a single path data point
+// of the TracerouteMeasurement measurement, and a data point
+// of the TracerouteHopMeasurement measurement for each hop,
+// to a specified bucket.
+// It assumes c.Org is not nil.
+func (c *Client) WriteTracerouteMeasurementResult(bucketName string, trData *TracerouteData) error {
+	return c.batch.add(bucketName, traceroutePoints(trData)...)
+}
+
+// WriteDNSMeasurementResult writes a single response data point
+// of the DNSMeasurement measurement, and a data point
+// of the DNSAnswerMeasurement measurement for each answer,
+// to a specified bucket.
+// It assumes c.Org is not nil.
+func (c *Client) WriteDNSMeasurementResult(bucketName string, dnsData *DNSData) error {
+	return c.batch.add(bucketName, dnsPoints(dnsData)...)
+}
+
+// WriteSSLCertMeasurementResult writes a single data point
+// of the SSLCertMeasurement measurement to a specified bucket.
+// It assumes c.Org is not nil.
+func (c *Client) WriteSSLCertMeasurementResult(bucketName string, certData *SSLCertData) error {
+	return c.batch.add(bucketName, sslCertPoints(certData)...)
+}
 
-	return c.write(bucketName, dataPoint)
+// WriteNTPMeasurementResult writes a single summary data point
+// of the NTPMeasurement measurement, and a data point
+// of the NTPPacketMeasurement measurement for each replied packet,
+// to a specified bucket.
+// It assumes c.Org is not nil.
+func (c *Client) WriteNTPMeasurementResult(bucketName string, ntpData *NTPData) error {
+	return c.batch.add(bucketName, ntpPoints(ntpData)...)
 }
 
 // WriteMeasurementMetadata writes a MetadataMeasurement data point to the SystemBucket.
 // It assumes c.Org is not nil.
 func (c *Client) WriteMeasurementMetadata(md MeasurementMetadata) error {
-	dataPoint := influxdb2.NewPoint(
-		MetadataMeasurement,
-		map[string]string{
-			tagID:          md.ID,
-			tagDescription: md.Description,
-			tagBackendIDs:  md.BackendIDsStr,
-		},
-		map[string]interface{}{
-			"dummy-value": 42,
-		},
-		nullTimestamp,
+	return c.write(SystemBucket, metadataPoint(md))
+}
+
+// WriteMeasurementTemplate writes a TemplateMeasurement data point to the SystemBucket.
+// The data point has a fixed timestamp, so a write replaces the template with the same name.
+// It assumes c.Org is not nil.
+func (c *Client) WriteMeasurementTemplate(tmpl MeasurementTemplate) error {
+	return c.write(SystemBucket, templatePoint(tmpl))
+}
+
+// DeleteMeasurementTemplate deletes a TemplateMeasurement data point from the SystemBucket.
+// It assumes c.Org is not nil.
+func (c *Client) DeleteMeasurementTemplate(name string) error {
+	predicate := fmt.Sprintf(`_measurement="%s" AND %s="%s"`,
+		TemplateMeasurement, tagName, strings.ReplaceAll(name, `"`, `\"`))
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+	return c.influxClient.DeleteAPI().DeleteWithName(
+		ctx, c.Org.Name, SystemBucket, nullTimestamp, nullTimestamp.Add(time.Second), predicate,
 	)
+}
 
-	return c.write(SystemBucket, dataPoint)
+// WriteMeasurementCampaign writes a CampaignMeasurement data point to the SystemBucket.
+// The data point has a fixed timestamp, so a write replaces the campaign with the same name.
+// It assumes c.Org is not nil.
+func (c *Client) WriteMeasurementCampaign(camp MeasurementCampaign) error {
+	return c.write(SystemBucket, campaignPoint(camp))
+}
+
+// DeleteMeasurementCampaign deletes a CampaignMeasurement data point from the SystemBucket.
+// It assumes c.Org is not nil.
+func (c *Client) DeleteMeasurementCampaign(name string) error {
+	predicate := fmt.Sprintf(`_measurement="%s" AND %s="%s"`,
+		CampaignMeasurement, tagName, strings.ReplaceAll(name, `"`, `\"`))
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+	return c.influxClient.DeleteAPI().DeleteWithName(
+		ctx, c.Org.Name, SystemBucket, nullTimestamp, nullTimestamp.Add(time.Second), predicate,
+	)
+}
+
+// WriteCheckpoint writes a CheckpointMeasurement data point to the SystemBucket,
+// recording the timestamp of the latest stored result of a backend measurement.
+// The data point has a fixed timestamp, so each write replaces the previous one.
+// It assumes c.Org is not nil.
+func (c *Client) WriteCheckpoint(measID string, backendID int64, lastResultUnix int64) error {
+	return c.write(SystemBucket, checkpointPoint(measID, backendID, lastResultUnix))
+}
+
+// DeleteCheckpoints deletes CheckpointMeasurement data points of all
+// backend measurements of a measurement from the SystemBucket.
+// It assumes c.Org is not nil.
+func (c *Client) DeleteCheckpoints(measID string) error {
+	predicate := fmt.Sprintf(`_measurement="%s" AND %s="%s"`,
+		CheckpointMeasurement, tagID, strings.ReplaceAll(measID, `"`, `\"`))
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+	return c.influxClient.DeleteAPI().DeleteWithName(
+		ctx, c.Org.Name, SystemBucket, nullTimestamp, nullTimestamp.Add(time.Second), predicate,
+	)
 }
 
 // WriteCreditBalance writes a single data point
 // of the CreditBalanceMeasurement measurement.
 // It assumes c.Org is not nil.
 func (c *Client) WriteCreditBalance(creditBalance int64) error {
-	// specify data point
-	dataPoint := influxdb2.NewPoint(
-		CreditBalanceMeasurement,
-		nil, /* tags */
-		map[string]interface{}{fieldValue: creditBalance},
-		time.Now(),
-	)
+	return c.write(SystemBucket, creditBalancePoint(creditBalance))
+}
+
+func lossPercentage(sent int64, received int64) float64 {
+	if sent <= 0 {
+		return 0
+	}
+	return float64(sent-received) / float64(sent) * 100
+}
+
+// Writes data points to a bucket. If that fails and spooling is enabled,
+// data points are spooled instead, unless the bucket does not exist
+// or the database rejected them.
+func (c *Client) writeOrSpool(bucketName string, dataPoints ...*write.Point) error {
+	err := c.write(bucketName, dataPoints...)
+	if err == nil || c.spool == nil || isNotFoundErr(err) || isRejectedWriteErr(err) {
+		return err
+	}
+
+	if spoolErr := c.spool.append(bucketName, dataPoints...); spoolErr != nil {
+		return fmt.Errorf("%v (spooling failed: %v)", err, spoolErr)
+	}
+	return nil
+}
+
+// ReplaySpool writes spooled data points to the database. Returns the number
+// of written data points, and the number of data points still spooled.
+func (c *Client) ReplaySpool() (replayed int, remaining int, err error) {
+	if c.spool == nil {
+		return 0, 0, nil
+	}
 
-	return c.write(SystemBucket, dataPoint)
+	replayed, err = c.spool.replay(func(bucketName string, lines ...string) error {
+		writeAPI := c.influxClient.WriteAPIBlocking(c.Org.Name, bucketName)
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+		defer cancel()
+		return writeAPI.WriteRecord(ctx, lines...)
+	})
+	return replayed, c.spool.size(), err
 }
 
-func (c *Client) write(bucketName string, dataPoint *write.Point) error {
+// Writes data points to a bucket, blocking until done.
+func (c *Client) write(bucketName string, dataPoints ...*write.Point) error {
 	writeAPI := c.influxClient.WriteAPIBlocking(c.Org.Name, bucketName)
 	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
 	defer cancel()
-	return writeAPI.WritePoint(ctx, dataPoint)
+	return writeAPI.WritePoint(ctx, dataPoints...)
 }
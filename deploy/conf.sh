@@ -8,17 +8,35 @@ cat > $CONF <<- EOF
         "dns_name": "localhost",
         "port": 8080
     },
+    "auth": {
+        "keys_file": "$WORKDIR/api.keys"
+    },
     "atlas": {
         "net": {
             "protocol": "https",
             "dns_name": "atlas.ripe.net",
             "port": 443
         },
+        "api_path": "/api/v2",
+        "rate_limit": {
+            "requests_per_sec": 5,
+            "burst": 10,
+            "max_in_flight": 8
+        },
         "auth":{
             "key_file": "$WORKDIR/atlas.api.key",
             "validate_key": true
         }
     },
+    "fetch": {
+        "min_period_sec": 60,
+        "max_period_sec": 3600
+    },
+    "tasks": {
+        "workers": 16,
+        "timeout_sec": 300
+    },
+    "storage": "influxdb",
     "influxdb": {
         "organization": "dante",
         "net": {
@@ -28,6 +46,14 @@ cat > $CONF <<- EOF
         },
         "auth": {
             "token_file": "$WORKDIR/influxdb.token"
+        },
+        "batch": {
+            "size": 500,
+            "flush_interval_ms": 1000,
+            "max_pending_batches": 16
+        },
+        "spool": {
+            "dir": "$WORKDIR/spool"
         }
     },
     "log": {
@@ -0,0 +1,162 @@
+package fakeatlas
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/cicovic-andrija/dante/atlas"
+	"github.com/gorilla/mux"
+)
+
+func (s *Server) authenticate(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.opts.Key != "" && r.Header.Get(atlas.AuthorizationHeader) != fmt.Sprintf(atlas.AuthorizationFmt, s.opts.Key) {
+			writeError(w, http.StatusForbidden, "Authentication credentials were not provided or are invalid.")
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) creditsHandler(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	s.advance()
+
+	writeJSON(w, http.StatusOK, &atlas.Credit{CurrentBalance: s.credits})
+}
+
+func (s *Server) createMeasurementsHandler(w http.ResponseWriter, r *http.Request) {
+	measReq := &atlas.MeasurementRequest{}
+	if err := json.NewDecoder(r.Body).Decode(measReq); err != nil {
+		writeError(w, http.StatusBadRequest, "JSON parse error.")
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	s.advance()
+
+	if errMsg := s.validateMeasurementRequest(measReq); errMsg != "" {
+		writeError(w, http.StatusBadRequest, errMsg)
+		return
+	}
+
+	probeIDs := s.selectProbes(measReq.Probes)
+	if len(probeIDs) == 0 {
+		writeError(w, http.StatusBadRequest, "No suitable probes found.")
+		return
+	}
+
+	resp := &atlas.MeasurementReqResponse{}
+	for _, def := range measReq.Definitions {
+		meas := s.newMeasurement(def, probeIDs)
+		s.measurements[meas.ID] = meas
+		resp.Measurements = append(resp.Measurements, meas.ID)
+	}
+
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+func (s *Server) measurementHandler(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	s.advance()
+
+	meas, ok := s.lookupMeasurement(r)
+	if !ok {
+		writeError(w, http.StatusNotFound, "Not found.")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, &meas.Measurement)
+	case http.MethodDelete:
+		s.stopMeasurement(meas)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *Server) resultsHandler(w http.ResponseWriter, r *http.Request) {
+	var (
+		start int64
+		stop  int64 = -1
+		err   error
+	)
+
+	query := r.URL.Query()
+	if v := query.Get("start"); v != "" {
+		if start, err = strconv.ParseInt(v, 10, 64); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid start parameter.")
+			return
+		}
+	}
+	if v := query.Get("stop"); v != "" {
+		if stop, err = strconv.ParseInt(v, 10, 64); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid stop parameter.")
+			return
+		}
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	s.advance()
+
+	meas, ok := s.lookupMeasurement(r)
+	if !ok {
+		writeError(w, http.StatusNotFound, "Not found.")
+		return
+	}
+
+	results := atlas.MeasurementResults{}
+	for _, result := range meas.results {
+		if result.Timestamp >= start && (stop < 0 || result.Timestamp <= stop) {
+			results = append(results, result)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, results)
+}
+
+func (s *Server) probeHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+
+	s.Lock()
+	defer s.Unlock()
+
+	probe, ok := s.probes[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "Not found.")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, probe)
+}
+
+// It assumes s is locked.
+func (s *Server) lookupMeasurement(r *http.Request) (*measurement, bool) {
+	id, _ := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	meas, ok := s.measurements[id]
+	return meas, ok
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set(atlas.ContentTypeHeader, atlas.ContentType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, detail string) {
+	writeJSON(w, status, struct {
+		Error *atlas.Error `json:"error"`
+	}{
+		Error: &atlas.Error{
+			Detail: detail,
+			Title:  http.StatusText(status),
+			Status: int64(status),
+		},
+	})
+}
@@ -0,0 +1,203 @@
+// Package fakeatlas implements a simulated RIPE Atlas API server.
+// It supports the subset of the API used by dante, generates synthetic
+// HTTP measurement results and charges credits for them.
+package fakeatlas
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cicovic-andrija/dante/atlas"
+	"github.com/gorilla/mux"
+)
+
+// Default simulation parameters.
+const (
+	DefaultCredits   = 1000000
+	DefaultProbes    = 50
+	firstProbeID     = 1000
+	firstMeasID      = 10000000
+	httpResultCost   = 10
+	failureRateOneIn = 50
+)
+
+var (
+	probeCountries = []string{"RS", "DE", "NL", "US", "JP", "BR", "ZA", "AU"}
+	probeASNs      = []int64{64496, 64497, 64498, 64499, 64500, 64501, 64502, 64503}
+)
+
+// Options specifies parameters of a simulated server.
+// Zero values are replaced with defaults.
+type Options struct {
+	APIPath string
+	Key     string
+	Credits int64
+	Probes  int
+
+	// Now returns current time. Can be replaced to control
+	// the simulation clock.
+	Now func() time.Time
+}
+
+// Server represents a simulated Atlas API server.
+type Server struct {
+	sync.Mutex
+
+	opts         Options
+	credits      int64
+	nextMeasID   int64
+	probes       map[int64]*atlas.Probe
+	probeIDs     []int64
+	measurements map[int64]*measurement
+	router       http.Handler
+}
+
+type measurement struct {
+	atlas.Measurement
+
+	probeIDs []int64
+	nextTick int64
+	results  atlas.MeasurementResults
+	rand     *rand.Rand
+}
+
+// NewServer returns a new Server.
+func NewServer(opts Options) *Server {
+	if opts.APIPath == "" {
+		opts.APIPath = atlas.DefaultAPIPath
+	}
+	if opts.Credits == 0 {
+		opts.Credits = DefaultCredits
+	}
+	if opts.Probes == 0 {
+		opts.Probes = DefaultProbes
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+
+	s := &Server{
+		opts:         opts,
+		credits:      opts.Credits,
+		nextMeasID:   firstMeasID,
+		probes:       make(map[int64]*atlas.Probe, opts.Probes),
+		probeIDs:     make([]int64, 0, opts.Probes),
+		measurements: make(map[int64]*measurement),
+	}
+
+	for i := 0; i < opts.Probes; i++ {
+		probe := &atlas.Probe{
+			ResourceBase: atlas.ResourceBase{ID: int64(firstProbeID + i), Type: "Probe"},
+			CountryCode:  probeCountries[i%len(probeCountries)],
+			ASNv4:        probeASNs[i%len(probeASNs)],
+			ASNv6:        probeASNs[i%len(probeASNs)],
+		}
+		s.probes[probe.ID] = probe
+		s.probeIDs = append(s.probeIDs, probe.ID)
+	}
+
+	s.router = s.initRouter()
+	return s
+}
+
+// Handler returns an HTTP handler that serves the simulated API.
+func (s *Server) Handler() http.Handler {
+	return s.router
+}
+
+// Transport returns an HTTP round tripper that serves requests
+// with the simulated API in-process, without opening connections.
+func (s *Server) Transport() http.RoundTripper {
+	return handlerTransport{handler: s.router}
+}
+
+// Credits returns the current credit balance.
+func (s *Server) Credits() int64 {
+	s.Lock()
+	defer s.Unlock()
+	s.advance()
+	return s.credits
+}
+
+func (s *Server) initRouter() http.Handler {
+	router := mux.NewRouter()
+	api := router.PathPrefix(s.opts.APIPath).Subrouter()
+
+	api.HandleFunc("/credits", s.creditsHandler).Methods(http.MethodGet)
+	api.HandleFunc("/measurements", s.createMeasurementsHandler).Methods(http.MethodPost)
+	api.HandleFunc("/measurements/{id:[0-9]+}", s.measurementHandler).Methods(http.MethodGet, http.MethodDelete)
+	api.HandleFunc("/measurements/{id:[0-9]+}/results", s.resultsHandler).Methods(http.MethodGet)
+	api.HandleFunc("/probes/{id:[0-9]+}", s.probeHandler).Methods(http.MethodGet)
+
+	router.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "Not found.")
+	})
+	router.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed.")
+	})
+
+	return s.authenticate(router)
+}
+
+type handlerTransport struct {
+	handler http.Handler
+}
+
+func (t handlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// like a network transport, do not send canceled requests
+	if err := req.Context().Err(); err != nil {
+		return nil, err
+	}
+
+	// handlers expect a non-nil body, as on the server side
+	r := req.Clone(req.Context())
+	if r.Body == nil {
+		r.Body = http.NoBody
+	}
+
+	w := &responseBuffer{header: make(http.Header)}
+	t.handler.ServeHTTP(w, r)
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", w.code, http.StatusText(w.code)),
+		StatusCode:    w.code,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        w.header,
+		Body:          io.NopCloser(bytes.NewReader(w.body.Bytes())),
+		ContentLength: int64(w.body.Len()),
+		Request:       req,
+	}, nil
+}
+
+// responseBuffer is a minimal http.ResponseWriter that
+// keeps a response in memory, to be returned by handlerTransport.
+type responseBuffer struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func (w *responseBuffer) Header() http.Header {
+	return w.header
+}
+
+func (w *responseBuffer) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+}
+
+func (w *responseBuffer) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(b)
+}
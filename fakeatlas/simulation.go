@@ -0,0 +1,180 @@
+package fakeatlas
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/cicovic-andrija/dante/atlas"
+)
+
+// It assumes s is locked.
+func (s *Server) validateMeasurementRequest(req *atlas.MeasurementRequest) string {
+	if len(req.Definitions) == 0 {
+		return "At least one definition must be specified."
+	}
+
+	if len(req.Probes) == 0 {
+		return "At least one probe request must be specified."
+	}
+
+	for _, def := range req.Definitions {
+		if def.Type != atlas.MeasHTTP {
+			return fmt.Sprintf("Measurement type %q is not supported by the simulator.", def.Type)
+		}
+		if def.Target == "" {
+			return "Target must be specified."
+		}
+		if def.AddressFamily != atlas.IPv4 && def.AddressFamily != atlas.IPv6 {
+			return "Address family must be 4 or 6."
+		}
+		if def.Interval <= 0 {
+			return "Interval must be a positive integer."
+		}
+		if def.StopTime != 0 && def.StopTime <= def.StartTime {
+			return "Stop time must be later than start time."
+		}
+	}
+
+	if s.credits < httpResultCost {
+		return "Not enough credits to schedule measurements."
+	}
+
+	return ""
+}
+
+// It assumes s is locked.
+func (s *Server) selectProbes(probeReqs []*atlas.ProbeRequest) []int64 {
+	selected := make(map[int64]bool)
+	probeIDs := []int64{}
+
+	for _, probeReq := range probeReqs {
+		var n int64
+		for _, id := range s.probeIDs {
+			if n >= probeReq.Requested {
+				break
+			}
+			if selected[id] || !probeMatches(s.probes[id], probeReq) {
+				continue
+			}
+			selected[id] = true
+			probeIDs = append(probeIDs, id)
+			n++
+		}
+	}
+
+	return probeIDs
+}
+
+func probeMatches(probe *atlas.Probe, probeReq *atlas.ProbeRequest) bool {
+	switch probeReq.Type {
+	case "country":
+		return probe.CountryCode == probeReq.Value
+	case "asn":
+		return fmt.Sprintf("%d", probe.ASNv4) == probeReq.Value
+	default:
+		// all probes are in every area
+		return true
+	}
+}
+
+// It assumes s is locked.
+func (s *Server) newMeasurement(def *atlas.MeasurementDefinition, probeIDs []int64) *measurement {
+	now := s.opts.Now().Unix()
+
+	meas := &measurement{
+		probeIDs: probeIDs,
+		rand:     rand.New(rand.NewSource(s.nextMeasID)),
+	}
+	meas.ID = s.nextMeasID
+	meas.Type = def.Type
+	meas.AddressFamily = def.AddressFamily
+	meas.Description = def.Description
+	meas.Interval = def.Interval
+	meas.Target = def.Target
+	meas.TargetIP = fmt.Sprintf("192.0.2.%d", s.nextMeasID%254+1)
+	if def.AddressFamily == atlas.IPv6 {
+		meas.TargetIP = fmt.Sprintf("2001:db8::%x", s.nextMeasID%0xffff+1)
+	}
+	meas.StartTime = def.StartTime
+	if meas.StartTime < now {
+		meas.StartTime = now
+	}
+	meas.StopTime = def.StopTime
+	meas.Status.ID = atlas.MeasurementStatusIDScheduled
+	meas.nextTick = meas.StartTime
+
+	s.nextMeasID++
+	return meas
+}
+
+// It assumes s is locked.
+func (s *Server) stopMeasurement(meas *measurement) {
+	now := s.opts.Now().Unix()
+	if meas.StopTime == 0 || meas.StopTime > now {
+		meas.StopTime = now
+	}
+	meas.Status.ID = atlas.MeasurementStatusIDStopped
+}
+
+// advance generates results of all running measurements up to the current time,
+// charging credits for each result. Measurements are stopped when they reach
+// their stop time, or when credits run out.
+// It assumes s is locked.
+func (s *Server) advance() {
+	now := s.opts.Now().Unix()
+
+	for _, meas := range s.measurements {
+		if meas.Status.ID == atlas.MeasurementStatusIDStopped || meas.StartTime > now {
+			continue
+		}
+		meas.Status.ID = atlas.MeasurementStatusIDOngoing
+
+		until := now
+		if meas.StopTime != 0 && meas.StopTime < until {
+			until = meas.StopTime
+		}
+
+	generate:
+		for ; meas.nextTick <= until; meas.nextTick += meas.Interval {
+			for _, probeID := range meas.probeIDs {
+				if s.credits < httpResultCost {
+					s.stopMeasurement(meas)
+					break generate
+				}
+				s.credits -= httpResultCost
+				meas.results = append(meas.results, s.synthesizeResult(meas, probeID, meas.nextTick))
+			}
+		}
+
+		if meas.StopTime != 0 && meas.StopTime <= now {
+			meas.Status.ID = atlas.MeasurementStatusIDStopped
+		}
+	}
+}
+
+// Each probe gets a stable base latency, with random jitter
+// and an occasional failed request on top of it.
+func (s *Server) synthesizeResult(meas *measurement, probeID int64, timestamp int64) atlas.ProbeMeasurementResults {
+	baseRT := 20 + float64(probeID%7)*15
+
+	result := atlas.Result{
+		BodySize:   1256,
+		HeaderSize: 230,
+		Result:     200,
+		RT:         baseRT + meas.rand.Float64()*baseRT*0.3,
+		SrcAddr:    fmt.Sprintf("198.51.100.%d", probeID%254+1),
+		DstAddr:    meas.TargetIP,
+		Method:     "GET",
+	}
+	if meas.rand.Intn(failureRateOneIn) == 0 {
+		result.Result = 503
+		result.BodySize = 0
+	}
+
+	return atlas.ProbeMeasurementResults{
+		FirmwareVersion: 5000,
+		Timestamp:       timestamp,
+		ProbeID:         probeID,
+		Results:         []atlas.Result{result},
+	}
+}
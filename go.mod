@@ -5,4 +5,6 @@ go 1.16
 require (
 	github.com/gorilla/mux v1.8.0
 	github.com/influxdata/influxdb-client-go/v2 v2.4.0
+	github.com/mattn/go-sqlite3 v1.14.8
+	golang.org/x/net v0.0.0-20210119194325-5f4716e94777
 )
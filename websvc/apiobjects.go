@@ -1,7 +1,10 @@
 package websvc
 
 import (
-	"github.com/influxdata/influxdb-client-go/v2/domain"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
 )
 
 type control struct {
@@ -19,16 +22,99 @@ type creditResp struct {
 	URL            string `json:"url"`
 }
 
+type taskResp struct {
+	Name         string     `json:"name"`
+	Period       string     `json:"period,omitempty"`
+	Schedule     string     `json:"schedule,omitempty"`
+	Iterations   int        `json:"iterations"`
+	Running      bool       `json:"running"`
+	Paused       bool       `json:"paused"`
+	LastRun      *time.Time `json:"last_run,omitempty"`
+	LastDuration string     `json:"last_duration,omitempty"`
+	LastStatus   string     `json:"last_status,omitempty"`
+	NextRun      *time.Time `json:"next_run,omitempty"`
+}
+
+type campaignReq struct {
+	Schedule   string          `json:"schedule"`
+	Spec       json.RawMessage `json:"spec"`
+	MaxCredits int64           `json:"max_credits"`
+}
+
+type campaignResp struct {
+	Name       string                `json:"name"`
+	Schedule   string                `json:"schedule"`
+	Spec       json.RawMessage       `json:"spec"`
+	MaxCredits int64                 `json:"max_credits,omitempty"`
+	Task       string                `json:"task"`
+	History    []*campaignOccurrence `json:"history"`
+}
+
+// campaignOccurrence records a scheduled run of a campaign,
+// and the measurement created by it, if any.
+type campaignOccurrence struct {
+	Time             time.Time `json:"time"`
+	Status           string    `json:"status"`
+	MeasurementID    string    `json:"measurement_id,omitempty"`
+	MeasurementURL   string    `json:"measurement_url,omitempty"`
+	EstimatedCredits int64     `json:"estimated_credits,omitempty"`
+	Reason           string    `json:"reason,omitempty"`
+}
+
+type templateResp struct {
+	Name string          `json:"name"`
+	Spec json.RawMessage `json:"spec"`
+}
+
 type measurementReq struct {
+	Template         string     `json:"template,omitempty"`
+	Type             string     `json:"type"`
+	AddressFamily    afSpec     `json:"af"`
 	Targets          []string   `json:"targets"`
 	ProbeRequests    []probeReq `json:"probe_requests"`
 	Description      string     `json:"description"`
 	StartTimeRFC3339 string     `json:"start_time_rfc3339"`
 	StopTimeRFC3339  string     `json:"stop_time_rfc3339"`
+	Start            string     `json:"start,omitempty"`
+	Stop             string     `json:"stop,omitempty"`
+	Duration         string     `json:"duration,omitempty"`
 	IntervalSec      int64      `json:"interval_sec"`
+	DNS              *dnsOpts   `json:"dns,omitempty"`
 
 	startTimeUnix int64 `json:"-"`
 	stopTimeUnix  int64 `json:"-"`
+	startNow      bool  `json:"-"`
+}
+
+// afSpec specifies requested address families, either as
+// a number (4 or 6) or as a string ("4", "6" or "both").
+type afSpec string
+
+func (af *afSpec) UnmarshalJSON(b []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+
+	switch v := v.(type) {
+	case nil:
+		*af = ""
+	case string:
+		*af = afSpec(v)
+	case float64:
+		*af = afSpec(strconv.FormatFloat(v, 'f', -1, 64))
+	default:
+		return fmt.Errorf("invalid address family value: %s", b)
+	}
+
+	return nil
+}
+
+type dnsOpts struct {
+	QueryClass       string `json:"query_class"`
+	QueryType        string `json:"query_type"`
+	QueryArgument    string `json:"query_argument"`
+	UseProbeResolver bool   `json:"use_probe_resolver"`
 }
 
 type probeReq struct {
@@ -39,6 +125,7 @@ type probeReq struct {
 
 type measurement struct {
 	ID                  string                `json:"id"`
+	Type                string                `json:"type,omitempty"`
 	Status              string                `json:"status"`
 	BucketName          string                `json:"bucket_name,omitempty"`
 	Description         string                `json:"description,omitempty"`
@@ -46,16 +133,23 @@ type measurement struct {
 	Reason              string                `json:"reason,omitempty"`
 	URL                 string                `json:"url,omitempty"`
 
-	backendIDs []int64        `json:"-"`
-	bucket     *domain.Bucket `json:"-"`
+	backendIDs []int64 `json:"-"`
+	hasBucket  bool    `json:"-"`
 }
 
 type backendMeasurement struct {
-	ID       int64  `json:"backend_id"`
-	Target   string `json:"target"`
-	TargetIP string `json:"target_ip"`
+	ID            int64  `json:"backend_id"`
+	Type          string `json:"type"`
+	AddressFamily int32  `json:"af"`
+	Target        string `json:"target"`
+	TargetIP      string `json:"target_ip"`
 
-	startTimeUnix int64 `json:"-"`
-	stopTimeUnix  int64 `json:"-"`
-	stopped       bool  `json:"-"`
+	intervalSec    int64 `json:"-"`
+	startTimeUnix  int64 `json:"-"`
+	stopTimeUnix   int64 `json:"-"`
+	lastResultUnix int64 `json:"-"`
+	stopped        bool  `json:"-"`
+
+	// consecutive iterations in which some results failed to be stored
+	failedIterations int `json:"-"`
 }
@@ -0,0 +1,60 @@
+package websvc
+
+import (
+	"crypto/sha256"
+	"net/http"
+	"strings"
+
+	"github.com/cicovic-andrija/dante/conf"
+)
+
+const bearerPrefix = "Bearer "
+
+// Ranks of API key roles; a role grants access to routes
+// that require it or a lower ranked role.
+var roleRank = map[string]int{
+	conf.RoleViewer:   1,
+	conf.RoleOperator: 2,
+	conf.RoleAdmin:    3,
+}
+
+// routeAccess specifies the minimum role needed to access a route, per HTTP method.
+type routeAccess map[string]string
+
+// apiKeyTable maps SHA-256 digests of API keys to their clients,
+// so that looking up a key does not depend on its content byte by byte.
+type apiKeyTable map[[sha256.Size]byte]*conf.APIKey
+
+func newAPIKeyTable(keys []conf.APIKey) apiKeyTable {
+	table := make(apiKeyTable, len(keys))
+	for i := range keys {
+		table[sha256.Sum256([]byte(keys[i].Key))] = &keys[i]
+	}
+	return table
+}
+
+func (t apiKeyTable) lookup(key string) (*conf.APIKey, bool) {
+	if key == "" {
+		return nil, false
+	}
+	apiKey, ok := t[sha256.Sum256([]byte(key))]
+	return apiKey, ok
+}
+
+// Returns the bearer token from the Authorization header of a request,
+// or an empty string if there is none.
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if len(header) < len(bearerPrefix) || !strings.EqualFold(header[:len(bearerPrefix)], bearerPrefix) {
+		return ""
+	}
+	return strings.TrimSpace(header[len(bearerPrefix):])
+}
+
+// Context key under which the name of an authenticated client is stored.
+type clientCtxKey struct{}
+
+func requestClient(r *http.Request) (string, bool) {
+	client, ok := r.Context().Value(clientCtxKey{}).(string)
+	return client, ok
+}
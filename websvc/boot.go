@@ -33,6 +33,7 @@ func Start() {
 
 	// run an interrupt handler in a separate thread
 	go srv.interruptHandler()
+	go srv.reloadHandler()
 
 	// wait for the shutdown request and shut down the server
 	<-srv.shutdownC
@@ -0,0 +1,454 @@
+package websvc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cicovic-andrija/dante/atlas"
+	"github.com/cicovic-andrija/dante/db"
+)
+
+const (
+	campaignTaskPrefix   = "campaign-"
+	campaignMeasDescrFmt = "%s (campaign %s, %s)"
+	measurementURLPrefix = "/api/measurements/"
+
+	// only the most recent occurrences are kept in campaign history
+	maxCampaignHistory = 100
+)
+
+// campaign is a measurement request created again at each occurrence
+// of a cron schedule. Its state, including history, is persisted as JSON.
+type campaign struct {
+	Schedule   string                `json:"schedule"`
+	Spec       json.RawMessage       `json:"spec"`
+	MaxCredits int64                 `json:"max_credits,omitempty"`
+	History    []*campaignOccurrence `json:"history"`
+
+	schedule *cronSchedule `json:"-"`
+}
+
+// campaignTable holds measurement campaigns, as loaded from the database
+// on boot and updated through the API and by campaign tasks.
+type campaignTable struct {
+	sync.RWMutex
+
+	// serializes updates of campaigns with persisting their state,
+	// so that states are persisted in the order of updates
+	persisting sync.Mutex
+
+	campaigns map[string]*campaign
+}
+
+func newCampaignTable(campaigns []db.MeasurementCampaign) (*campaignTable, error) {
+	t := &campaignTable{
+		campaigns: make(map[string]*campaign, len(campaigns)),
+	}
+	for _, record := range campaigns {
+		camp := &campaign{}
+		if err := json.Unmarshal([]byte(record.State), camp); err != nil {
+			return nil, fmt.Errorf("campaign %s corrupted: %v", record.Name, err)
+		}
+		sched, err := parseCronSchedule(camp.Schedule)
+		if err != nil {
+			return nil, fmt.Errorf("campaign %s corrupted: %v", record.Name, err)
+		}
+		camp.schedule = sched
+		t.campaigns[record.Name] = camp
+	}
+	return t, nil
+}
+
+// Returns a copy of a campaign, safe to use without the lock.
+func (t *campaignTable) get(name string) (*campaign, bool) {
+	t.RLock()
+	defer t.RUnlock()
+	camp, ok := t.campaigns[name]
+	if !ok {
+		return nil, false
+	}
+	return camp.copy(), true
+}
+
+// Returns all campaigns, ordered by name.
+func (t *campaignTable) getAll() []*campaignResp {
+	t.RLock()
+	defer t.RUnlock()
+	campaigns := make([]*campaignResp, 0, len(t.campaigns))
+	for name, camp := range t.campaigns {
+		campaigns = append(campaigns, camp.copy().resp(name))
+	}
+	sort.Slice(campaigns, func(i, j int) bool { return campaigns[i].Name < campaigns[j].Name })
+	return campaigns
+}
+
+// Returns schedules of all campaigns, mapped by campaign name.
+func (t *campaignTable) schedules() map[string]*cronSchedule {
+	t.RLock()
+	defer t.RUnlock()
+	schedules := make(map[string]*cronSchedule, len(t.campaigns))
+	for name, camp := range t.campaigns {
+		schedules[name] = camp.schedule
+	}
+	return schedules
+}
+
+// Stores a campaign definition, and persists its state. A campaign that
+// replaces another one with the same name keeps its history. If the state
+// fails to be persisted, the replaced campaign is put back.
+// Returns true if a campaign is replaced.
+func (t *campaignTable) replace(name string, camp *campaign, persist func(db.MeasurementCampaign) error) (replaced bool, err error) {
+	t.persisting.Lock()
+	defer t.persisting.Unlock()
+
+	t.Lock()
+	old, replaced := t.campaigns[name]
+	if replaced {
+		camp.History = append([]*campaignOccurrence(nil), old.History...)
+	}
+	record, err := camp.record(name)
+	if err == nil {
+		t.campaigns[name] = camp
+	}
+	t.Unlock()
+	if err != nil {
+		return
+	}
+
+	if err = persist(record); err != nil {
+		t.Lock()
+		if replaced {
+			t.campaigns[name] = old
+		} else {
+			delete(t.campaigns, name)
+		}
+		t.Unlock()
+	}
+	return
+}
+
+// Deletes a campaign, once its state is deleted with persist.
+func (t *campaignTable) remove(name string, persist func(string) error) (found bool, err error) {
+	t.persisting.Lock()
+	defer t.persisting.Unlock()
+
+	t.RLock()
+	_, found = t.campaigns[name]
+	t.RUnlock()
+	if !found {
+		return
+	}
+
+	if err = persist(name); err != nil {
+		return
+	}
+
+	t.Lock()
+	delete(t.campaigns, name)
+	t.Unlock()
+	return
+}
+
+// Appends an occurrence to the history of a campaign, and persists its state.
+// Returns false if the campaign no longer exists.
+func (t *campaignTable) addOccurrence(name string, occ *campaignOccurrence, persist func(db.MeasurementCampaign) error) (found bool, err error) {
+	t.persisting.Lock()
+	defer t.persisting.Unlock()
+
+	t.Lock()
+	camp, found := t.campaigns[name]
+	if !found {
+		t.Unlock()
+		return
+	}
+	camp.History = append(camp.History, occ)
+	if n := len(camp.History); n > maxCampaignHistory {
+		camp.History = append([]*campaignOccurrence(nil), camp.History[n-maxCampaignHistory:]...)
+	}
+	record, err := camp.record(name)
+	t.Unlock()
+	if err != nil {
+		return true, err
+	}
+
+	return true, persist(record)
+}
+
+func (c *campaign) copy() *campaign {
+	camp := *c
+	camp.History = append([]*campaignOccurrence(nil), c.History...)
+	return &camp
+}
+
+func (c *campaign) record(name string) (db.MeasurementCampaign, error) {
+	state, err := json.Marshal(c)
+	if err != nil {
+		return db.MeasurementCampaign{}, err
+	}
+	return db.MeasurementCampaign{Name: name, State: string(state)}, nil
+}
+
+func (c *campaign) resp(name string) *campaignResp {
+	history := c.History
+	if history == nil {
+		history = []*campaignOccurrence{}
+	}
+	return &campaignResp{
+		Name:       name,
+		Schedule:   c.Schedule,
+		Spec:       c.Spec,
+		MaxCredits: c.MaxCredits,
+		Task:       campaignTaskPrefix + name,
+		History:    history,
+	}
+}
+
+// Estimates the credits a measurement spends over its lifetime, assuming that
+// all requested probes are allocated and report a result in each interval.
+// Returns 0 for measurements without stop time.
+func estimateCredits(req *measurementReq) int64 {
+	if req.stopTimeUnix == 0 {
+		return 0
+	}
+
+	var probes int64
+	for _, probeReq := range req.ProbeRequests {
+		probes += probeReq.Requested
+	}
+
+	definitions := int64(len(req.Targets))
+	if req.Type == atlas.MeasDNS && req.DNS.UseProbeResolver {
+		definitions = 1
+	}
+	definitions *= int64(len(req.AddressFamily.families()))
+
+	results := (req.stopTimeUnix-req.startTimeUnix)/req.IntervalSec + 1
+	return definitions * probes * results * atlas.ResultCredits[req.Type]
+}
+
+// Resolves and validates the measurement request of a campaign. Times must be
+// relative, since the request is resolved again at each occurrence.
+// Returns a client-facing error message if the request is invalid.
+func (s *server) campaignMeasurementReq(camp *campaign) (*measurementReq, bool, string, error) {
+	req := &measurementReq{}
+	ok, errMsg, err := s.resolveMeasurementReq(camp.Spec, req)
+	if err != nil || !ok {
+		return nil, false, errMsg, err
+	}
+	if req.StartTimeRFC3339 != "" || req.StopTimeRFC3339 != "" ||
+		!relativeTimeSpec(req.Start) || !relativeTimeSpec(req.Stop) {
+		return nil, false, CFCampaignAbsoluteTime, nil
+	}
+	if ok, errMsg = s.validateMeasurementReq(req); !ok {
+		return nil, false, errMsg, nil
+	}
+	return req, true, "", nil
+}
+
+func (s *server) scheduleCampaign(name string, sched *cronSchedule) {
+	s.taskManager.scheduleTask(
+		&timerTask{
+			name:     campaignTaskPrefix + name,
+			execute:  s.runCampaign,
+			schedule: sched,
+			log:      s.log,
+		},
+		name,
+	)
+}
+
+// Timer task: creates a measurement of a campaign at an occurrence of its
+// schedule, unless the estimated cost exceeds the credit cap of the campaign.
+// The occurrence is recorded in the campaign history.
+func (s *server) runCampaign(ctx context.Context, args ...interface{}) (string, bool) {
+	name := args[0].(string)
+	camp, ok := s.campaigns.get(name)
+	if !ok {
+		return timerTaskFailure(fmt.Errorf("campaign %s not found", name))
+	}
+
+	occ := s.startCampaignOccurrence(name, camp)
+
+	if _, err := s.campaigns.addOccurrence(name, occ, s.database.WriteMeasurementCampaign); err != nil {
+		s.log.err("[campaign %s] failed to persist history: %v", name, err)
+	}
+
+	switch occ.Status {
+	case CFStatusFailed:
+		return timerTaskFailure(errors.New(occ.Reason))
+	case CFStatusSkipped:
+		return timerTaskSuccess(fmt.Sprintf("occurrence skipped: %s", occ.Reason))
+	default:
+		return timerTaskSuccess(fmt.Sprintf("measurement %s created", occ.MeasurementID))
+	}
+}
+
+func (s *server) startCampaignOccurrence(name string, camp *campaign) *campaignOccurrence {
+	occ := &campaignOccurrence{Time: time.Now().UTC(), Status: CFStatusFailed}
+
+	req, ok, errMsg, err := s.campaignMeasurementReq(camp)
+	if err != nil {
+		s.log.err("[campaign %s] %v", name, err)
+		occ.Reason = CFCampaignOccurrenceFailed
+		return occ
+	}
+	if !ok {
+		occ.Reason = errMsg
+		return occ
+	}
+
+	// a template the campaign refers to may have been changed
+	// since the campaign was created, so the cap is checked again
+	if camp.MaxCredits > 0 && req.stopTimeUnix == 0 {
+		occ.Status = CFStatusSkipped
+		occ.Reason = CFCampaignOpenEnded
+		s.log.info("[campaign %s] occurrence skipped: measurement has no stop time", name)
+		return occ
+	}
+
+	occ.EstimatedCredits = estimateCredits(req)
+	if camp.MaxCredits > 0 && occ.EstimatedCredits > camp.MaxCredits {
+		occ.Status = CFStatusSkipped
+		occ.Reason = fmt.Sprintf(CFCreditCapExceededFmt, occ.EstimatedCredits, camp.MaxCredits)
+		s.log.info("[campaign %s] occurrence skipped: estimated cost %d over cap %d",
+			name, occ.EstimatedCredits, camp.MaxCredits)
+		return occ
+	}
+
+	id, err := freshMeasurementID()
+	if err != nil {
+		s.log.err("[campaign %s] %v", name, err)
+		occ.Reason = CFCampaignOccurrenceFailed
+		return occ
+	}
+	occ.MeasurementID = id
+	occ.MeasurementURL = measurementURLPrefix + id
+
+	req.Description = fmt.Sprintf(campaignMeasDescrFmt, req.Description, name, occ.Time.Format(time.RFC3339))
+	s.log.info("[campaign %s] creating measurement %s", name, id)
+	s.measurementCreationWorkflow(req, id)
+
+	if meas, ok := s.measCache.get(id); ok {
+		occ.Status = meas.Status
+		occ.Reason = meas.Reason
+	}
+	return occ
+}
+
+func (s *server) campaignsHandler(w http.ResponseWriter, r *http.Request) {
+	// HTTP GET
+	s.httpWriteResponseObject(w, r, http.StatusOK, s.campaigns.getAll())
+}
+
+func (s *server) singleCampaignHandler(w http.ResponseWriter, r *http.Request, routeVars map[string]string) {
+	name := routeVars[namePathVariable]
+
+	switch r.Method {
+	// HTTP GET
+	case http.MethodGet:
+		if camp, ok := s.campaigns.get(name); ok {
+			s.httpWriteResponseObject(w, r, http.StatusOK, camp.resp(name))
+		} else {
+			s.httpWriteResponseObject(w, r, http.StatusNotFound, ResourceNotFound)
+		}
+
+	// HTTP PUT
+	case http.MethodPut:
+		campReq := &campaignReq{}
+		if ok := s.decodeReqBody(w, r, campReq); !ok {
+			return
+		}
+
+		camp, ok, errMsg, err := s.readCampaign(campReq)
+		if err != nil {
+			s.internalServerError(w, r, err)
+			return
+		}
+		if !ok {
+			s.badRequest(w, r, errMsg)
+			return
+		}
+
+		replaced, err := s.campaigns.replace(name, camp, s.database.WriteMeasurementCampaign)
+		if err != nil {
+			s.internalServerError(w, r, err)
+			return
+		}
+
+		s.scheduleCampaign(name, camp.schedule)
+		s.log.info("[campaign %s] stored, schedule: %s", name, camp.Schedule)
+
+		status := http.StatusCreated
+		if replaced {
+			status = http.StatusOK
+		}
+		resp, _ := s.campaigns.get(name)
+		s.httpWriteResponseObject(w, r, status, resp.resp(name))
+
+	// HTTP DELETE
+	case http.MethodDelete:
+		found, err := s.campaigns.remove(name, s.database.DeleteMeasurementCampaign)
+		if err != nil {
+			s.internalServerError(w, r, err)
+			return
+		}
+		if !found {
+			s.httpWriteResponseObject(w, r, http.StatusNotFound, ResourceNotFound)
+			return
+		}
+
+		// measurements created by the campaign are not affected
+		s.taskManager.stopTask(campaignTaskPrefix + name)
+		s.log.info("[campaign %s] deleted", name)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Builds a campaign from a request. The measurement request of the campaign
+// is validated as if it were created now, and must fit under the credit cap.
+// Returns a client-facing error message if the request is invalid.
+func (s *server) readCampaign(campReq *campaignReq) (*campaign, bool, string, error) {
+	sched, err := parseCronSchedule(campReq.Schedule)
+	if err != nil {
+		return nil, false, fmt.Sprintf(CFInvalidScheduleFmt, err), nil
+	}
+
+	if campReq.MaxCredits < 0 {
+		return nil, false, CFInvalidCreditCap, nil
+	}
+
+	spec := &bytes.Buffer{}
+	if err = json.Compact(spec, campReq.Spec); err != nil {
+		return nil, false, CFReqDecodingFailed, nil
+	}
+
+	camp := &campaign{
+		Schedule:   campReq.Schedule,
+		Spec:       spec.Bytes(),
+		MaxCredits: campReq.MaxCredits,
+		schedule:   sched,
+	}
+
+	req, ok, errMsg, err := s.campaignMeasurementReq(camp)
+	if err != nil || !ok {
+		return nil, false, errMsg, err
+	}
+
+	if camp.MaxCredits > 0 {
+		if req.stopTimeUnix == 0 {
+			return nil, false, CFCampaignOpenEnded, nil
+		}
+		if estimate := estimateCredits(req); estimate > camp.MaxCredits {
+			return nil, false, fmt.Sprintf(CFCreditCapExceededFmt, estimate, camp.MaxCredits), nil
+		}
+	}
+
+	return camp, true, "", nil
+}
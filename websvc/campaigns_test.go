@@ -0,0 +1,113 @@
+package websvc
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/cicovic-andrija/dante/db"
+)
+
+// Occurrences added while a replaced campaign is being persisted
+// are kept in the history of the new definition.
+func TestCampaignReplaceKeepsHistory(t *testing.T) {
+	table, err := newCampaignTable(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		mu        sync.Mutex
+		persisted db.MeasurementCampaign
+	)
+	persist := func(record db.MeasurementCampaign) error {
+		mu.Lock()
+		persisted = record
+		mu.Unlock()
+		return nil
+	}
+
+	if _, err = table.replace("c", &campaign{Schedule: "0 * * * *"}, persist); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = table.addOccurrence("c", &campaignOccurrence{MeasurementID: "1"}, persist); err != nil {
+		t.Fatal(err)
+	}
+
+	started, release := make(chan struct{}), make(chan struct{})
+	replaced := make(chan bool)
+	go func() {
+		ok, err := table.replace("c", &campaign{Schedule: "30 * * * *"}, func(record db.MeasurementCampaign) error {
+			close(started)
+			<-release
+			return persist(record)
+		})
+		if err != nil {
+			t.Error(err)
+		}
+		replaced <- ok
+	}()
+
+	<-started
+	added := make(chan struct{})
+	go func() {
+		if _, err := table.addOccurrence("c", &campaignOccurrence{MeasurementID: "2"}, persist); err != nil {
+			t.Error(err)
+		}
+		close(added)
+	}()
+	close(release)
+	if !<-replaced {
+		t.Error("campaign not replaced")
+	}
+	<-added
+
+	camp, _ := table.get("c")
+	if camp.Schedule != "30 * * * *" || len(camp.History) != 2 || camp.History[1].MeasurementID != "2" {
+		t.Errorf("campaign = %+v, want the new definition with both occurrences", camp)
+	}
+
+	stored := &campaign{}
+	if err = json.Unmarshal([]byte(persisted.State), stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.Schedule != "30 * * * *" || len(stored.History) != 2 {
+		t.Errorf("persisted campaign = %+v, want the new definition with both occurrences", stored)
+	}
+}
+
+// A definition that fails to be persisted is not kept.
+func TestCampaignReplacePersistFailure(t *testing.T) {
+	table, err := newCampaignTable(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok := func(db.MeasurementCampaign) error { return nil }
+	fail := func(db.MeasurementCampaign) error { return errors.New("write failed") }
+
+	if _, err = table.replace("new", &campaign{Schedule: "0 * * * *"}, fail); err == nil {
+		t.Error("persist error not returned")
+	}
+	if _, found := table.get("new"); found {
+		t.Error("campaign stored after it failed to be persisted")
+	}
+
+	if _, err = table.replace("c", &campaign{Schedule: "0 * * * *"}, ok); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = table.replace("c", &campaign{Schedule: "30 * * * *"}, fail); err == nil {
+		t.Error("persist error not returned")
+	}
+	if camp, _ := table.get("c"); camp.Schedule != "0 * * * *" {
+		t.Errorf("schedule = %q, want the replaced definition", camp.Schedule)
+	}
+
+	failDelete := func(string) error { return errors.New("delete failed") }
+	if found, err := table.remove("c", failDelete); !found || err == nil {
+		t.Errorf("remove = (%t, %v), want the error", found, err)
+	}
+	if _, found := table.get("c"); !found {
+		t.Error("campaign removed after it failed to be deleted")
+	}
+}
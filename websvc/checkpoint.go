@@ -0,0 +1,35 @@
+package websvc
+
+import "sync"
+
+// checkpointTable holds timestamps of the latest stored results
+// of backend measurements, as loaded from the database on boot.
+type checkpointTable struct {
+	sync.RWMutex
+
+	checkpoints map[int64]int64
+}
+
+func newCheckpointTable(checkpoints map[int64]int64) *checkpointTable {
+	if checkpoints == nil {
+		checkpoints = make(map[int64]int64)
+	}
+	return &checkpointTable{
+		checkpoints: checkpoints,
+	}
+}
+
+// Returns 0 if there is no checkpoint for a backend measurement.
+func (t *checkpointTable) get(backendID int64) int64 {
+	t.RLock()
+	defer t.RUnlock()
+	return t.checkpoints[backendID]
+}
+
+func (t *checkpointTable) delete(backendIDs ...int64) {
+	t.Lock()
+	defer t.Unlock()
+	for _, id := range backendIDs {
+		delete(t.checkpoints, id)
+	}
+}
@@ -1,7 +1,7 @@
 package websvc
 
 import (
-	"fmt"
+	"context"
 	"net/http"
 	"strconv"
 	"strings"
@@ -11,27 +11,54 @@ import (
 
 // Client-facing messages and message formats in API response objects.
 const (
+	CFAPIKeyRequired             = "A valid API key is required."
+	CFCampaignAbsoluteTime       = "Campaign times must be relative: now, an offset such as +1h, or a duration."
+	CFCampaignOccurrenceFailed   = "Occurrence failed because of a system error."
+	CFCampaignOpenEnded          = "A credit cap requires a stop time or duration."
+	CFConflictingFieldsFmt       = "Only one of %s and %s can be specified."
 	CFCreationFailedFmt          = "Measurement %s creation failed: %s."
 	CFCreationFailedSystemFmt    = "Measurement %s creation failed because of a system error."
+	CFCreditCapExceededFmt       = "Estimated cost of %d credits exceeds the cap of %d credits per occurrence."
+	CFDNSOptionsNotSpecified     = "DNS options must be specified for DNS measurements."
+	CFDurationWithStopTime       = "Duration cannot be specified together with stop time."
 	CFEmptyDescriptionInRequest  = "Description cannot be empty string."
+	CFEmptyQueryArgument         = "DNS query argument cannot be empty string."
 	CFEmptyTargetInRequest       = "Target cannot be empty string."
 	CFEndpointNotFound           = "Endpoint not found."
 	CFEndTimeBeforeStartTime     = "Stop time cannot be a value earlier than start time."
-	CFEndTimeNotSpecified        = "Stop time not specified."
+	CFGroupByWithoutAggregate    = "Results can be grouped only if an aggregate is specified."
 	CFInternalServerErrorFmt     = "Request %s %s failed because of an internal server error."
 	CFIntervalValueTooLarge      = "Interval value too large for the specified time window."
+	CFInvalidAddressFamilyFmt    = "Address family must be one of: %s"
+	CFInvalidAggregateFieldFmt   = "Only numeric fields can be aggregated: %s"
+	CFInvalidAggregateFmt        = "Aggregate must be one of: %s"
+	CFInvalidCreditCap           = "Credit cap must be a non-negative integer."
+	CFInvalidDNSQueryClassFmt    = "DNS query class must be one of: %s"
+	CFInvalidDNSQueryTypeFmt     = "DNS query type must be one of: %s"
+	CFInvalidDurationValueFmt    = "Failed to parse duration value: %s."
+	CFInvalidFieldFmt            = "Field must be one of: %s"
+	CFInvalidGroupByFmt          = "Results can only be grouped by: %s"
 	CFInvalidIntervalValue       = "Interval value not specified or invalid. Value must be a positive integer."
+	CFInvalidLimitValue          = "Limit must be a positive integer."
+	CFInvalidMeasurementTypeFmt  = "Measurement type must be one of: %s"
 	CFInvalidNumberOfProbes      = "Number of requested probes must be a positive integer."
 	CFInvalidOperationFmt        = "Operation %s is invalid."
 	CFInvalidProbeRequestTypeFmt = "Probe request type must be one of: %s"
+	CFInvalidScheduleFmt         = "Failed to parse schedule: %v."
 	CFInvalidTimeValueFmt        = "Failed to parse time value: %s."
+	CFMeasurementNoResults       = "This measurement has no results."
 	CFMeasurementNoStop          = "This measurement cannot be stopped."
 	CFMethodNotAllowedFmt        = "Method %s is not allowed."
 	CFProbeRequestNotSpecified   = "At least one probe request must be specified."
 	CFReqDecodingFailed          = "Failed to decode request body."
 	CFResourceNotFound           = "Resource not found."
+	CFRoleRequiredFmt            = "Role %s or higher is required."
 	CFStartTimeNotSpecified      = "Start time not specified."
 	CFTargetNotSpecified         = "At least one target must be specified."
+	CFTargetWithProbeResolver    = "Targets cannot be specified when probe resolvers are used."
+	CFTaskRunning                = "This task is already running."
+	CFTemplateInTemplate         = "Templates cannot refer to other templates."
+	CFTemplateNotFoundFmt        = "Template %s not found."
 
 	CFStatusSuccess = "Success."
 
@@ -39,36 +66,30 @@ const (
 	CFStatusOngoing   = "Ongoing."
 	CFStatusQueued    = "Queued."
 	CFStatusScheduled = "Scheduled."
+	CFStatusSkipped   = "Skipped."
 	CFStatusStopped   = "Stopped."
 )
 
 // This request is issued in multiple places,
 // thus putting common code in a separate method.
-func (s *server) httpGetCredits() (*atlas.Credit, error) {
+func (s *server) httpGetCredits(ctx context.Context) (*atlas.Credit, error) {
 	var (
-		reqParams = &atlas.ReqParams{Method: http.MethodGet, Key: cfg.Atlas.Auth.Key}
+		reqParams = &atlas.ReqParams{Method: http.MethodGet}
 		credit    = &atlas.Credit{}
 		req       *http.Request
 		err       error
 	)
 
-	if req, err = atlas.PrepareRequest(atlas.CreditsEndpoint, reqParams); err != nil {
+	if req, err = s.atlas.PrepareRequest(s.atlas.CreditsURL(), reqParams); err != nil {
 		return nil, err
 	}
 
-	if err = s.makeRequest(req, credit); err != nil {
+	if err = s.atlas.Do(ctx, req, credit); err != nil {
 		return nil, err
 	}
 
-	if credit.Error != nil {
-		err = fmt.Errorf(
-			"client request failed (%s %d): %s",
-			credit.Error.Title, credit.Error.Status, credit.Error.Detail,
-		)
-		credit = nil
-	}
-
-	return credit, err
+	s.metrics.creditBalance.set(float64(credit.CurrentBalance))
+	return credit, nil
 }
 
 // help functions
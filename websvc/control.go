@@ -6,6 +6,9 @@ import (
 
 // Control constants.
 const (
+	OperationPause    = "pause"
+	OperationResume   = "resume"
+	OperationRunNow   = "run-now"
 	OperationShutdown = "shutdown"
 	OperationStop     = "stop"
 
@@ -28,3 +31,46 @@ func (s *server) controlHandler(w http.ResponseWriter, r *http.Request) {
 
 	s.httpWriteResponseObject(w, r, http.StatusOK, &status{Status: CFStatusSuccess})
 }
+
+func (s *server) tasksHandler(w http.ResponseWriter, r *http.Request) {
+	// HTTP GET
+	s.httpWriteResponseObject(w, r, http.StatusOK, s.taskManager.describe())
+}
+
+func (s *server) taskControlHandler(w http.ResponseWriter, r *http.Request, routeVars map[string]string) {
+	// HTTP POST
+	ctrl := &control{}
+	if ok := s.decodeReqBody(w, r, ctrl); !ok {
+		return
+	}
+
+	var (
+		name = routeVars[namePathVariable]
+		err  error
+	)
+
+	switch ctrl.Operation {
+	case OperationRunNow:
+		err = s.taskManager.runNow(name)
+	case OperationPause:
+		err = s.taskManager.pause(name)
+	case OperationResume:
+		err = s.taskManager.resume(name)
+	default:
+		s.badRequest(w, r, CFInvalidOperationFmt, ctrl.Operation)
+		return
+	}
+
+	switch err {
+	case nil:
+		s.log.info("[task %s] %s requested", name, ctrl.Operation)
+		s.httpWriteResponseObject(w, r, http.StatusOK, &status{Status: CFStatusSuccess})
+	case errTaskNotFound:
+		s.httpWriteResponseObject(w, r, http.StatusNotFound, ResourceNotFound)
+	case errTaskRunning:
+		s.httpWriteResponseObject(w, r, http.StatusConflict,
+			&status{Status: CFStatusFailed, Explanation: CFTaskRunning})
+	default:
+		s.internalServerError(w, r, err)
+	}
+}
@@ -0,0 +1,182 @@
+package websvc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Shorthands accepted in place of a five-field cron expression.
+var cronShorthands = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// an occurrence is searched for at most this far ahead
+const cronSearchLimitYears = 5
+
+// cronField is a set of values allowed in a field of a cron expression.
+type cronField uint64
+
+func (f cronField) has(v int) bool {
+	return f&(1<<uint(v)) != 0
+}
+
+// cronSchedule is a schedule specified by a cron expression with five fields:
+// minute, hour, day of month, month and day of week. Each field is either "*",
+// or a list of values and ranges, optionally with a step, such as "1-5" or "*/15".
+// Day of week is 0-7, where both 0 and 7 are Sunday. Times are in UTC.
+type cronSchedule struct {
+	expr    string
+	minutes cronField
+	hours   cronField
+	doms    cronField
+	months  cronField
+	dows    cronField
+
+	// day of month and day of week are unrestricted, i.e. start with "*"
+	domAny bool
+	dowAny bool
+}
+
+func parseCronSchedule(expr string) (*cronSchedule, error) {
+	spec := expr
+	if full, ok := cronShorthands[spec]; ok {
+		spec = full
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected 5 fields, found %d", len(fields))
+	}
+
+	var (
+		sched = &cronSchedule{expr: expr}
+		err   error
+	)
+	if sched.minutes, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("minute: %v", err)
+	}
+	if sched.hours, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("hour: %v", err)
+	}
+	if sched.doms, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("day of month: %v", err)
+	}
+	if sched.months, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("month: %v", err)
+	}
+	if sched.dows, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("day of week: %v", err)
+	}
+	if sched.dows.has(7) {
+		sched.dows |= 1
+	}
+	sched.domAny = strings.HasPrefix(fields[2], "*")
+	sched.dowAny = strings.HasPrefix(fields[4], "*")
+
+	if sched.next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("schedule never occurs")
+	}
+	return sched, nil
+}
+
+func parseCronField(field string, min int, max int) (cronField, error) {
+	var set cronField
+	for _, part := range strings.Split(field, ",") {
+		var (
+			rng  = part
+			step = 1
+			lo   = min
+			hi   = max
+			err  error
+		)
+
+		if i := strings.Index(part, "/"); i >= 0 {
+			rng = part[:i]
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+		}
+
+		if rng != "*" {
+			bounds := strings.SplitN(rng, "-", 2)
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid value in %q", part)
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid value in %q", part)
+				}
+			} else if step > 1 {
+				// "5/15" means from 5 to the maximum, every 15
+				hi = max
+			}
+		}
+
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q is out of range %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+// Day of month and day of week are combined as in cron:
+// if both are restricted, a day matching either of them matches.
+func (c *cronSchedule) dayMatches(t time.Time) bool {
+	dom := c.doms.has(t.Day())
+	dow := c.dows.has(int(t.Weekday()))
+	switch {
+	case c.domAny && c.dowAny:
+		return true
+	case c.domAny:
+		return dow
+	case c.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+// next returns the first occurrence strictly after a given time,
+// or the zero time if the schedule does not occur in the next few years.
+func (c *cronSchedule) next(after time.Time) time.Time {
+	t := after.UTC().Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(cronSearchLimitYears, 0, 0)
+
+	for t.Before(limit) {
+		if !c.months.has(int(t.Month())) {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !c.hours.has(t.Hour()) {
+			t = t.Truncate(time.Hour).Add(time.Hour)
+			continue
+		}
+		if !c.minutes.has(t.Minute()) {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+
+	return time.Time{}
+}
+
+func (c *cronSchedule) String() string {
+	return c.expr
+}
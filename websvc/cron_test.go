@@ -0,0 +1,36 @@
+package websvc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronScheduleNext(t *testing.T) {
+	// Saturday, 2024-06-01 12:00 UTC
+	after := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"*/15 * * * *", time.Date(2024, 6, 1, 12, 15, 0, 0, time.UTC)},
+		{"@daily", time.Date(2024, 6, 2, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 1", time.Date(2024, 6, 3, 0, 0, 0, 0, time.UTC)},
+		// day of month or day of week, if both are restricted
+		{"0 0 15 * 1", time.Date(2024, 6, 3, 0, 0, 0, 0, time.UTC)},
+		// a step over all days does not restrict day of month
+		{"0 0 */2 * 2", time.Date(2024, 6, 4, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 */2 *", time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, test := range tests {
+		sched, err := parseCronSchedule(test.expr)
+		if err != nil {
+			t.Errorf("%q: %v", test.expr, err)
+			continue
+		}
+		if got := sched.next(after); !got.Equal(test.want) {
+			t.Errorf("%q: next = %v, want %v", test.expr, got, test.want)
+		}
+	}
+}
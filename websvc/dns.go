@@ -0,0 +1,113 @@
+package websvc
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cicovic-andrija/dante/atlas"
+	"github.com/cicovic-andrija/dante/db"
+	"github.com/cicovic-andrija/dante/util"
+)
+
+func validateDNSOpts(req *measurementReq) (bool, string) {
+	if req.DNS == nil {
+		return false, CFDNSOptionsNotSpecified
+	}
+
+	if req.DNS.QueryArgument == "" {
+		return false, CFEmptyQueryArgument
+	}
+
+	if req.DNS.QueryClass == "" {
+		req.DNS.QueryClass = atlas.ValidDNSQueryClasses[0]
+	}
+	req.DNS.QueryClass = strings.ToUpper(req.DNS.QueryClass)
+	if found := util.SearchForString(req.DNS.QueryClass, atlas.ValidDNSQueryClasses...); !found {
+		return false, fmt.Sprintf(CFInvalidDNSQueryClassFmt, atlas.ValidDNSQueryClassesStr)
+	}
+
+	req.DNS.QueryType = strings.ToUpper(req.DNS.QueryType)
+	if found := util.SearchForString(req.DNS.QueryType, atlas.ValidDNSQueryTypes...); !found {
+		return false, fmt.Sprintf(CFInvalidDNSQueryTypeFmt, atlas.ValidDNSQueryTypesStr)
+	}
+
+	return true, ""
+}
+
+func (s *server) processProbeDNSResults(ctx context.Context, probeResults *atlas.ProbeDNSResults, backend *backendMeasurement, bucketName string) error {
+	var (
+		probe *atlas.Probe
+		err   error
+	)
+
+	if probe, err = s.getProbe(ctx, probeResults.ProbeID); err != nil {
+		return fmt.Errorf("probe info request failed for probe %d and measurement %d: %w", probeResults.ProbeID, backend.ID, err)
+	}
+
+	for _, response := range probeResults.Responses() {
+		timestamp := response.Timestamp
+		if timestamp == 0 {
+			timestamp = probeResults.Timestamp
+		}
+
+		dnsData := &db.DNSData{
+			BackendID:     backend.ID,
+			AddressFamily: backend.AddressFamily,
+			ProbeID:       probe.ID,
+			ASN:           probe.ASN(backend.AddressFamily),
+			Country:       probe.CountryCode,
+			Target:        backend.Target,
+			TargetIP:      backend.TargetIP,
+			Resolver:      response.DstAddr,
+			Timestamp:     time.Unix(timestamp, 0),
+		}
+
+		if response.Result == nil {
+			dnsData.Error = formatDNSError(response.Error)
+		} else if msg, err := response.Result.Decode(); err != nil {
+			dnsData.Error = err.Error()
+		} else {
+			dnsData.ResponseTime = response.Result.RT
+			dnsData.ResponseSize = response.Result.Size
+			dnsData.RCode = msg.RCode
+			for _, answer := range msg.Answers {
+				dnsData.Answers = append(dnsData.Answers, db.DNSAnswerData{
+					Name: answer.Name,
+					Type: answer.Type,
+					TTL:  int64(answer.TTL),
+					Data: answer.Data,
+				})
+			}
+		}
+
+		if err = s.database.WriteDNSMeasurementResult(bucketName, dnsData); err != nil {
+			// do not continue, assume others will fail too
+			return fmt.Errorf("writing data point failed for %d: %v", backend.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// Formats an error object from a DNS result, e.g. {"timeout": 5000},
+// as a string with a stable order of keys.
+func formatDNSError(errObj map[string]interface{}) string {
+	if len(errObj) == 0 {
+		return "no response"
+	}
+
+	keys := make([]string, 0, len(errObj))
+	for k := range errObj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("%s: %v", k, errObj[k]))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -1,11 +1,17 @@
 package websvc
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"sync"
 	"time"
+
+	"github.com/cicovic-andrija/dante/atlas"
+	"github.com/cicovic-andrija/dante/conf"
+	"github.com/cicovic-andrija/dante/fakeatlas"
 )
 
 const (
@@ -36,7 +42,7 @@ var ResourceNotFound = &ErrorResponse{
 	Description: CFResourceNotFound,
 }
 
-func (s *server) httpInit() {
+func (s *server) httpInit() error {
 	s.httpClientInit()
 	s.router = s.initRouter()
 	s.httpServer = &http.Server{
@@ -44,12 +50,37 @@ func (s *server) httpInit() {
 		Handler:  s.router,
 		ErrorLog: s.log.errorLogger.backend,
 	}
+
+	if cfg.Net.Protocol == conf.HTTPSString {
+		var err error
+		if s.tls, err = newTLSReloader(&cfg.Net.TLS); err != nil {
+			return err
+		}
+		s.httpServer.TLSConfig = s.tls.serverConfig()
+		if cfg.Net.TLS.ClientCAFile != "" {
+			s.log.info("[main] TLS: client certificates required")
+		}
+	}
+
+	return nil
 }
 
 func (s *server) httpClientInit() {
 	s.httpClient = &http.Client{
 		Timeout: httpClientTimeout,
 	}
+	s.atlas = atlas.NewClient(&cfg.Atlas)
+
+	transport := http.DefaultTransport
+	if cfg.Atlas.Sandbox {
+		sim := fakeatlas.NewServer(fakeatlas.Options{APIPath: cfg.Atlas.APIPath})
+		transport = sim.Transport()
+	}
+	s.atlas.SetTransport(&atlasTransport{
+		base:    transport,
+		apiPath: cfg.Atlas.APIPath,
+		metrics: s.metrics,
+	})
 }
 
 func (s *server) runHTTP() {
@@ -57,8 +88,14 @@ func (s *server) runHTTP() {
 	s.httpWg.Add(1)
 
 	go func() {
-		s.log.info("[http] starting the server on %s ...", s.httpServer.Addr)
-		err := s.httpServer.ListenAndServe()
+		s.log.info("[http] starting the server on %s (%s) ...", s.httpServer.Addr, cfg.Net.Protocol)
+		var err error
+		if s.tls != nil {
+			// certificates are provided by the TLS config
+			err = s.httpServer.ListenAndServeTLS("", "")
+		} else {
+			err = s.httpServer.ListenAndServe()
+		}
 		if err == http.ErrServerClosed {
 			s.log.info("[http] server shut down gracefully")
 		} else {
@@ -68,7 +105,7 @@ func (s *server) runHTTP() {
 	}()
 }
 
-func (s *server) httpGet(endpoint string, v interface{}) error {
+func (s *server) httpGet(ctx context.Context, endpoint string, v interface{}) error {
 	var (
 		req *http.Request
 		err error
@@ -77,22 +114,32 @@ func (s *server) httpGet(endpoint string, v interface{}) error {
 	req, err = http.NewRequest(http.MethodGet, endpoint, nil)
 
 	if err == nil {
-		err = s.makeRequest(req, v)
+		err = s.makeRequest(ctx, req, v)
 	}
 
 	return err
 }
 
-func (s *server) makeRequest(req *http.Request, v interface{}) error {
+func (s *server) makeRequest(ctx context.Context, req *http.Request, v interface{}) error {
 	var (
 		res *http.Response
 		err error
 	)
 
-	res, err = s.httpClient.Do(req)
+	if res, err = s.httpClient.Do(req.WithContext(ctx)); err != nil {
+		return err
+	}
+	defer func() {
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+	}()
+
+	if res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("request %s %s failed with status %s", req.Method, req.URL, res.Status)
+	}
 
-	if err == nil && v != nil {
-		err = json.NewDecoder(res.Body).Decode(&v)
+	if v != nil {
+		err = json.NewDecoder(res.Body).Decode(v)
 	}
 
 	return err
@@ -115,6 +162,27 @@ func (s *server) badRequest(w http.ResponseWriter, r *http.Request, msgFmt strin
 	s.httpWriteResponseObject(w, r, http.StatusBadRequest, errResp)
 }
 
+func (s *server) unauthorized(w http.ResponseWriter, r *http.Request) {
+	s.log.info("%s: rejected: missing or unknown API key", httpReqInfoPrefix(r))
+	errResp := &ErrorResponse{
+		Title:       http.StatusText(http.StatusUnauthorized),
+		Code:        http.StatusUnauthorized,
+		Description: CFAPIKeyRequired,
+	}
+	w.Header().Set("WWW-Authenticate", `Bearer realm="dante"`)
+	s.httpWriteResponseObject(w, r, http.StatusUnauthorized, errResp)
+}
+
+func (s *server) forbidden(w http.ResponseWriter, r *http.Request, client string, role string) {
+	s.log.info("%s: rejected: client %s lacks role %s", httpReqInfoPrefix(r), client, role)
+	errResp := &ErrorResponse{
+		Title:       http.StatusText(http.StatusForbidden),
+		Code:        http.StatusForbidden,
+		Description: fmt.Sprintf(CFRoleRequiredFmt, role),
+	}
+	s.httpWriteResponseObject(w, r, http.StatusForbidden, errResp)
+}
+
 func (s *server) internalServerError(w http.ResponseWriter, r *http.Request, err error) {
 	s.log.err("%s: internal server error: %v", httpReqInfoPrefix(r), err)
 	errResp := &ErrorResponse{
@@ -1,8 +1,10 @@
 package websvc
 
 import (
+	"context"
 	"net/http"
 
+	"github.com/cicovic-andrija/dante/conf"
 	"github.com/cicovic-andrija/dante/util"
 )
 
@@ -30,9 +32,46 @@ func (s *server) allowMethods(methods ...string) Adapter {
 	}
 }
 
+// authorize lets a request through only if it carries an API key
+// with a role sufficient for the request method. Methods without
+// a specified role require the admin role.
+func (s *server) authorize(access routeAccess) Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// authentication is disabled if no keys are configured
+			if s.apiKeys == nil {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			apiKey, ok := s.apiKeys.lookup(bearerToken(r))
+			if !ok {
+				s.unauthorized(w, r)
+				return
+			}
+
+			required, ok := access[r.Method]
+			if !ok {
+				required = conf.RoleAdmin
+			}
+			if roleRank[apiKey.Role] < roleRank[required] {
+				s.forbidden(w, r, apiKey.Client, required)
+				return
+			}
+
+			// call original handler
+			h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientCtxKey{}, apiKey.Client)))
+		})
+	}
+}
+
 func (s *server) logRequest(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		s.log.info("%s: accepted", httpReqInfoPrefix(r))
+		if client, ok := requestClient(r); ok {
+			s.log.info("%s: accepted, client %s", httpReqInfoPrefix(r), client)
+		} else {
+			s.log.info("%s: accepted", httpReqInfoPrefix(r))
+		}
 
 		// call original handler
 		h.ServeHTTP(w, r)
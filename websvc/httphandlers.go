@@ -7,7 +7,10 @@ import (
 	"github.com/cicovic-andrija/dante/db"
 )
 
-const idPathVariable = "id"
+const (
+	idPathVariable   = "id"
+	namePathVariable = "name"
+)
 
 func (s *server) measurementsHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
@@ -24,7 +27,7 @@ func (s *server) measurementsHandler(w http.ResponseWriter, r *http.Request) {
 			measReq = &measurementReq{}
 		)
 
-		if ok := s.decodeReqBody(w, r, measReq); !ok {
+		if ok := s.decodeMeasurementReq(w, r, measReq); !ok {
 			return
 		}
 
@@ -90,11 +93,52 @@ func (s *server) measurementControlHandler(w http.ResponseWriter, r *http.Reques
 	s.httpWriteResponseObject(w, r, status, respObj)
 }
 
+func (s *server) measurementResultsHandler(w http.ResponseWriter, r *http.Request, routeVars map[string]string) {
+	// HTTP GET
+	meas, ok := s.measCache.get(routeVars[idPathVariable])
+	if !ok {
+		s.httpWriteResponseObject(w, r, http.StatusNotFound, ResourceNotFound)
+		return
+	}
+
+	if meas.BucketName == "" || meas.Type == "" {
+		s.httpWriteResponseObject(w, r, http.StatusNotFound,
+			&status{Status: CFStatusFailed, Explanation: CFMeasurementNoResults})
+		return
+	}
+
+	query, ok, errMsg := parseResultsQuery(meas, r.URL.Query())
+	if !ok {
+		s.badRequest(w, r, errMsg)
+		return
+	}
+
+	points, err := s.database.QueryResults(query)
+	if err != nil {
+		s.internalServerError(w, r, err)
+		return
+	}
+
+	resp := &resultsResp{
+		ID:        meas.ID,
+		Type:      meas.Type,
+		Start:     query.Start,
+		Stop:      query.Stop,
+		Aggregate: query.Aggregate,
+		Points:    points,
+	}
+	if query.Window > 0 {
+		resp.Window = query.Window.String()
+	}
+
+	s.httpWriteResponseObject(w, r, http.StatusOK, resp)
+}
+
 func (s *server) creditsHandler(w http.ResponseWriter, r *http.Request) {
 	// HTTP GET
 	creditResp := &creditResp{}
 
-	credit, err := s.httpGetCredits()
+	credit, err := s.httpGetCredits(r.Context())
 	if err != nil {
 		s.internalServerError(w, r, err)
 		return
@@ -1,24 +1,67 @@
 package websvc
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/cicovic-andrija/dante/atlas"
 	"github.com/cicovic-andrija/dante/db"
 	"github.com/cicovic-andrija/dante/util"
-	"github.com/influxdata/influxdb-client-go/v2/domain"
 )
 
 const (
-	measDefDescrFmt   = "IPv4/HTTP measurement for target %s."
+	measDefDescrFmt   = "IPv%d/%s measurement for target %s."
 	measBucketPrefix  = "meas-"
 	measBucketNameFmt = measBucketPrefix + "%s"
 	measIDHexLength   = 9
+
+	resultsFetchOverlap = 10 * time.Minute
+
+	// results that fail to be stored in this many iterations in a row are given up on
+	maxResultRetries = 5
+
+	// bounds of the results fetch period, used if not set in configuration
+	defaultMinFetchPeriodSec = 60
+	defaultMaxFetchPeriodSec = 3600
+	// fetch period is delayed randomly by up to 1/fetchJitterDivisor of its length
+	fetchJitterDivisor = 10
+)
+
+// Measurement types supported by the service.
+var (
+	supportedMeasTypes    = []string{atlas.MeasHTTP, atlas.MeasPing, atlas.MeasTraceroute, atlas.MeasDNS, atlas.MeasSSL, atlas.MeasNTP}
+	supportedMeasTypesStr = strings.Join(supportedMeasTypes, ",")
+)
+
+// Address family values accepted in measurement requests.
+const (
+	afSpecIPv4 = "4"
+	afSpecIPv6 = "6"
+	afSpecBoth = "both"
+)
+
+var (
+	validAFSpecs    = []string{afSpecIPv4, afSpecIPv6, afSpecBoth}
+	validAFSpecsStr = strings.Join(validAFSpecs, ",")
 )
 
+// Returns backend address families requested by an address family value.
+func (af afSpec) families() []int32 {
+	switch af {
+	case afSpecIPv6:
+		return []int32{atlas.IPv6}
+	case afSpecBoth:
+		return []int32{atlas.IPv4, atlas.IPv6}
+	default:
+		return []int32{atlas.IPv4}
+	}
+}
+
 func freshMeasurementID() (id string, err error) {
 	id, err = util.RandHexString(measIDHexLength)
 	return
@@ -35,7 +78,33 @@ func (s *server) validateMeasurementReq(req *measurementReq) (bool, string) {
 		return false, CFEmptyDescriptionInRequest
 	}
 
-	if len(req.Targets) == 0 {
+	if req.Type == "" {
+		req.Type = atlas.MeasHTTP
+	}
+
+	if found := util.SearchForString(req.Type, supportedMeasTypes...); !found {
+		return false, fmt.Sprintf(CFInvalidMeasurementTypeFmt, supportedMeasTypesStr)
+	}
+
+	if req.AddressFamily == "" {
+		req.AddressFamily = afSpecIPv4
+	}
+
+	if found := util.SearchForString(string(req.AddressFamily), validAFSpecs...); !found {
+		return false, fmt.Sprintf(CFInvalidAddressFamilyFmt, validAFSpecsStr)
+	}
+
+	if req.Type == atlas.MeasDNS {
+		if ok, errMsg := validateDNSOpts(req); !ok {
+			return false, errMsg
+		}
+	}
+
+	if req.Type == atlas.MeasDNS && req.DNS.UseProbeResolver {
+		if len(req.Targets) > 0 {
+			return false, CFTargetWithProbeResolver
+		}
+	} else if len(req.Targets) == 0 {
 		return false, CFTargetNotSpecified
 	}
 
@@ -58,34 +127,56 @@ func (s *server) validateMeasurementReq(req *measurementReq) (bool, string) {
 		}
 	}
 
-	if req.StartTimeRFC3339 == "" {
-		return false, CFStartTimeNotSpecified
+	startSpec, ok, errMsg := oneTimeSpec(req.Start, reqFieldStart, req.StartTimeRFC3339, reqFieldStartTimeRFC3339)
+	if !ok {
+		return false, errMsg
 	}
-
-	if startTime, err = time.Parse(time.RFC3339, req.StartTimeRFC3339); err != nil {
-		return false, fmt.Sprintf(CFInvalidTimeValueFmt, req.StartTimeRFC3339)
+	stopSpec, ok, errMsg := oneTimeSpec(req.Stop, reqFieldStop, req.StopTimeRFC3339, reqFieldStopTimeRFC3339)
+	if !ok {
+		return false, errMsg
 	}
 
-	if req.StopTimeRFC3339 == "" {
-		return false, CFEndTimeNotSpecified
+	if startSpec == "" {
+		return false, CFStartTimeNotSpecified
 	}
 
-	if endTime, err = time.Parse(time.RFC3339, req.StopTimeRFC3339); err != nil {
-		return false, fmt.Sprintf(CFInvalidTimeValueFmt, req.StopTimeRFC3339)
+	// relative start time is an offset from now,
+	// and relative stop time is an offset from the start time
+	now := time.Now()
+	if startTime, err = parseTimeSpec(startSpec, now, now); err != nil {
+		return false, fmt.Sprintf(CFInvalidTimeValueFmt, startSpec)
 	}
 
-	if endTime.Before(startTime) {
-		return false, CFEndTimeBeforeStartTime
+	// without stop time and duration, the measurement runs until stopped
+	switch {
+	case stopSpec != "" && req.Duration != "":
+		return false, CFDurationWithStopTime
+	case stopSpec != "":
+		if endTime, err = parseTimeSpec(stopSpec, now, startTime); err != nil {
+			return false, fmt.Sprintf(CFInvalidTimeValueFmt, stopSpec)
+		}
+		if endTime.Before(startTime) {
+			return false, CFEndTimeBeforeStartTime
+		}
+	case req.Duration != "":
+		duration, err := parseDurationSpec(req.Duration)
+		if err != nil {
+			return false, fmt.Sprintf(CFInvalidDurationValueFmt, req.Duration)
+		}
+		endTime = startTime.Add(duration)
 	}
 
 	req.startTimeUnix = startTime.Unix()
-	req.stopTimeUnix = endTime.Unix()
+	if !endTime.IsZero() {
+		req.stopTimeUnix = endTime.Unix()
+	}
+	req.startNow = startSpec == timeSpecNow
 
 	if req.IntervalSec <= 0 {
 		return false, CFInvalidIntervalValue
 	}
 
-	if req.IntervalSec >= (req.stopTimeUnix - req.startTimeUnix) {
+	if req.stopTimeUnix != 0 && req.IntervalSec >= (req.stopTimeUnix-req.startTimeUnix) {
 		return false, CFIntervalValueTooLarge
 	}
 
@@ -151,19 +242,43 @@ func (s *server) createBackendMeasurements(req *measurementReq) (*atlas.Measurem
 		err        error
 	)
 
-	for _, target := range req.Targets {
-		def := &atlas.MeasurementDefinition{
-			Type:          atlas.MeasHTTP,
-			AddressFamily: atlas.IPv4,
-			Target:        target,
-			Description:   fmt.Sprintf(measDefDescrFmt, target),
-			IsPublic:      false,
-			IsOneOff:      false,
-			StartTime:     req.startTimeUnix,
-			StopTime:      req.stopTimeUnix,
-			Interval:      req.IntervalSec,
+	targets := req.Targets
+	if req.Type == atlas.MeasDNS && req.DNS.UseProbeResolver {
+		// a single definition without target
+		targets = []string{""}
+	}
+
+	// for dual-stack requests, v4 and v6 definitions are paired by target;
+	// all definitions in a single request share the same probe selection
+	for _, target := range targets {
+		for _, af := range req.AddressFamily.families() {
+			def := &atlas.MeasurementDefinition{
+				Type:          req.Type,
+				AddressFamily: af,
+				Target:        target,
+				Description:   fmt.Sprintf(measDefDescrFmt, af, strings.ToUpper(req.Type), target),
+				IsPublic:      false,
+				IsOneOff:      false,
+				StartTime:     req.startTimeUnix,
+				StopTime:      req.stopTimeUnix,
+				Interval:      req.IntervalSec,
+			}
+			if req.startNow {
+				// start as soon as possible, a timestamp
+				// could already be in the past when received
+				def.StartTime = 0
+			}
+			if req.Type == atlas.MeasDNS {
+				def.QueryClass = req.DNS.QueryClass
+				def.QueryType = req.DNS.QueryType
+				def.QueryArgument = req.DNS.QueryArgument
+				def.UseProbeResolver = req.DNS.UseProbeResolver
+				if def.UseProbeResolver {
+					def.Description = fmt.Sprintf(measDefDescrFmt, af, strings.ToUpper(req.Type), req.DNS.QueryArgument)
+				}
+			}
+			backendReq.Definitions = append(backendReq.Definitions, def)
 		}
-		backendReq.Definitions = append(backendReq.Definitions, def)
 	}
 
 	for _, probeReq := range req.ProbeRequests {
@@ -175,11 +290,10 @@ func (s *server) createBackendMeasurements(req *measurementReq) (*atlas.Measurem
 		backendReq.Probes = append(backendReq.Probes, probes)
 	}
 
-	httpReq, err = atlas.PrepareRequest(
-		atlas.MeasurementsEndpoint,
+	httpReq, err = s.atlas.PrepareRequest(
+		s.atlas.MeasurementsURL(),
 		&atlas.ReqParams{
 			Method: http.MethodPost,
-			Key:    cfg.Atlas.Auth.Key,
 			Body:   backendReq,
 		},
 	)
@@ -188,30 +302,28 @@ func (s *server) createBackendMeasurements(req *measurementReq) (*atlas.Measurem
 	}
 
 	resp := &atlas.MeasurementReqResponse{}
-	if err = s.makeRequest(httpReq, resp); err != nil {
+	if err = s.atlas.Do(s.ctx, httpReq, resp); err != nil {
+		var statusErr *atlas.StatusError
+		if errors.As(err, &statusErr) {
+			return nil, int64(statusErr.StatusCode), statusErr.Err.Detail, err
+		}
 		return nil, http.StatusInternalServerError, "", err
 	}
 
-	if resp.Error != nil {
-		err := fmt.Errorf("client request failed (%s %d): %s", resp.Error.Title, resp.Error.Status, resp.Error.Detail)
-		return nil, resp.Error.Status, resp.Error.Detail, err
-	}
-
 	return resp, http.StatusOK, "", nil
 }
 
 // Best effort, ignore all errors.
 func (s *server) stopBackendMeasurements(backendIDs ...int64) {
 	for _, id := range backendIDs {
-		req, err := atlas.PrepareRequest(
-			atlas.MeasurementURL(id),
+		req, err := s.atlas.PrepareRequest(
+			s.atlas.MeasurementURL(id),
 			&atlas.ReqParams{
 				Method: http.MethodDelete,
-				Key:    cfg.Atlas.Auth.Key,
 			},
 		)
 		if err == nil {
-			s.makeRequest(req, nil)
+			s.atlas.Do(s.ctx, req, nil)
 		}
 	}
 }
@@ -250,11 +362,10 @@ func (s *server) fetchRetainBackendDetails(meas *measurement, backendIDs []int64
 	meas.backendIDs = make([]int64, 0, len(backendIDs))
 
 	for _, id := range backendIDs {
-		req, err = atlas.PrepareRequest(
-			atlas.MeasurementURL(id),
+		req, err = s.atlas.PrepareRequest(
+			s.atlas.MeasurementURL(id),
 			&atlas.ReqParams{
 				Method: http.MethodGet,
-				Key:    cfg.Atlas.Auth.Key,
 			},
 		)
 		if err != nil {
@@ -262,47 +373,85 @@ func (s *server) fetchRetainBackendDetails(meas *measurement, backendIDs []int64
 		}
 
 		resp := &atlas.Measurement{}
-		if err = s.makeRequest(req, resp); err != nil {
+		if err = s.atlas.Do(s.ctx, req, resp); err != nil {
 			return err
 		}
 
 		bm := &backendMeasurement{
-			ID:       id,
-			Target:   resp.Target,
-			TargetIP: resp.TargetIP,
-
-			startTimeUnix: resp.StartTime,
-			stopTimeUnix:  resp.StopTime,
-
-			stopped: resp.Status.ID > atlas.MeasurementStatusIDOngoing,
+			ID:            id,
+			Type:          resp.Type,
+			AddressFamily: resp.AddressFamily,
+			Target:        resp.Target,
+			TargetIP:      resp.TargetIP,
+
+			intervalSec:    resp.Interval,
+			startTimeUnix:  resp.StartTime,
+			stopTimeUnix:   resp.StopTime,
+			lastResultUnix: s.checkpoints.get(id),
+
+			// results are fetched until the checkpoint reaches the stop time
+			stopped: resp.Status.ID > atlas.MeasurementStatusIDOngoing &&
+				s.checkpoints.get(id) >= resp.StopTime,
 		}
 		meas.BackendMeasurements = append(meas.BackendMeasurements, bm)
 		meas.backendIDs = append(meas.backendIDs, id)
+
+		// all backend measurements are created from the same request,
+		// so they share the measurement type
+		meas.Type = resp.Type
 	}
 
 	return nil
 }
 
 func (s *server) cleanupMeasurement(meas *measurement) {
-	if meas.bucket != nil {
-		err := s.database.DeleteBucket(meas.bucket)
+	if meas.hasBucket {
+		err := s.database.DeleteBucket(meas.BucketName)
 		if err != nil {
 			s.log.err("[mgmt %s] failed to delete bucket %s", meas.ID, meas.BucketName)
 		}
-		meas.bucket = nil
+		meas.hasBucket = false
 	}
 }
 
+// Returns the period in which results of a measurement are fetched:
+// the shortest interval of its running backend measurements,
+// within the configured bounds.
+func fetchPeriod(meas *measurement) time.Duration {
+	minPeriod, maxPeriod := cfg.Fetch.MinPeriodSec, cfg.Fetch.MaxPeriodSec
+	if minPeriod <= 0 {
+		minPeriod = defaultMinFetchPeriodSec
+	}
+	if maxPeriod <= 0 {
+		maxPeriod = defaultMaxFetchPeriodSec
+	}
+	if minPeriod > maxPeriod {
+		// only one bound is configured, and it conflicts with the default
+		maxPeriod = minPeriod
+	}
+
+	period := maxPeriod
+	for _, backend := range meas.BackendMeasurements {
+		if !backend.stopped && backend.intervalSec > 0 && backend.intervalSec < period {
+			period = backend.intervalSec
+		}
+	}
+	if period < minPeriod {
+		period = minPeriod
+	}
+
+	return time.Duration(period) * time.Second
+}
+
 func (s *server) scheduleWorker(meas *measurement) error {
 	// first ensure there is a bucket for writing data
-	if meas.bucket == nil {
-		if bck, err := s.database.EnsureBucket(meas.BucketName); err == nil {
-			meas.bucket = bck
-		} else {
+	if !meas.hasBucket {
+		if err := s.database.EnsureBucket(meas.BucketName); err != nil {
 			return err
 		}
+		meas.hasBucket = true
 
-		// if the bucket was nil, this is a new measurement
+		// if there was no bucket, this is a new measurement
 		// write metadata about the measurement to the system bucket
 		err := s.database.WriteMeasurementMetadata(
 			db.MeasurementMetadata{
@@ -328,14 +477,22 @@ func (s *server) scheduleWorker(meas *measurement) error {
 		return nil
 	}
 
+	// first results are fetched right away, after a random delay,
+	// so that workers scheduled together do not fire at the same time;
+	// catching up on results may take longer than the period, so
+	// iterations are bound by the configured task timeout instead
+	period := fetchPeriod(meas)
 	task := &timerTask{
-		name:    meas.ID,
-		execute: s.updateMeasurementResults,
-		period:  5 * time.Minute,
-		log:     s.log,
+		name:      meas.ID,
+		execute:   s.updateMeasurementResults,
+		period:    period,
+		jitter:    period / fetchJitterDivisor,
+		immediate: true,
+		log:       s.log,
 	}
 
 	meas.Status = CFStatusScheduled
+	s.log.info("[mgmt %s] results fetched every %v", meas.ID, period)
 
 	// after this point, the worker task owns the pointer to meas
 	s.taskManager.scheduleTask(task, meas)
@@ -344,7 +501,7 @@ func (s *server) scheduleWorker(meas *measurement) error {
 }
 
 // Intended to be run as a timer task, thus the signature.
-func (s *server) updateMeasurementResults(args ...interface{}) (status string, failed bool) {
+func (s *server) updateMeasurementResults(ctx context.Context, args ...interface{}) (status string, failed bool) {
 	// convert generic argument to measurement this task is tracking
 	meas := args[0].(*measurement)
 
@@ -369,11 +526,20 @@ func (s *server) updateMeasurementResults(args ...interface{}) (status string, f
 			continue
 		}
 
-		req, err := atlas.PrepareRequest(
-			atlas.MeasurementResultsURL(backend.ID),
+		// fetch only results newer than the checkpoint, but go back
+		// a bit to pick up results that probes uploaded late; results
+		// must therefore be processed so that processing one again,
+		// or after a newer one, writes the same data points
+		fetchStart := backend.lastResultUnix
+		if fetchStart > 0 {
+			fetchStart = fetchStart + 1 - int64(resultsFetchOverlap.Seconds())
+		}
+		fetchStop := time.Now().Unix()
+
+		req, err := s.atlas.PrepareRequest(
+			s.atlas.MeasurementResultsURL(backend.ID, fetchStart, fetchStop),
 			&atlas.ReqParams{
 				Method: http.MethodGet,
-				Key:    cfg.Atlas.Auth.Key,
 			},
 		)
 		if err != nil {
@@ -381,31 +547,68 @@ func (s *server) updateMeasurementResults(args ...interface{}) (status string, f
 			continue
 		}
 
-		var results atlas.MeasurementResults
-		if err = s.makeRequest(req, &results); err != nil {
+		results := newResultsObject(backend.Type)
+		if err = s.atlas.Do(ctx, req, results); err != nil {
 			recordError(fmt.Errorf("request failed for %d: %v", backend.ID, err))
 			continue
 		}
 
 		// bucket got delted along with the measurement
 		// end this (most probably last) iteration
-		if meas.bucket == nil {
+		if !meas.hasBucket {
 			return timerTaskFailure(errors.New("bucket deleted"))
 		}
 
-		for _, probeResults := range results {
-			if err = s.processProbeResults(&probeResults, backend, meas.BucketName); err != nil {
-				recordError(err)
-				continue
+		batch := s.processResults(ctx, results, backend, meas.BucketName, recordError)
+
+		// results may be written asynchronously, so make sure they are stored
+		flushFailed := false
+		if err = s.database.Flush(meas.BucketName); err != nil {
+			recordError(fmt.Errorf("writing results failed for %d: %v", backend.ID, err))
+			flushFailed = true
+		}
+
+		// move the checkpoint past stored and dropped results, but not past
+		// results that failed transiently, so that they are fetched again in
+		// the next iteration; give up on them if they keep failing, so that
+		// the fetch window does not grow without bounds; if the flush failed,
+		// none of the results may be stored, so the checkpoint stays put
+		checkpoint := batch.latest
+		complete := !flushFailed && batch.retried == 0
+		switch {
+		case flushFailed:
+			checkpoint = backend.lastResultUnix
+		case batch.retried == 0:
+			backend.failedIterations = 0
+		default:
+			backend.failedIterations++
+			if backend.failedIterations < maxResultRetries {
+				if batch.retryFrom-1 < checkpoint {
+					checkpoint = batch.retryFrom - 1
+				}
+			} else {
+				s.log.err("[mgmt %s] giving up on %d results of measurement %d after %d failed iterations",
+					meas.ID, batch.retried, backend.ID, backend.failedIterations)
+				s.metrics.resultsDropped.add(float64(batch.retried), backend.Type, dropReasonRetries)
+				backend.failedIterations = 0
 			}
 		}
 
+		advanceCheckpoint := func(checkpoint int64) {
+			if checkpoint > backend.lastResultUnix {
+				backend.lastResultUnix = checkpoint
+				if err := s.database.WriteCheckpoint(meas.ID, backend.ID, checkpoint); err != nil {
+					recordError(fmt.Errorf("writing checkpoint failed for %d: %v", backend.ID, err))
+				}
+			}
+		}
+		advanceCheckpoint(checkpoint)
+
 		// fetch backend measurement status from the API and update internal state
-		req, err = atlas.PrepareRequest(
-			atlas.MeasurementURL(backend.ID),
+		req, err = s.atlas.PrepareRequest(
+			s.atlas.MeasurementURL(backend.ID),
 			&atlas.ReqParams{
 				Method: http.MethodGet,
-				Key:    cfg.Atlas.Auth.Key,
 			},
 		)
 		if err != nil {
@@ -414,14 +617,20 @@ func (s *server) updateMeasurementResults(args ...interface{}) (status string, f
 		}
 
 		resp := &atlas.Measurement{}
-		if err = s.makeRequest(req, resp); err != nil {
+		if err = s.atlas.Do(ctx, req, resp); err != nil {
 			recordError(fmt.Errorf("request for metadata failed for %d: %v", backend.ID, err))
 			continue
 		}
 
 		if resp.Status.ID > atlas.MeasurementStatusIDOngoing {
-			// this should happen ONLY in case of no errors in this loop iteration
-			backend.stopped = true
+			// results of a stopped backend measurement are fetched until all
+			// of them are stored, including results that probes upload late;
+			// the checkpoint then reaches the stop time, also across restarts
+			lateUntil := resp.StopTime + int64(resultsFetchOverlap.Seconds())
+			if complete && fetchStop >= lateUntil {
+				advanceCheckpoint(resp.StopTime)
+				backend.stopped = backend.lastResultUnix >= resp.StopTime
+			}
 		} else if resp.Status.ID == atlas.MeasurementStatusIDOngoing {
 			// other threads may update measurement status,
 			// so do it in a locked code section
@@ -459,22 +668,140 @@ func (s *server) updateMeasurementResults(args ...interface{}) (status string, f
 	}
 }
 
-// TODO: Implement smart updating.
-func (s *server) processProbeResults(probeResults *atlas.ProbeMeasurementResults, backend *backendMeasurement, bucketName string) error {
+// Returns a pointer to an object into which the results
+// of a backend measurement of the specified type are decoded.
+func newResultsObject(measType string) interface{} {
+	switch measType {
+	case atlas.MeasPing:
+		return &atlas.PingResults{}
+	case atlas.MeasTraceroute:
+		return &atlas.TracerouteResults{}
+	case atlas.MeasDNS:
+		return &atlas.DNSResults{}
+	case atlas.MeasSSL:
+		return &atlas.SSLCertResults{}
+	case atlas.MeasNTP:
+		return &atlas.NTPResults{}
+	default:
+		return &atlas.MeasurementResults{}
+	}
+}
+
+// Reasons for giving up on measurement results, used as a metric label.
+const (
+	dropReasonPermanent = "permanent"
+	dropReasonRetries   = "retries"
+)
+
+// errMalformedResult is recorded for results without a probe or a timestamp.
+var errMalformedResult = errors.New("malformed result")
+
+// resultsBatch summarizes processing of the results fetched in an iteration.
+type resultsBatch struct {
+	// latest timestamp of a processed result, regardless of the outcome
+	latest int64
+	// earliest timestamp of a result that should be fetched again, 0 if none
+	retryFrom int64
+	// number of results that should be fetched again
+	retried int
+}
+
+// Returns true if processing a result failed in a way that does not
+// change when the result is fetched again, e.g. its probe was removed.
+func permanentResultError(err error) bool {
+	return errors.Is(err, errMalformedResult) || errors.Is(err, atlas.ErrNotFound)
+}
+
+// Processes results and stores them. Results that fail permanently are dropped,
+// and other failed results are reported through recordError. Lookups done
+// while processing are bound by ctx, the context of the worker iteration.
+func (s *server) processResults(ctx context.Context, results interface{}, backend *backendMeasurement, bucketName string, recordError func(error)) (batch resultsBatch) {
+	process := func(probeID int64, timestamp int64, fn func() error) {
+		err := errMalformedResult
+		if probeID > 0 && timestamp > 0 {
+			err = fn()
+		}
+
+		if timestamp > batch.latest {
+			batch.latest = timestamp
+		}
+
+		switch {
+		case err == nil:
+		case permanentResultError(err):
+			s.log.err("[mgmt] result of probe %d and measurement %d dropped: %v", probeID, backend.ID, err)
+			s.metrics.resultsDropped.inc(backend.Type, dropReasonPermanent)
+		default:
+			recordError(err)
+			batch.retried++
+			if batch.retryFrom == 0 || timestamp < batch.retryFrom {
+				batch.retryFrom = timestamp
+			}
+		}
+	}
+
+	switch results := results.(type) {
+	case *atlas.PingResults:
+		for _, probeResults := range *results {
+			process(probeResults.ProbeID, probeResults.Timestamp, func() error {
+				return s.processProbePingResults(ctx, &probeResults, backend, bucketName)
+			})
+		}
+	case *atlas.TracerouteResults:
+		// AS path changes are detected in order of results
+		sort.SliceStable(*results, func(i, j int) bool {
+			return (*results)[i].Timestamp < (*results)[j].Timestamp
+		})
+		for _, probeResults := range *results {
+			process(probeResults.ProbeID, probeResults.Timestamp, func() error {
+				return s.processProbeTracerouteResults(ctx, &probeResults, backend, bucketName)
+			})
+		}
+	case *atlas.DNSResults:
+		for _, probeResults := range *results {
+			process(probeResults.ProbeID, probeResults.Timestamp, func() error {
+				return s.processProbeDNSResults(ctx, &probeResults, backend, bucketName)
+			})
+		}
+	case *atlas.SSLCertResults:
+		for _, probeResults := range *results {
+			process(probeResults.ProbeID, probeResults.Timestamp, func() error {
+				return s.processProbeSSLCertResults(ctx, &probeResults, backend, bucketName)
+			})
+		}
+	case *atlas.NTPResults:
+		for _, probeResults := range *results {
+			process(probeResults.ProbeID, probeResults.Timestamp, func() error {
+				return s.processProbeNTPResults(ctx, &probeResults, backend, bucketName)
+			})
+		}
+	case *atlas.MeasurementResults:
+		for _, probeResults := range *results {
+			process(probeResults.ProbeID, probeResults.Timestamp, func() error {
+				return s.processProbeResults(ctx, &probeResults, backend, bucketName)
+			})
+		}
+	}
+
+	return
+}
+
+func (s *server) processProbeResults(ctx context.Context, probeResults *atlas.ProbeMeasurementResults, backend *backendMeasurement, bucketName string) error {
 	var (
 		probe *atlas.Probe
 		err   error
 	)
 
-	if probe, err = s.getProbe(probeResults.ProbeID); err != nil {
-		return fmt.Errorf("probe info request failed for probe %d and measurement %d: %v", probeResults.ProbeID, backend.ID, err)
+	if probe, err = s.getProbe(ctx, probeResults.ProbeID); err != nil {
+		return fmt.Errorf("probe info request failed for probe %d and measurement %d: %w", probeResults.ProbeID, backend.ID, err)
 	}
 
 	for _, result := range probeResults.Results {
 		httpData := &db.HTTPData{
 			BackendID:     backend.ID,
+			AddressFamily: backend.AddressFamily,
 			ProbeID:       probe.ID,
-			ASN:           probe.ASNv4,
+			ASN:           probe.ASN(backend.AddressFamily),
 			Country:       probe.CountryCode,
 			Target:        backend.Target,
 			TargetIP:      backend.TargetIP,
@@ -540,17 +867,22 @@ func (s *server) deleteMeasurement(id string) int {
 
 	// as this is executed by an http handler, run long operation in another thread
 	// errors are disregarded anyway
-	go func(measID string, bucket *domain.Bucket, backendIDs ...int64) {
+	go func(measID string, bucketName string, hasBucket bool, backendIDs ...int64) {
 		s.stopBackendMeasurements(backendIDs...)
 
 		// after this is done, some write operations to the bucket will fail
 		// that's ok
-		if bucket != nil {
-			if err := s.database.DeleteBucket(bucket); err != nil {
-				s.log.err("[mgmt %s] failed to delete bucket %s: %v", measID, bucket.Name, err)
+		if hasBucket {
+			if err := s.database.DeleteBucket(bucketName); err != nil {
+				s.log.err("[mgmt %s] failed to delete bucket %s: %v", measID, bucketName, err)
 			}
 		}
-	}(meas.ID, meas.bucket, meas.backendIDs...)
+
+		s.checkpoints.delete(backendIDs...)
+		if err := s.database.DeleteCheckpoints(measID); err != nil {
+			s.log.err("[mgmt %s] failed to delete checkpoints: %v", measID, err)
+		}
+	}(meas.ID, meas.BucketName, meas.hasBucket, meas.backendIDs...)
 
 	return http.StatusNoContent
 }
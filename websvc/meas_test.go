@@ -0,0 +1,225 @@
+package websvc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cicovic-andrija/dante/atlas"
+	"github.com/cicovic-andrija/dante/db"
+)
+
+func TestValidateMeasurementReq(t *testing.T) {
+	s, _, _ := newTestServer(t, &simClock{})
+
+	tests := []struct {
+		name   string
+		modify func(req *measurementReq)
+		errMsg string
+	}{
+		{"valid", func(req *measurementReq) {}, ""},
+		{"no description", func(req *measurementReq) { req.Description = "" }, CFEmptyDescriptionInRequest},
+		{"unsupported type", func(req *measurementReq) { req.Type = atlas.MeasWiFi },
+			fmt.Sprintf(CFInvalidMeasurementTypeFmt, supportedMeasTypesStr)},
+		{"no targets", func(req *measurementReq) { req.Targets = nil }, CFTargetNotSpecified},
+		{"empty target", func(req *measurementReq) { req.Targets = []string{""} }, CFEmptyTargetInRequest},
+		{"no probes", func(req *measurementReq) { req.ProbeRequests = nil }, CFProbeRequestNotSpecified},
+		{"zero probes", func(req *measurementReq) { req.ProbeRequests[0].Requested = 0 }, CFInvalidNumberOfProbes},
+		{"no start", func(req *measurementReq) { req.Start = "" }, CFStartTimeNotSpecified},
+		{"stop and duration", func(req *measurementReq) { req.Stop = "+1h" }, CFDurationWithStopTime},
+		{"invalid duration", func(req *measurementReq) { req.Duration = "soon" },
+			fmt.Sprintf(CFInvalidDurationValueFmt, "soon")},
+		{"no interval", func(req *measurementReq) { req.IntervalSec = 0 }, CFInvalidIntervalValue},
+		{"interval too large", func(req *measurementReq) { req.IntervalSec = 7200 }, CFIntervalValueTooLarge},
+	}
+
+	for _, test := range tests {
+		req := testHTTPMeasurementReq(1, 60)
+		test.modify(req)
+		ok, errMsg := s.validateMeasurementReq(req)
+		if ok != (test.errMsg == "") || errMsg != test.errMsg {
+			t.Errorf("%s: got (%t, %q), want %q", test.name, ok, errMsg, test.errMsg)
+		}
+	}
+}
+
+// Results of probes that do not exist, and malformed results, are dropped
+// without holding back the checkpoint.
+func TestProcessResultsDropsPermanentFailures(t *testing.T) {
+	s, store, _ := newTestServer(t, &simClock{})
+
+	const bucket = "results"
+	if err := store.EnsureBucket(bucket); err != nil {
+		t.Fatal(err)
+	}
+
+	backend := &backendMeasurement{ID: 1, Type: atlas.MeasHTTP, AddressFamily: atlas.IPv4, Target: "example.com"}
+	result := []atlas.Result{{RT: 12.5, Result: 200}}
+	results := &atlas.MeasurementResults{
+		{ProbeID: 1000, Timestamp: 100, Results: result},
+		{ProbeID: 999999, Timestamp: 300, Results: result}, // unknown probe
+		{ProbeID: 0, Timestamp: 400, Results: result},      // malformed
+		{ProbeID: 1001, Timestamp: 200, Results: result},
+	}
+
+	var errs []error
+	batch := s.processResults(context.Background(), results, backend, bucket, func(err error) { errs = append(errs, err) })
+
+	if len(errs) != 0 || batch.retried != 0 {
+		t.Errorf("%d results retried, errors: %v", batch.retried, errs)
+	}
+	if batch.latest != 400 {
+		t.Errorf("latest = %d, want 400", batch.latest)
+	}
+
+	points, err := store.QueryResults(&db.ResultsQuery{
+		Bucket: bucket,
+		Type:   atlas.MeasHTTP,
+		Start:  time.Unix(0, 0),
+		Stop:   time.Unix(1000, 0),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 2 {
+		t.Errorf("%d results stored, want 2", len(points))
+	}
+}
+
+// failingStore fails to write HTTP results.
+type failingStore struct {
+	db.Store
+}
+
+func (f failingStore) WriteHTTPMeasurementResult(bucketName string, httpData *db.HTTPData) error {
+	return errors.New("write failed")
+}
+
+// Results that fail to be stored hold the checkpoint back,
+// until they fail in maxResultRetries iterations in a row.
+func TestCheckpointRetry(t *testing.T) {
+	clock := &simClock{}
+	clock.set(time.Now().Add(-time.Hour))
+	s, _, _ := newTestServer(t, clock)
+
+	meas := newTestMeasurement(t, s, testHTTPMeasurementReq(2, 60))
+	backend := meas.BackendMeasurements[0]
+	clock.set(time.Now())
+
+	// checkpoint stays right before the earliest result that failed
+	s.database = failingStore{Store: s.database}
+	var checkpoint int64
+	for i := 1; i < maxResultRetries; i++ {
+		if _, failed := s.updateMeasurementResults(context.Background(), meas); !failed {
+			t.Fatalf("iteration %d: worker did not fail", i)
+		}
+		if i == 1 {
+			checkpoint = backend.lastResultUnix
+		} else if backend.lastResultUnix != checkpoint {
+			t.Fatalf("iteration %d: checkpoint moved from %d to %d", i, checkpoint, backend.lastResultUnix)
+		}
+		if backend.failedIterations != i {
+			t.Fatalf("iteration %d: %d failed iterations", i, backend.failedIterations)
+		}
+	}
+
+	// results are given up on in the last iteration
+	s.updateMeasurementResults(context.Background(), meas)
+	if backend.lastResultUnix <= checkpoint {
+		t.Error("checkpoint did not move past results that were given up on")
+	}
+	if backend.failedIterations != 0 {
+		t.Errorf("%d failed iterations after giving up, want 0", backend.failedIterations)
+	}
+}
+
+// flushFailingStore fails to flush written results.
+type flushFailingStore struct {
+	db.Store
+}
+
+func (f flushFailingStore) Flush(bucketName string) error {
+	return errors.New("flush failed")
+}
+
+// Results that may not have been stored are never given up on.
+func TestCheckpointHeldOnFlushFailure(t *testing.T) {
+	clock := &simClock{}
+	clock.set(time.Now().Add(-time.Hour))
+	s, _, _ := newTestServer(t, clock)
+
+	meas := newTestMeasurement(t, s, testHTTPMeasurementReq(2, 60))
+	backend := meas.BackendMeasurements[0]
+	clock.set(time.Now())
+
+	s.database = flushFailingStore{Store: s.database}
+	for i := 1; i <= 2*maxResultRetries; i++ {
+		if _, failed := s.updateMeasurementResults(context.Background(), meas); !failed {
+			t.Fatalf("iteration %d: worker did not fail", i)
+		}
+		if backend.lastResultUnix != 0 {
+			t.Fatalf("iteration %d: checkpoint moved to %d", i, backend.lastResultUnix)
+		}
+	}
+}
+
+// A stopped backend measurement is fetched until all of its results are stored.
+func TestBackendStoppedAfterResultsStored(t *testing.T) {
+	clock := &simClock{}
+	clock.set(time.Now().Add(-3 * time.Hour))
+	s, _, _ := newTestServer(t, clock)
+
+	// measurement runs for an hour, and stopped two hours ago
+	meas := newTestMeasurement(t, s, testHTTPMeasurementReq(2, 60))
+	backend := meas.BackendMeasurements[0]
+	clock.set(time.Now().Add(-2 * time.Hour))
+	s.stopBackendMeasurements(backend.ID)
+	clock.set(time.Now())
+
+	store := s.database
+	s.database = failingStore{Store: store}
+	s.updateMeasurementResults(context.Background(), meas)
+	if backend.stopped {
+		t.Fatal("backend stopped before its results were stored")
+	}
+
+	s.database = store
+	if _, failed := s.updateMeasurementResults(context.Background(), meas); failed {
+		t.Fatal("worker failed")
+	}
+	if !backend.stopped {
+		t.Fatal("backend not stopped after its results were stored")
+	}
+	if stopTime := time.Now().Add(-2 * time.Hour).Unix(); backend.lastResultUnix < stopTime-1 {
+		t.Errorf("checkpoint %d before stop time %d", backend.lastResultUnix, stopTime)
+	}
+	if meas.Status != CFStatusStopped {
+		t.Errorf("status = %q, want %q", meas.Status, CFStatusStopped)
+	}
+}
+
+// Lookups stop when the worker iteration is canceled,
+// and results are fetched again in the next one.
+func TestProcessResultsCanceled(t *testing.T) {
+	s, store, _ := newTestServer(t, &simClock{})
+
+	const bucket = "results"
+	if err := store.EnsureBucket(bucket); err != nil {
+		t.Fatal(err)
+	}
+
+	backend := &backendMeasurement{ID: 1, Type: atlas.MeasHTTP, AddressFamily: atlas.IPv4, Target: "example.com"}
+	results := &atlas.MeasurementResults{
+		{ProbeID: 1000, Timestamp: 100, Results: []atlas.Result{{RT: 12.5, Result: 200}}},
+		{ProbeID: 1001, Timestamp: 200, Results: []atlas.Result{{RT: 12.5, Result: 200}}},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	batch := s.processResults(ctx, results, backend, bucket, func(error) {})
+	if batch.retried != 2 || batch.retryFrom != 100 {
+		t.Errorf("%d results retried from %d, want 2 from 100", batch.retried, batch.retryFrom)
+	}
+}
@@ -0,0 +1,216 @@
+package websvc
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Minimal implementation of metric types exposed in the Prometheus
+// text-based exposition format (version 0.0.4).
+
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Default histogram buckets, in seconds.
+var defaultDurationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 25}
+
+type metricFamily interface {
+	writeTo(w io.Writer)
+}
+
+// valueVec is a counter or a gauge, partitioned by label values.
+type valueVec struct {
+	sync.Mutex
+
+	name       string
+	help       string
+	kind       string
+	labelNames []string
+	series     map[string]*valueSeries
+}
+
+type valueSeries struct {
+	labelValues []string
+	value       float64
+}
+
+func newCounterVec(name string, help string, labelNames ...string) *valueVec {
+	return newValueVec(name, help, "counter", labelNames)
+}
+
+func newGaugeVec(name string, help string, labelNames ...string) *valueVec {
+	return newValueVec(name, help, "gauge", labelNames)
+}
+
+func newValueVec(name string, help string, kind string, labelNames []string) *valueVec {
+	return &valueVec{
+		name:       name,
+		help:       help,
+		kind:       kind,
+		labelNames: labelNames,
+		series:     make(map[string]*valueSeries),
+	}
+}
+
+func (v *valueVec) add(delta float64, labelValues ...string) {
+	v.Lock()
+	v.get(labelValues).value += delta
+	v.Unlock()
+}
+
+func (v *valueVec) inc(labelValues ...string) {
+	v.add(1, labelValues...)
+}
+
+func (v *valueVec) set(value float64, labelValues ...string) {
+	v.Lock()
+	v.get(labelValues).value = value
+	v.Unlock()
+}
+
+// Must be called with the lock held.
+func (v *valueVec) get(labelValues []string) *valueSeries {
+	key := strings.Join(labelValues, "\xff")
+	series, ok := v.series[key]
+	if !ok {
+		series = &valueSeries{labelValues: labelValues}
+		v.series[key] = series
+	}
+	return series
+}
+
+func (v *valueVec) writeTo(w io.Writer) {
+	v.Lock()
+	defer v.Unlock()
+
+	writeHeader(w, v.name, v.help, v.kind)
+	for _, key := range sortedKeys(v.series) {
+		series := v.series[key]
+		fmt.Fprintf(w, "%s%s %s\n", v.name, formatLabels(v.labelNames, series.labelValues), formatValue(series.value))
+	}
+}
+
+// histogramVec is a histogram, partitioned by label values.
+type histogramVec struct {
+	sync.Mutex
+
+	name       string
+	help       string
+	labelNames []string
+	buckets    []float64
+	series     map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labelValues []string
+	counts      []uint64 // per bucket, not cumulative
+	count       uint64
+	sum         float64
+}
+
+func newHistogramVec(name string, help string, buckets []float64, labelNames ...string) *histogramVec {
+	return &histogramVec{
+		name:       name,
+		help:       help,
+		labelNames: labelNames,
+		buckets:    buckets,
+		series:     make(map[string]*histogramSeries),
+	}
+}
+
+func (h *histogramVec) observe(value float64, labelValues ...string) {
+	h.Lock()
+	defer h.Unlock()
+
+	key := strings.Join(labelValues, "\xff")
+	series, ok := h.series[key]
+	if !ok {
+		series = &histogramSeries{
+			labelValues: labelValues,
+			counts:      make([]uint64, len(h.buckets)),
+		}
+		h.series[key] = series
+	}
+
+	for i, upperBound := range h.buckets {
+		if value <= upperBound {
+			series.counts[i]++
+			break
+		}
+	}
+	series.count++
+	series.sum += value
+}
+
+func (h *histogramVec) writeTo(w io.Writer) {
+	h.Lock()
+	defer h.Unlock()
+
+	writeHeader(w, h.name, h.help, "histogram")
+	bucketLabelNames := append(append([]string{}, h.labelNames...), "le")
+	for _, key := range sortedKeys(h.series) {
+		series := h.series[key]
+		var cumulative uint64
+		for i, upperBound := range h.buckets {
+			cumulative += series.counts[i]
+			labelValues := append(append([]string{}, series.labelValues...), formatValue(upperBound))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(bucketLabelNames, labelValues), cumulative)
+		}
+		labelValues := append(append([]string{}, series.labelValues...), "+Inf")
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(bucketLabelNames, labelValues), series.count)
+		labels := formatLabels(h.labelNames, series.labelValues)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, labels, formatValue(series.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labels, series.count)
+	}
+}
+
+func writeHeader(w io.Writer, name string, help string, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names []string, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf(`%s="%s"`, name, labelValueEscaper.Replace(values[i]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch series := m.(type) {
+	case map[string]*valueSeries:
+		for key := range series {
+			keys = append(keys, key)
+		}
+	case map[string]*histogramSeries:
+		for key := range series {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,54 @@
+package websvc
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestMetricsExposition(t *testing.T) {
+	requests := newCounterVec("test_requests_total", "Number of requests\nper \\route.", "route", "code")
+	requests.inc("/api/measurements/{id}", "200")
+	requests.add(2, "/api/measurements/{id}", "200")
+	requests.inc(`C:\dir "quoted"`+"\nnext", "500")
+
+	balance := newGaugeVec("test_balance", "Last known balance.")
+	balance.set(math.Inf(1))
+
+	duration := newHistogramVec("test_duration_seconds", "Duration of tasks.", []float64{0.5, 1, 2.5}, "task")
+	for _, v := range []float64{0.25, 0.75, 0.75, 5} {
+		duration.observe(v, "a")
+	}
+	duration.observe(1, "b")
+
+	var buf bytes.Buffer
+	for _, family := range []metricFamily{requests, balance, duration} {
+		family.writeTo(&buf)
+	}
+
+	const want = `# HELP test_requests_total Number of requests\nper \\route.
+# TYPE test_requests_total counter
+test_requests_total{route="/api/measurements/{id}",code="200"} 3
+test_requests_total{route="C:\\dir \"quoted\"\nnext",code="500"} 1
+# HELP test_balance Last known balance.
+# TYPE test_balance gauge
+test_balance +Inf
+# HELP test_duration_seconds Duration of tasks.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{task="a",le="0.5"} 1
+test_duration_seconds_bucket{task="a",le="1"} 3
+test_duration_seconds_bucket{task="a",le="2.5"} 3
+test_duration_seconds_bucket{task="a",le="+Inf"} 4
+test_duration_seconds_sum{task="a"} 6.75
+test_duration_seconds_count{task="a"} 4
+test_duration_seconds_bucket{task="b",le="0.5"} 0
+test_duration_seconds_bucket{task="b",le="1"} 1
+test_duration_seconds_bucket{task="b",le="2.5"} 1
+test_duration_seconds_bucket{task="b",le="+Inf"} 1
+test_duration_seconds_sum{task="b"} 1
+test_duration_seconds_count{task="b"} 1
+`
+	if got := buf.String(); got != want {
+		t.Errorf("exposition:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,49 @@
+package websvc
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cicovic-andrija/dante/atlas"
+	"github.com/cicovic-andrija/dante/db"
+)
+
+func (s *server) processProbeNTPResults(ctx context.Context, probeResults *atlas.ProbeNTPResults, backend *backendMeasurement, bucketName string) error {
+	var (
+		probe *atlas.Probe
+		err   error
+	)
+
+	if probe, err = s.getProbe(ctx, probeResults.ProbeID); err != nil {
+		return fmt.Errorf("probe info request failed for probe %d and measurement %d: %w", probeResults.ProbeID, backend.ID, err)
+	}
+
+	ntpData := &db.NTPData{
+		BackendID:     backend.ID,
+		AddressFamily: backend.AddressFamily,
+		ProbeID:       probe.ID,
+		ASN:           probe.ASN(backend.AddressFamily),
+		Country:       probe.CountryCode,
+		Target:        backend.Target,
+		TargetIP:      backend.TargetIP,
+		Stratum:       probeResults.Stratum,
+		ReferenceID:   probeResults.ReferenceID,
+		Packets:       make([]db.NTPPacketData, 0, len(probeResults.Replies)),
+		Timestamp:     time.Unix(probeResults.Timestamp, 0),
+	}
+
+	for _, reply := range probeResults.Replies {
+		ntpData.Packets = append(ntpData.Packets, db.NTPPacketData{
+			Offset:   reply.Offset,
+			RTT:      reply.RTT,
+			TimedOut: reply.TimedOut(),
+		})
+	}
+
+	if err = s.database.WriteNTPMeasurementResult(bucketName, ntpData); err != nil {
+		return fmt.Errorf("writing data point failed for %d: %v", backend.ID, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,52 @@
+package websvc
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cicovic-andrija/dante/atlas"
+	"github.com/cicovic-andrija/dante/db"
+)
+
+func (s *server) processProbePingResults(ctx context.Context, probeResults *atlas.ProbePingResults, backend *backendMeasurement, bucketName string) error {
+	var (
+		probe *atlas.Probe
+		err   error
+	)
+
+	if probe, err = s.getProbe(ctx, probeResults.ProbeID); err != nil {
+		return fmt.Errorf("probe info request failed for probe %d and measurement %d: %w", probeResults.ProbeID, backend.ID, err)
+	}
+
+	rtts := make([]float64, 0, len(probeResults.Replies))
+	for _, reply := range probeResults.Replies {
+		if reply.TimedOut() {
+			rtts = append(rtts, -1)
+		} else {
+			rtts = append(rtts, reply.RTT)
+		}
+	}
+
+	pingData := &db.PingData{
+		BackendID:     backend.ID,
+		AddressFamily: backend.AddressFamily,
+		ProbeID:       probe.ID,
+		ASN:           probe.ASN(backend.AddressFamily),
+		Country:       probe.CountryCode,
+		Target:        backend.Target,
+		TargetIP:      backend.TargetIP,
+		MinRTT:        probeResults.Min,
+		AvgRTT:        probeResults.Avg,
+		MaxRTT:        probeResults.Max,
+		Sent:          probeResults.Sent,
+		Received:      probeResults.Received,
+		RTTs:          rtts,
+		Timestamp:     time.Unix(probeResults.Timestamp, 0),
+	}
+	if err = s.database.WritePingMeasurementResult(bucketName, pingData); err != nil {
+		return fmt.Errorf("writing data point failed for %d: %v", backend.ID, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,70 @@
+package websvc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cicovic-andrija/dante/atlas"
+	"github.com/cicovic-andrija/dante/db"
+	"github.com/cicovic-andrija/dante/fakeatlas"
+)
+
+// Creates a measurement against the simulated Atlas API, runs its worker
+// once, and checks that results and checkpoints end up in the store.
+func TestMeasurementPipeline(t *testing.T) {
+	const (
+		probes   = 3
+		interval = 60
+		lookback = time.Hour
+	)
+
+	// measurement starts an hour ago on the simulation clock,
+	// so that results are available right away
+	clock := &simClock{}
+	clock.set(time.Now().Add(-lookback))
+	s, store, sim := newTestServer(t, clock)
+
+	meas := newTestMeasurement(t, s, testHTTPMeasurementReq(probes, interval))
+	if _, ok := s.taskManager.tasks[meas.ID]; !ok {
+		t.Fatalf("worker task %s not scheduled", meas.ID)
+	}
+
+	// an hour of results is generated once the clock catches up
+	clock.set(time.Now())
+	if status, failed := s.updateMeasurementResults(context.Background(), meas); failed {
+		t.Fatalf("worker failed: %s", status)
+	}
+
+	points, err := store.QueryResults(&db.ResultsQuery{
+		Bucket: meas.BucketName,
+		Type:   atlas.MeasHTTP,
+		Start:  time.Now().Add(-2 * lookback),
+		Stop:   time.Now().Add(time.Minute),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := probes * (int(lookback.Seconds())/interval + 1); len(points) != want {
+		t.Errorf("%d results stored, want %d", len(points), want)
+	}
+	spent := fakeatlas.DefaultCredits - sim.Credits()
+	if want := int64(len(points)) * atlas.ResultCredits[atlas.MeasHTTP]; spent != want {
+		t.Errorf("%d credits spent for %d results, want %d", spent, len(points), want)
+	}
+
+	backend := meas.BackendMeasurements[0]
+	if backend.lastResultUnix == 0 {
+		t.Fatal("checkpoint did not advance")
+	}
+	checkpoints, err := store.QueryCheckpoints()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checkpoints[backend.ID] != backend.lastResultUnix {
+		t.Errorf("stored checkpoint = %d, want %d", checkpoints[backend.ID], backend.lastResultUnix)
+	}
+	if meas.Status != CFStatusOngoing {
+		t.Errorf("status = %q, want %q", meas.Status, CFStatusOngoing)
+	}
+}
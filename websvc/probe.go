@@ -1,6 +1,7 @@
 package websvc
 
 import (
+	"context"
 	"net/http"
 	"sync"
 
@@ -32,7 +33,7 @@ func (t *probeTable) insert(probe *atlas.Probe) {
 	t.Unlock()
 }
 
-func (s *server) getProbe(id int64) (*atlas.Probe, error) {
+func (s *server) getProbe(ctx context.Context, id int64) (*atlas.Probe, error) {
 	var (
 		probe *atlas.Probe
 		req   *http.Request
@@ -44,11 +45,10 @@ func (s *server) getProbe(id int64) (*atlas.Probe, error) {
 		return probe, nil
 	}
 
-	req, err = atlas.PrepareRequest(
-		atlas.ProbeURL(id),
+	req, err = s.atlas.PrepareRequest(
+		s.atlas.ProbeURL(id),
 		&atlas.ReqParams{
 			Method: http.MethodGet,
-			Key:    cfg.Atlas.Auth.Key,
 		},
 	)
 	if err != nil {
@@ -56,7 +56,7 @@ func (s *server) getProbe(id int64) (*atlas.Probe, error) {
 	}
 
 	probe = &atlas.Probe{}
-	if err = s.makeRequest(req, probe); err != nil {
+	if err = s.atlas.Do(ctx, req, probe); err != nil {
 		return nil, err
 	}
 
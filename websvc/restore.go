@@ -10,8 +10,7 @@ func (s *server) restore() {
 
 	for _, md := range s.mmd {
 		// fail early in case there is no bucket
-		bck, err := s.database.LookupBucket(fmt.Sprintf(measBucketNameFmt, md.ID))
-		if err != nil {
+		if err := s.database.LookupBucket(fmt.Sprintf(measBucketNameFmt, md.ID)); err != nil {
 			s.log.info("[restore %s] ignore: bucket not found", md.ID)
 			continue
 		}
@@ -24,7 +23,7 @@ func (s *server) restore() {
 			continue
 		}
 
-		meas.bucket = bck
+		meas.hasBucket = true
 		err = s.scheduleWorker(meas)
 		if err != nil {
 			logError(md.ID, fmt.Errorf("failed to schedule worker: %v", err))
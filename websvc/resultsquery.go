@@ -0,0 +1,150 @@
+package websvc
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cicovic-andrija/dante/db"
+	"github.com/cicovic-andrija/dante/util"
+)
+
+// Query parameters of the measurement results endpoint.
+const (
+	resultsParamStart     = "start"
+	resultsParamStop      = "stop"
+	resultsParamField     = "field"
+	resultsParamProbe     = "probe"
+	resultsParamCountry   = "country"
+	resultsParamASN       = "asn"
+	resultsParamTarget    = "target"
+	resultsParamAggregate = "aggregate"
+	resultsParamWindow    = "window"
+	resultsParamGroupBy   = "group_by"
+	resultsParamLimit     = "limit"
+
+	defaultResultsTimeRange = 24 * time.Hour
+)
+
+var (
+	validAggregatesStr  = strings.Join(db.ValidAggregates, ",")
+	validGroupByTagsStr = strings.Join(db.ValidGroupByTags, ",")
+)
+
+type resultsResp struct {
+	ID        string           `json:"id"`
+	Type      string           `json:"type"`
+	Start     time.Time        `json:"start"`
+	Stop      time.Time        `json:"stop"`
+	Aggregate string           `json:"aggregate,omitempty"`
+	Window    string           `json:"window,omitempty"`
+	Points    []db.ResultPoint `json:"points"`
+}
+
+// Parses query parameters into a results query. Returns a client-facing
+// error message if any of the parameters is invalid.
+func parseResultsQuery(meas *measurement, params url.Values) (*db.ResultsQuery, bool, string) {
+	var (
+		now = time.Now()
+		q   = &db.ResultsQuery{
+			Bucket:    meas.BucketName,
+			Type:      meas.Type,
+			Field:     params.Get(resultsParamField),
+			Start:     now.Add(-defaultResultsTimeRange),
+			Stop:      now,
+			ProbeIDs:  listParam(params, resultsParamProbe),
+			Countries: listParam(params, resultsParamCountry),
+			ASNs:      listParam(params, resultsParamASN),
+			Targets:   listParam(params, resultsParamTarget),
+			Aggregate: params.Get(resultsParamAggregate),
+			GroupBy:   listParam(params, resultsParamGroupBy),
+		}
+		err error
+	)
+
+	if v := params.Get(resultsParamStart); v != "" {
+		if q.Start, err = parseTimeParam(v, now); err != nil {
+			return nil, false, fmt.Sprintf(CFInvalidTimeValueFmt, v)
+		}
+	}
+
+	if v := params.Get(resultsParamStop); v != "" {
+		if q.Stop, err = parseTimeParam(v, now); err != nil {
+			return nil, false, fmt.Sprintf(CFInvalidTimeValueFmt, v)
+		}
+	}
+
+	if q.Stop.Before(q.Start) {
+		return nil, false, CFEndTimeBeforeStartTime
+	}
+
+	if q.Aggregate != db.AggregateNone {
+		if found := util.SearchForString(q.Aggregate, db.ValidAggregates...); !found {
+			return nil, false, fmt.Sprintf(CFInvalidAggregateFmt, validAggregatesStr)
+		}
+	}
+
+	// error rate is computed from a fixed field, so the field is only checked
+	if q.Field != "" {
+		numeric, other := db.ResultFields(q.Type)
+		switch {
+		case util.SearchForString(q.Field, numeric...):
+		case q.Aggregate != db.AggregateNone && q.Aggregate != db.AggregateErrorRate:
+			return nil, false, fmt.Sprintf(CFInvalidAggregateFieldFmt, strings.Join(numeric, ","))
+		case !util.SearchForString(q.Field, other...):
+			return nil, false, fmt.Sprintf(CFInvalidFieldFmt, strings.Join(append(numeric, other...), ","))
+		}
+	}
+
+	if v := params.Get(resultsParamWindow); v != "" {
+		if q.Window, err = time.ParseDuration(v); err != nil || q.Window < time.Second {
+			return nil, false, fmt.Sprintf(CFInvalidDurationValueFmt, v)
+		}
+	}
+
+	if len(q.GroupBy) > 0 && q.Aggregate == db.AggregateNone {
+		return nil, false, CFGroupByWithoutAggregate
+	}
+
+	for _, tag := range q.GroupBy {
+		if found := util.SearchForString(tag, db.ValidGroupByTags...); !found {
+			return nil, false, fmt.Sprintf(CFInvalidGroupByFmt, validGroupByTagsStr)
+		}
+	}
+
+	if v := params.Get(resultsParamLimit); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit < 1 {
+			return nil, false, CFInvalidLimitValue
+		}
+	}
+
+	return q, true, ""
+}
+
+// Parses a time value specified either in RFC 3339 format,
+// as "now", or as a duration relative to now, e.g. "-6h".
+func parseTimeParam(v string, now time.Time) (time.Time, error) {
+	if v == "now" {
+		return now, nil
+	}
+	if d, err := time.ParseDuration(v); err == nil {
+		return now.Add(d), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+// Returns values of a query parameter, which can be specified
+// multiple times, or as a comma-separated list.
+func listParam(params url.Values, key string) []string {
+	var values []string
+	for _, param := range params[key] {
+		for _, v := range strings.Split(param, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+	}
+	return values
+}
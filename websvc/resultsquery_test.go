@@ -0,0 +1,41 @@
+package websvc
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/cicovic-andrija/dante/atlas"
+)
+
+func TestParseResultsQueryField(t *testing.T) {
+	tests := []struct {
+		measType string
+		params   string
+		valid    bool
+	}{
+		{atlas.MeasHTTP, "", true},
+		{atlas.MeasHTTP, "field=rt&aggregate=mean", true},
+		{atlas.MeasHTTP, "field=ttl", false},
+		{atlas.MeasDNS, "field=rcode", true},
+		{atlas.MeasDNS, "field=rcode&aggregate=mean", false},
+		{atlas.MeasDNS, "field=rcode&aggregate=error-rate", true},
+		{atlas.MeasSSL, "field=days-until-expiry&aggregate=median", true},
+		{atlas.MeasNTP, "field=ref-id&aggregate=p95", false},
+	}
+
+	for _, test := range tests {
+		params, err := url.ParseQuery(test.params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		meas := &measurement{Type: test.measType, BucketName: "results"}
+		_, ok, errMsg := parseResultsQuery(meas, params)
+		if ok != test.valid {
+			t.Errorf("%s %q: valid = %t, %q", test.measType, test.params, ok, errMsg)
+		}
+		if !ok && !strings.Contains(errMsg, "Field") && !strings.Contains(errMsg, "fields") {
+			t.Errorf("%s %q: unexpected message %q", test.measType, test.params, errMsg)
+		}
+	}
+}
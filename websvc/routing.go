@@ -3,6 +3,7 @@ package websvc
 import (
 	"net/http"
 
+	"github.com/cicovic-andrija/dante/conf"
 	"github.com/gorilla/mux"
 )
 
@@ -17,7 +18,8 @@ func (s *server) initRouter() http.Handler {
 		Adapt(
 			http.HandlerFunc(s.controlHandler), // main handler (last executed)
 			s.logRequest,                       // last executed adapter
-			s.allowMethods(http.MethodPost),    // first executed adapter
+			s.authorize(routeAccess{http.MethodPost: conf.RoleAdmin}),
+			s.allowMethods(http.MethodPost), // first executed adapter
 		),
 	)
 
@@ -26,6 +28,7 @@ func (s *server) initRouter() http.Handler {
 		Adapt(
 			http.HandlerFunc(s.creditsHandler),
 			s.logRequest,
+			s.authorize(routeAccess{http.MethodGet: conf.RoleViewer}),
 			s.allowMethods(http.MethodGet),
 		),
 	)
@@ -35,6 +38,7 @@ func (s *server) initRouter() http.Handler {
 		Adapt(
 			http.HandlerFunc(s.measurementsHandler),
 			s.logRequest,
+			s.authorize(routeAccess{http.MethodGet: conf.RoleViewer, http.MethodPut: conf.RoleOperator}),
 			s.allowMethods(http.MethodGet, http.MethodPut),
 		),
 	)
@@ -44,6 +48,7 @@ func (s *server) initRouter() http.Handler {
 		Adapt(
 			variableRouteHandler(s.singleMeasurementHandler),
 			s.logRequest,
+			s.authorize(routeAccess{http.MethodGet: conf.RoleViewer, http.MethodDelete: conf.RoleAdmin}),
 			s.allowMethods(http.MethodGet, http.MethodDelete),
 		),
 	)
@@ -53,13 +58,105 @@ func (s *server) initRouter() http.Handler {
 		Adapt(
 			variableRouteHandler(s.measurementControlHandler),
 			s.logRequest,
+			s.authorize(routeAccess{http.MethodPost: conf.RoleOperator}),
 			s.allowMethods(http.MethodPost),
 		),
 	)
 
+	router.Handle(
+		"/api/measurements/{id:[0-9a-f]+}/results",
+		Adapt(
+			variableRouteHandler(s.measurementResultsHandler),
+			s.logRequest,
+			s.authorize(routeAccess{http.MethodGet: conf.RoleViewer}),
+			s.allowMethods(http.MethodGet),
+		),
+	)
+
+	router.Handle(
+		"/api/templates",
+		Adapt(
+			http.HandlerFunc(s.templatesHandler),
+			s.logRequest,
+			s.authorize(routeAccess{http.MethodGet: conf.RoleViewer}),
+			s.allowMethods(http.MethodGet),
+		),
+	)
+
+	router.Handle(
+		"/api/templates/{name:[0-9A-Za-z_-]+}",
+		Adapt(
+			variableRouteHandler(s.singleTemplateHandler),
+			s.logRequest,
+			s.authorize(routeAccess{
+				http.MethodGet:    conf.RoleViewer,
+				http.MethodPut:    conf.RoleOperator,
+				http.MethodDelete: conf.RoleOperator,
+			}),
+			s.allowMethods(http.MethodGet, http.MethodPut, http.MethodDelete),
+		),
+	)
+
+	router.Handle(
+		"/api/campaigns",
+		Adapt(
+			http.HandlerFunc(s.campaignsHandler),
+			s.logRequest,
+			s.authorize(routeAccess{http.MethodGet: conf.RoleViewer}),
+			s.allowMethods(http.MethodGet),
+		),
+	)
+
+	router.Handle(
+		"/api/campaigns/{name:[0-9a-z-]+}",
+		Adapt(
+			variableRouteHandler(s.singleCampaignHandler),
+			s.logRequest,
+			s.authorize(routeAccess{
+				http.MethodGet:    conf.RoleViewer,
+				http.MethodPut:    conf.RoleOperator,
+				http.MethodDelete: conf.RoleOperator,
+			}),
+			s.allowMethods(http.MethodGet, http.MethodPut, http.MethodDelete),
+		),
+	)
+
+	router.Handle(
+		"/api/tasks",
+		Adapt(
+			http.HandlerFunc(s.tasksHandler),
+			s.logRequest,
+			s.authorize(routeAccess{http.MethodGet: conf.RoleViewer}),
+			s.allowMethods(http.MethodGet),
+		),
+	)
+
+	router.Handle(
+		"/api/tasks/{name:[0-9a-z-]+}/control",
+		Adapt(
+			variableRouteHandler(s.taskControlHandler),
+			s.logRequest,
+			s.authorize(routeAccess{http.MethodPost: conf.RoleOperator}),
+			s.allowMethods(http.MethodPost),
+		),
+	)
+
+	// requests are not logged, as they are periodically sent by scrapers
+	router.Handle(
+		"/metrics",
+		Adapt(
+			http.HandlerFunc(s.metricsHandler),
+			s.authorize(routeAccess{http.MethodGet: conf.RoleViewer}),
+			s.allowMethods(http.MethodGet),
+		),
+	)
+
 	// catch all
 	router.PathPrefix("/").HandlerFunc(s.invalidEndpointHandler)
 
+	// executed for all routes, before any route adapter
+	router.Use(s.observeRequest)
+
 	return router
 }
 
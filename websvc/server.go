@@ -7,8 +7,11 @@ import (
 	"os"
 	"os/signal"
 	"sync"
+	"syscall"
 	"time"
 
+	"github.com/cicovic-andrija/dante/atlas"
+	"github.com/cicovic-andrija/dante/conf"
 	"github.com/cicovic-andrija/dante/db"
 	"github.com/cicovic-andrija/dante/util"
 )
@@ -24,29 +27,41 @@ type server struct {
 	// server/control
 	name      string
 	shutdownC chan struct{}
+	ctx       context.Context // canceled on shutdown
+	cancel    context.CancelFunc
 
-	// logging
-	log *logstruct
+	// logging and telemetry
+	log     *logstruct
+	metrics *telemetry
 
 	// http server
 	httpServer *http.Server
 	httpWg     *sync.WaitGroup
 	router     http.Handler
+	apiKeys    apiKeyTable  // nil if authentication is disabled
+	tls        *tlsReloader // nil if served over http
 
-	// http client
+	// http clients
 	httpClient *http.Client
+	atlas      *atlas.Client
 
 	// database objects
-	database *db.Client
-	mmd      []db.MeasurementMetadata
+	database        db.Store
+	databaseHealthy int32 // accessed atomically, set by the probe-database task
+	mmd             []db.MeasurementMetadata
+	checkpoints     *checkpointTable
+	templates       *templateTable
+	campaigns       *campaignTable
 
 	// measurements
-	measCache *measurementCache
-	probeInfo *probeTable
+	measCache    *measurementCache
+	probeInfo    *probeTable
+	asnInfo      *asnTable
+	asPaths      *pathTable
+	certWarnings *certWarningTable
 
 	// timer tasks
-	taskManager   *timerTaskManager
-	taskManagerWg *sync.WaitGroup
+	taskManager *timerTaskManager
 }
 
 func (s *server) init() error {
@@ -57,6 +72,7 @@ func (s *server) init() error {
 	}
 
 	s.shutdownC = make(chan struct{})
+	s.ctx, s.cancel = context.WithCancel(context.Background())
 
 	s.log = &logstruct{}
 	if err = s.log.init(s.name); err != nil {
@@ -67,7 +83,23 @@ func (s *server) init() error {
 	s.log.info("[main] configuration: %s", cfg.Path())
 	s.log.info("[main] environment: %s", cfg.Env)
 
-	s.httpInit()
+	s.metrics = newTelemetry()
+
+	if len(cfg.Auth.Keys) > 0 {
+		s.apiKeys = newAPIKeyTable(cfg.Auth.Keys)
+		s.log.info("[main] API authentication: %d keys", len(cfg.Auth.Keys))
+	} else {
+		s.log.info("[main] API authentication: disabled, no keys file configured")
+	}
+
+	if err = s.httpInit(); err != nil {
+		return err
+	}
+	if cfg.Atlas.Sandbox {
+		s.log.info("[main] Atlas API: %s (sandbox)", s.atlas.URLBase())
+	} else {
+		s.log.info("[main] Atlas API: %s", s.atlas.URLBase())
+	}
 
 	if err = s.dbinit(); err != nil {
 		return err
@@ -75,12 +107,21 @@ func (s *server) init() error {
 
 	s.measCache = newMeasurementCache()
 	s.probeInfo = newProbeTable()
+	s.asnInfo = newASNTable()
+	s.asPaths = newPathTable()
+	s.certWarnings = newCertWarningTable()
 
-	s.taskManager = newTimerTaskManager()
+	s.taskManager = newTimerTaskManager(s.ctx, &cfg.Tasks, s.log, s.metrics)
 
 	// default, always-running timer tasks
 	s.taskManager.addTask("get-credits", s.getCredits, 5*time.Minute, s.log)
 	s.taskManager.addTask("probe-database", s.probeDatabase, 10*time.Minute, s.log)
+	s.taskManager.addTask("replay-spool", s.replaySpool, 1*time.Minute, s.log)
+
+	// measurement campaigns, each on its own schedule
+	for name, sched := range s.campaigns.schedules() {
+		s.scheduleCampaign(name, sched)
+	}
 
 	return nil
 }
@@ -91,10 +132,7 @@ func (s *server) run() {
 
 	// Timer tasks
 	s.log.info("[main] starting timer tasks ...")
-	s.taskManagerWg = &sync.WaitGroup{}
-	s.taskManagerWg.Add(1)
-	s.taskManager.run(s.taskManagerWg) // run task manager itself
-	s.taskManager.runAllTasks()
+	s.taskManager.run()
 
 	// Restore thread
 	go s.restore()
@@ -112,42 +150,62 @@ func (s *server) shutdown() {
 	s.httpServer.Shutdown(ctx) // TODO: Handle error returned by Shutdown.
 	s.httpWg.Wait()
 
-	s.taskManager.stopAll()
+	// abort outgoing requests, including those waiting to be retried
+	s.cancel()
+
+	// wait for running tasks, so that their results are written
 	s.taskManager.stop()
 
 	s.database.Close()
 
-	s.taskManager.wg.Wait()
-	s.taskManagerWg.Wait()
-
 	s.log.info("[main] server stopped.")
 	s.log.finalize()
 }
 
 func (s *server) dbinit() error {
-	// database client
-	s.database = db.NewClient(&cfg.Influx)
+	// storage backend, unless a store was already provided
+	if s.database == nil {
+		switch cfg.Storage {
+		case conf.StorageSQLite:
+			s.database = db.NewSQLiteStore(&cfg.SQLite)
+		default:
+			s.database = db.NewClient(&cfg.Influx)
+		}
+	}
+
+	s.database = newMeteredStore(s.database, s.metrics)
 
 	formatError := func(err error) error {
 		return fmt.Errorf("failed to init database: %v", err)
 	}
 
-	if org, err := s.database.EnsureOrganization(cfg.Influx.Organization); err != nil {
+	if err := s.database.Init(); err != nil {
+		return formatError(err)
+	}
+	s.setDatabaseHealthy(true)
+
+	if mmd, err := s.database.QueryMeasurementMetadata(); err != nil {
 		return formatError(err)
 	} else {
-		s.database.Org = org
+		s.mmd = mmd
 	}
 
-	if bck, err := s.database.EnsureBucket(db.SystemBucket); err != nil {
+	if checkpoints, err := s.database.QueryCheckpoints(); err != nil {
 		return formatError(err)
 	} else {
-		s.database.SystemBucket = bck
+		s.checkpoints = newCheckpointTable(checkpoints)
 	}
 
-	if mmd, err := s.database.QueryMeasurementMetadata(); err != nil {
+	if templates, err := s.database.QueryMeasurementTemplates(); err != nil {
 		return formatError(err)
 	} else {
-		s.mmd = mmd
+		s.templates = newTemplateTable(templates)
+	}
+
+	if campaigns, err := s.database.QueryMeasurementCampaigns(); err != nil {
+		return formatError(err)
+	} else if s.campaigns, err = newCampaignTable(campaigns); err != nil {
+		return formatError(err)
 	}
 
 	return nil
@@ -162,3 +220,28 @@ func (s *server) interruptHandler() {
 	s.log.info("[interrupt] signaling shutdown ...")
 	s.signalShutdown()
 }
+
+// Reloads TLS certificates on SIGHUP, so that they can be
+// renewed without restarting the server.
+func (s *server) reloadHandler() {
+	sigchan := make(chan os.Signal, 1)
+	signal.Notify(sigchan, syscall.SIGHUP)
+	defer signal.Stop(sigchan)
+
+	for {
+		select {
+		case <-sigchan:
+			if s.tls == nil {
+				s.log.info("[reload] nothing to reload, server is not using TLS")
+				continue
+			}
+			if err := s.tls.reload(); err != nil {
+				s.log.err("[reload] failed to reload TLS certificates, previous ones stay in use: %v", err)
+				continue
+			}
+			s.log.info("[reload] TLS certificates reloaded")
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
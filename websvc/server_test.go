@@ -0,0 +1,112 @@
+package websvc
+
+import (
+	"context"
+	"io"
+	"log"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cicovic-andrija/dante/atlas"
+	"github.com/cicovic-andrija/dante/conf"
+	"github.com/cicovic-andrija/dante/db"
+	"github.com/cicovic-andrija/dante/fakeatlas"
+)
+
+// simClock is the clock of a simulated Atlas API. It stands still,
+// so that the simulation generates results only when it is moved.
+type simClock struct {
+	unixNano int64 // accessed atomically
+}
+
+func (c *simClock) now() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&c.unixNano))
+}
+
+func (c *simClock) set(t time.Time) {
+	atomic.StoreInt64(&c.unixNano, t.UnixNano())
+}
+
+// Returns a server that is initialized as on boot, except that it uses
+// an in-memory store, the simulated Atlas API, and does not log.
+// Timer tasks are scheduled, but not run.
+func newTestServer(t *testing.T, clock *simClock) (*server, *db.MemStore, *fakeatlas.Server) {
+	t.Helper()
+
+	cfg = &conf.Config{
+		Atlas: conf.AtlasConf{
+			Net:     conf.Net{Protocol: conf.HTTPSString, DNSName: "atlas.example", Port: 443},
+			APIPath: atlas.DefaultAPIPath,
+			Sandbox: true,
+		},
+	}
+
+	discard := &logger{backend: log.New(io.Discard, "", 0)}
+	s := &server{
+		log:     &logstruct{infoLogger: discard, errorLogger: discard},
+		metrics: newTelemetry(),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+
+	sim := fakeatlas.NewServer(fakeatlas.Options{Now: clock.now})
+	s.httpClientInit()
+	s.atlas.SetTransport(sim.Transport())
+
+	store := db.NewMemStore()
+	s.database = store
+	if err := s.dbinit(); err != nil {
+		t.Fatalf("dbinit failed: %v", err)
+	}
+
+	s.measCache = newMeasurementCache()
+	s.probeInfo = newProbeTable()
+	s.asnInfo = newASNTable()
+	s.asPaths = newPathTable()
+	s.certWarnings = newCertWarningTable()
+	s.taskManager = newTimerTaskManager(s.ctx, &cfg.Tasks, s.log, s.metrics)
+
+	t.Cleanup(s.cancel)
+	return s, store, sim
+}
+
+// Validates a measurement request and creates a measurement from it.
+// The measurement must be scheduled with a single backend measurement.
+func newTestMeasurement(t *testing.T, s *server, req *measurementReq) *measurement {
+	t.Helper()
+
+	if ok, errMsg := s.validateMeasurementReq(req); !ok {
+		t.Fatalf("request rejected: %s", errMsg)
+	}
+
+	id, err := freshMeasurementID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.measurementCreationWorkflow(req, id)
+
+	meas, ok := s.measCache.get(id)
+	if !ok {
+		t.Fatalf("measurement %s not in cache", id)
+	}
+	if meas.Status != CFStatusScheduled {
+		t.Fatalf("status = %q, reason %q; want %q", meas.Status, meas.Reason, CFStatusScheduled)
+	}
+	if len(meas.BackendMeasurements) != 1 {
+		t.Fatalf("%d backend measurements, want 1", len(meas.BackendMeasurements))
+	}
+	return meas
+}
+
+// Returns a measurement request for a single HTTP target.
+func testHTTPMeasurementReq(probes int64, intervalSec int64) *measurementReq {
+	return &measurementReq{
+		Type:          atlas.MeasHTTP,
+		Targets:       []string{"example.com"},
+		ProbeRequests: []probeReq{{Requested: probes, Type: "area", Value: "WW"}},
+		Description:   "test measurement",
+		Start:         timeSpecNow,
+		Duration:      "2h",
+		IntervalSec:   intervalSec,
+	}
+}
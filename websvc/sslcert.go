@@ -0,0 +1,117 @@
+package websvc
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cicovic-andrija/dante/atlas"
+	"github.com/cicovic-andrija/dante/db"
+)
+
+// Certificates expiring in less than this number of days are reported in the log.
+const certExpiryWarningDays = 14
+
+// Upper bounds of days left until expiry, in increasing order. A certificate
+// is reported again only when it moves to a lower band.
+var certExpiryBands = []float64{0, 1, 3, 7, certExpiryWarningDays}
+
+// maximum number of (backend measurement, certificate) pairs tracked by certWarningTable
+const maxTrackedCerts = 10000
+
+// certWarningTable remembers the last reported expiry band for each
+// (backend measurement, certificate) pair, so that an expiring certificate
+// is reported once per band instead of once per result, even if probes are
+// served different certificates for the same target. When full, an arbitrary
+// pair is evicted to make room for a new one.
+type certWarningTable struct {
+	sync.Mutex
+
+	bands map[string]int
+}
+
+func newCertWarningTable() *certWarningTable {
+	return &certWarningTable{
+		bands: make(map[string]int),
+	}
+}
+
+// update records the expiry of a certificate served to a probe of a backend
+// measurement, and returns a flag indicating whether it should be reported.
+func (t *certWarningTable) update(backendID int64, fingerprint string, days float64) (report bool) {
+	if days >= certExpiryWarningDays {
+		return false
+	}
+
+	band := 0
+	for band < len(certExpiryBands)-1 && days >= certExpiryBands[band] {
+		band++
+	}
+
+	key := fmt.Sprintf("%d/%s", backendID, fingerprint)
+	t.Lock()
+	defer t.Unlock()
+	if reported, ok := t.bands[key]; ok && reported == band {
+		return false
+	} else if !ok && len(t.bands) >= maxTrackedCerts {
+		for evicted := range t.bands {
+			delete(t.bands, evicted)
+			break
+		}
+	}
+	t.bands[key] = band
+	return true
+}
+
+func (s *server) processProbeSSLCertResults(ctx context.Context, probeResults *atlas.ProbeSSLCertResults, backend *backendMeasurement, bucketName string) error {
+	var (
+		probe *atlas.Probe
+		cert  *x509.Certificate
+		err   error
+	)
+
+	if probe, err = s.getProbe(ctx, probeResults.ProbeID); err != nil {
+		return fmt.Errorf("probe info request failed for probe %d and measurement %d: %w", probeResults.ProbeID, backend.ID, err)
+	}
+
+	certData := &db.SSLCertData{
+		BackendID:     backend.ID,
+		AddressFamily: backend.AddressFamily,
+		ProbeID:       probe.ID,
+		ASN:           probe.ASN(backend.AddressFamily),
+		Country:       probe.CountryCode,
+		Target:        backend.Target,
+		TargetIP:      probeResults.DstAddr,
+		Error:         probeResults.Error,
+		Timestamp:     time.Unix(probeResults.Timestamp, 0),
+	}
+
+	if certData.Error == "" {
+		if cert, err = probeResults.LeafCertificate(); err != nil {
+			certData.Error = err.Error()
+		} else {
+			certData.TLSVersion = probeResults.Version
+			certData.HandshakeTime = probeResults.RT
+			certData.ConnectTime = probeResults.TTC
+			certData.Subject = cert.Subject.String()
+			certData.Issuer = cert.Issuer.String()
+			certData.NotBefore = cert.NotBefore
+			certData.NotAfter = cert.NotAfter
+			certData.Fingerprint = atlas.CertificateFingerprint(cert)
+
+			days := certData.DaysUntilExpiry()
+			if s.certWarnings.update(backend.ID, certData.Fingerprint, days) {
+				s.log.info("[mgmt] probe %d is served certificate %s for %s that expires in %.1f days",
+					probe.ID, certData.Fingerprint, backend.Target, days)
+			}
+		}
+	}
+
+	if err = s.database.WriteSSLCertMeasurementResult(bucketName, certData); err != nil {
+		return fmt.Errorf("writing data point failed for %d: %v", backend.ID, err)
+	}
+
+	return nil
+}
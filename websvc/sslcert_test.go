@@ -0,0 +1,34 @@
+package websvc
+
+import (
+	"testing"
+)
+
+// Probes served a stale and a fresh certificate for the same
+// target report the stale one once per expiry band.
+func TestCertWarningTableUpdate(t *testing.T) {
+	warnings := newCertWarningTable()
+
+	tests := []struct {
+		fingerprint string
+		days        float64
+		report      bool
+	}{
+		{"stale", 10, true},
+		{"fresh", 80, false},
+		{"stale", 9.9, false},
+		{"fresh", 80, false},
+		{"stale", 6, true},
+		{"stale", 5, false},
+		{"other", 6, true},
+		{"stale", 0.5, true},
+		{"stale", -1, true},
+		{"stale", -2, false},
+	}
+
+	for i, test := range tests {
+		if report := warnings.update(1, test.fingerprint, test.days); report != test.report {
+			t.Errorf("%d: update(%q, %.1f) = %t, want %t", i, test.fingerprint, test.days, report, test.report)
+		}
+	}
+}
@@ -1,40 +1,52 @@
 package websvc
 
 import (
+	"context"
 	"fmt"
+	"sync/atomic"
+	"time"
 
 	"github.com/cicovic-andrija/dante/db"
 )
 
 // Intended to be run as a timer task, thus the signature.
-func (s *server) getCredits(args ...interface{} /* unused */) (status string, failed bool) {
-	credit, err := s.httpGetCredits()
+func (s *server) getCredits(ctx context.Context, args ...interface{} /* unused */) (status string, failed bool) {
+	credit, err := s.httpGetCredits(ctx)
 
 	if err == nil {
 		err = s.database.WriteCreditBalance(credit.CurrentBalance)
 	}
 
 	if err == nil {
-		return timerTaskSuccess(fmt.Sprintf("remaining credits: %d", credit.CurrentBalance))
+		return timerTaskSuccess(fmt.Sprintf("remaining credits: %d; %s", credit.CurrentBalance, s.limiterStatus()))
 	}
 
 	return timerTaskFailure(err)
 }
 
+// Describes the state of the Atlas API rate limiter, for logging.
+func (s *server) limiterStatus() string {
+	stats := s.atlas.LimiterStats()
+	var avgWait time.Duration
+	if stats.Waits > 0 {
+		avgWait = stats.WaitTime / time.Duration(stats.Waits)
+	}
+	return fmt.Sprintf("Atlas API requests waiting: %d, in flight: %d, average wait: %v",
+		stats.Waiting, stats.InFlight, avgWait)
+}
+
 // Intended to be run as a timer task, thus the signature.
-func (s *server) probeDatabase(args ...interface{} /* unused */) (status string, failed bool) {
-	var (
-		report = &db.HealthReport{}
-		err    error
-	)
+func (s *server) probeDatabase(ctx context.Context, args ...interface{} /* unused */) (status string, failed bool) {
+	report, err := s.database.Health()
 
-	err = s.httpGet(s.database.HealthEndpoint(), report)
+	healthy := err == nil && report.Status == db.HealthStatusPass
+	s.setDatabaseHealthy(healthy)
 
 	if err == nil {
-		if report.Status == db.HealthStatusPass {
+		if healthy {
 			return timerTaskSuccess(
 				fmt.Sprintf("database is healthy and available on %s: %s",
-					s.database.ServerURL(), report.Message),
+					s.database.Location(), report.Message),
 			)
 		}
 		err = fmt.Errorf("database is unhealthy with status %q: %s",
@@ -43,3 +55,33 @@ func (s *server) probeDatabase(args ...interface{} /* unused */) (status string,
 
 	return timerTaskFailure(err)
 }
+
+// Intended to be run as a timer task, thus the signature.
+func (s *server) replaySpool(ctx context.Context, args ...interface{} /* unused */) (status string, failed bool) {
+	// replay only when the last probe reported the database as healthy,
+	// otherwise the spooled results would just be spooled again
+	if !s.isDatabaseHealthy() {
+		return timerTaskSuccess("database is unhealthy, replay postponed")
+	}
+
+	replayed, remaining, err := s.database.ReplaySpool()
+	s.metrics.dbSpooled.set(float64(remaining))
+	if err != nil {
+		s.setDatabaseHealthy(false)
+		return timerTaskFailure(fmt.Errorf("replayed %d data points, %d remaining: %v", replayed, remaining, err))
+	}
+
+	return timerTaskSuccess(fmt.Sprintf("replayed %d data points, %d remaining", replayed, remaining))
+}
+
+func (s *server) setDatabaseHealthy(healthy bool) {
+	var value int32
+	if healthy {
+		value = 1
+	}
+	atomic.StoreInt32(&s.databaseHealthy, value)
+}
+
+func (s *server) isDatabaseHealthy() bool {
+	return atomic.LoadInt32(&s.databaseHealthy) == 1
+}
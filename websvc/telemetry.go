@@ -0,0 +1,302 @@
+package websvc
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cicovic-andrija/dante/db"
+	"github.com/gorilla/mux"
+)
+
+// Service telemetry, exposed on the /metrics endpoint.
+type telemetry struct {
+	httpRequests        *valueVec
+	httpRequestDuration *histogramVec
+	atlasRequests       *valueVec
+	atlasRequestErrors  *valueVec
+	atlasDuration       *histogramVec
+	dbWrites            *valueVec
+	dbFlushes           *valueVec
+	dbSpooled           *valueVec
+	resultsDropped      *valueVec
+	taskIterations      *valueVec
+	taskFailures        *valueVec
+	taskSkips           *valueVec
+	taskDuration        *histogramVec
+	creditBalance       *valueVec
+
+	families []metricFamily
+}
+
+func newTelemetry() *telemetry {
+	t := &telemetry{
+		httpRequests: newCounterVec("dante_http_requests_total",
+			"Number of handled HTTP requests.", "route", "method", "code"),
+		httpRequestDuration: newHistogramVec("dante_http_request_duration_seconds",
+			"Duration of handling HTTP requests.", defaultDurationBuckets, "route", "method"),
+		atlasRequests: newCounterVec("dante_atlas_requests_total",
+			"Number of requests sent to the Atlas API.", "operation", "code"),
+		atlasRequestErrors: newCounterVec("dante_atlas_request_errors_total",
+			"Number of requests sent to the Atlas API that failed or returned an error status.", "operation"),
+		atlasDuration: newHistogramVec("dante_atlas_request_duration_seconds",
+			"Duration of requests sent to the Atlas API.", defaultDurationBuckets, "operation"),
+		dbWrites: newCounterVec("dante_db_writes_total",
+			"Number of database writes.", "measurement", "result"),
+		dbFlushes: newCounterVec("dante_db_flushes_total",
+			"Number of flushes of pending measurement results to the database.", "result"),
+		dbSpooled: newGaugeVec("dante_db_spooled_points",
+			"Number of data points spooled locally, waiting to be written to the database."),
+		resultsDropped: newCounterVec("dante_results_dropped_total",
+			"Number of measurement results that were given up on without being stored.", "type", "reason"),
+		taskIterations: newCounterVec("dante_task_iterations_total",
+			"Number of timer task iterations.", "task"),
+		taskFailures: newCounterVec("dante_task_failures_total",
+			"Number of failed timer task iterations.", "task"),
+		taskSkips: newCounterVec("dante_task_skipped_iterations_total",
+			"Number of timer task iterations skipped because the previous one was still running.", "task"),
+		taskDuration: newHistogramVec("dante_task_iteration_duration_seconds",
+			"Duration of timer task iterations.", defaultDurationBuckets, "task"),
+		creditBalance: newGaugeVec("dante_credit_balance",
+			"Last known Atlas credit balance."),
+	}
+
+	t.families = []metricFamily{
+		t.httpRequests, t.httpRequestDuration,
+		t.atlasRequests, t.atlasRequestErrors, t.atlasDuration,
+		t.dbWrites, t.dbFlushes, t.dbSpooled, t.resultsDropped,
+		t.taskIterations, t.taskFailures, t.taskSkips, t.taskDuration,
+		t.creditBalance,
+	}
+
+	return t
+}
+
+func (t *telemetry) observeTask(name string, duration time.Duration, failed bool) {
+	t.taskIterations.inc(name)
+	if failed {
+		t.taskFailures.inc(name)
+	}
+	t.taskDuration.observe(duration.Seconds(), name)
+}
+
+func (s *server) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	// HTTP GET
+	var buf bytes.Buffer
+	for _, family := range s.metrics.families {
+		family.writeTo(&buf)
+	}
+
+	// measurement counts are read from the cache on each scrape
+	measurements := newGaugeVec("dante_measurements", "Number of measurements per status.", "status")
+	for _, status := range []string{CFStatusFailed, CFStatusOngoing, CFStatusQueued, CFStatusScheduled, CFStatusStopped} {
+		measurements.set(0, statusLabel(status))
+	}
+	for _, meas := range s.measCache.getAll() {
+		measurements.inc(statusLabel(meas.Status))
+	}
+	measurements.writeTo(&buf)
+
+	// rate limiter and scheduler state are read on each scrape
+	limiterStats := s.atlas.LimiterStats()
+	scheduledTasks, busyWorkers := s.taskManager.stats()
+	for _, family := range []struct {
+		metric *valueVec
+		value  float64
+	}{
+		{newGaugeVec("dante_atlas_queue_depth", "Number of Atlas API requests waiting for the rate limiter."),
+			float64(limiterStats.Waiting)},
+		{newGaugeVec("dante_atlas_requests_in_flight", "Number of Atlas API requests in flight."),
+			float64(limiterStats.InFlight)},
+		{newCounterVec("dante_atlas_limiter_waits_total", "Number of Atlas API requests that waited for the rate limiter, including ones canceled while waiting."),
+			float64(limiterStats.Waits)},
+		{newCounterVec("dante_atlas_limiter_wait_seconds_total", "Total time Atlas API requests waited for the rate limiter."),
+			limiterStats.WaitTime.Seconds()},
+		{newGaugeVec("dante_tasks_scheduled", "Number of scheduled timer tasks."),
+			float64(scheduledTasks)},
+		{newGaugeVec("dante_task_workers_busy", "Number of task workers running an iteration."),
+			float64(busyWorkers)},
+	} {
+		family.metric.set(family.value)
+		family.metric.writeTo(&buf)
+	}
+
+	w.Header().Set("Content-Type", metricsContentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+// Converts a client-facing status message (e.g. "Ongoing.") to a label value.
+func statusLabel(status string) string {
+	return strings.ToLower(strings.TrimSuffix(status, "."))
+}
+
+// Observes request counts and durations per route template.
+// It is meant to be registered as a router middleware.
+func (s *server) observeRequest(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := "unknown"
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+
+		// call original handler
+		h.ServeHTTP(sw, r)
+
+		s.metrics.httpRequests.inc(route, r.Method, strconv.Itoa(sw.status))
+		s.metrics.httpRequestDuration.observe(time.Since(start).Seconds(), route, r.Method)
+	})
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// atlasTransport observes requests sent to the Atlas API.
+type atlasTransport struct {
+	base    http.RoundTripper
+	apiPath string
+	metrics *telemetry
+}
+
+func (t *atlasTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	operation := req.Method + " " + atlasOperation(strings.TrimPrefix(req.URL.Path, t.apiPath))
+
+	start := time.Now()
+	res, err := t.base.RoundTrip(req)
+	t.metrics.atlasDuration.observe(time.Since(start).Seconds(), operation)
+
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(res.StatusCode)
+	}
+	t.metrics.atlasRequests.inc(operation, code)
+	if err != nil || res.StatusCode >= http.StatusBadRequest {
+		t.metrics.atlasRequestErrors.inc(operation)
+	}
+
+	return res, err
+}
+
+// Replaces numeric path segments (IDs) with a placeholder,
+// to keep the number of distinct operations small.
+func atlasOperation(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if _, err := strconv.ParseInt(segment, 10, 64); err == nil {
+			segments[i] = "{id}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// meteredStore counts database writes and flushes.
+type meteredStore struct {
+	db.Store
+	metrics *telemetry
+
+	// measurement results are written asynchronously
+	async bool
+}
+
+// Wraps a store. Writes of results by a backend that writes them
+// asynchronously are counted when they happen.
+func newMeteredStore(store db.Store, metrics *telemetry) *meteredStore {
+	m := &meteredStore{Store: store, metrics: metrics}
+	if w, ok := store.(db.AsyncWriter); ok {
+		w.ObserveWrites(func(measurement string, err error) {
+			m.observe(measurement, err)
+		})
+		m.async = true
+	}
+	return m
+}
+
+func (m *meteredStore) observe(measurement string, err error) error {
+	m.metrics.dbWrites.inc(measurement, resultLabel(err))
+	return err
+}
+
+// Counts a write of a measurement result, unless it is written
+// asynchronously and counted later; results that failed to be
+// accepted for writing are always counted.
+func (m *meteredStore) observeResult(measurement string, err error) error {
+	if m.async && err == nil {
+		return nil
+	}
+	return m.observe(measurement, err)
+}
+
+func (m *meteredStore) Flush(bucketName string) error {
+	err := m.Store.Flush(bucketName)
+	m.metrics.dbFlushes.inc(resultLabel(err))
+	return err
+}
+
+func resultLabel(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
+
+func (m *meteredStore) WriteMeasurementMetadata(md db.MeasurementMetadata) error {
+	return m.observe(db.MetadataMeasurement, m.Store.WriteMeasurementMetadata(md))
+}
+
+func (m *meteredStore) WriteMeasurementTemplate(tmpl db.MeasurementTemplate) error {
+	return m.observe(db.TemplateMeasurement, m.Store.WriteMeasurementTemplate(tmpl))
+}
+
+func (m *meteredStore) WriteMeasurementCampaign(camp db.MeasurementCampaign) error {
+	return m.observe(db.CampaignMeasurement, m.Store.WriteMeasurementCampaign(camp))
+}
+
+func (m *meteredStore) DeleteCheckpoints(measID string) error {
+	return m.observe(db.CheckpointMeasurement, m.Store.DeleteCheckpoints(measID))
+}
+
+func (m *meteredStore) WriteCheckpoint(measID string, backendID int64, lastResultUnix int64) error {
+	return m.observe(db.CheckpointMeasurement, m.Store.WriteCheckpoint(measID, backendID, lastResultUnix))
+}
+
+func (m *meteredStore) WriteHTTPMeasurementResult(bucketName string, httpData *db.HTTPData) error {
+	return m.observeResult(db.HTTPMeasurement, m.Store.WriteHTTPMeasurementResult(bucketName, httpData))
+}
+
+func (m *meteredStore) WritePingMeasurementResult(bucketName string, pingData *db.PingData) error {
+	return m.observeResult(db.PingMeasurement, m.Store.WritePingMeasurementResult(bucketName, pingData))
+}
+
+func (m *meteredStore) WriteTracerouteMeasurementResult(bucketName string, trData *db.TracerouteData) error {
+	return m.observeResult(db.TracerouteMeasurement, m.Store.WriteTracerouteMeasurementResult(bucketName, trData))
+}
+
+func (m *meteredStore) WriteDNSMeasurementResult(bucketName string, dnsData *db.DNSData) error {
+	return m.observeResult(db.DNSMeasurement, m.Store.WriteDNSMeasurementResult(bucketName, dnsData))
+}
+
+func (m *meteredStore) WriteSSLCertMeasurementResult(bucketName string, certData *db.SSLCertData) error {
+	return m.observeResult(db.SSLCertMeasurement, m.Store.WriteSSLCertMeasurementResult(bucketName, certData))
+}
+
+func (m *meteredStore) WriteNTPMeasurementResult(bucketName string, ntpData *db.NTPData) error {
+	return m.observeResult(db.NTPMeasurement, m.Store.WriteNTPMeasurementResult(bucketName, ntpData))
+}
+
+func (m *meteredStore) WriteCreditBalance(creditBalance int64) error {
+	return m.observe(db.CreditBalanceMeasurement, m.Store.WriteCreditBalance(creditBalance))
+}
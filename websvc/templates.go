@@ -0,0 +1,235 @@
+package websvc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cicovic-andrija/dante/db"
+)
+
+// maximum size of a measurement template specification, in bytes
+const maxTemplateSpecSize = 64 * 1024
+
+// templateTable holds measurement templates, as loaded from the database
+// on boot and updated through the API. Specifications are kept as JSON
+// fragments of measurement requests.
+type templateTable struct {
+	sync.RWMutex
+
+	templates map[string]json.RawMessage
+}
+
+func newTemplateTable(templates []db.MeasurementTemplate) *templateTable {
+	t := &templateTable{
+		templates: make(map[string]json.RawMessage, len(templates)),
+	}
+	for _, tmpl := range templates {
+		t.templates[tmpl.Name] = json.RawMessage(tmpl.Spec)
+	}
+	return t
+}
+
+func (t *templateTable) get(name string) (json.RawMessage, bool) {
+	t.RLock()
+	defer t.RUnlock()
+	spec, ok := t.templates[name]
+	return spec, ok
+}
+
+// Returns all templates, ordered by name.
+func (t *templateTable) getAll() []*templateResp {
+	t.RLock()
+	defer t.RUnlock()
+	templates := make([]*templateResp, 0, len(t.templates))
+	for name, spec := range t.templates {
+		templates = append(templates, &templateResp{Name: name, Spec: spec})
+	}
+	sort.Slice(templates, func(i, j int) bool { return templates[i].Name < templates[j].Name })
+	return templates
+}
+
+// Returns true if a template with the same name was replaced.
+func (t *templateTable) put(name string, spec json.RawMessage) (replaced bool) {
+	t.Lock()
+	defer t.Unlock()
+	_, replaced = t.templates[name]
+	t.templates[name] = spec
+	return
+}
+
+func (t *templateTable) delete(name string) (found bool) {
+	t.Lock()
+	defer t.Unlock()
+	if _, found = t.templates[name]; found {
+		delete(t.templates, name)
+	}
+	return
+}
+
+func (s *server) templatesHandler(w http.ResponseWriter, r *http.Request) {
+	// HTTP GET
+	s.httpWriteResponseObject(w, r, http.StatusOK, s.templates.getAll())
+}
+
+func (s *server) singleTemplateHandler(w http.ResponseWriter, r *http.Request, routeVars map[string]string) {
+	name := routeVars[namePathVariable]
+
+	switch r.Method {
+	// HTTP GET
+	case http.MethodGet:
+		if spec, ok := s.templates.get(name); ok {
+			s.httpWriteResponseObject(w, r, http.StatusOK, &templateResp{Name: name, Spec: spec})
+		} else {
+			s.httpWriteResponseObject(w, r, http.StatusNotFound, ResourceNotFound)
+		}
+
+	// HTTP PUT
+	case http.MethodPut:
+		spec, ok, errMsg := readTemplateSpec(w, r)
+		if !ok {
+			s.badRequest(w, r, errMsg)
+			return
+		}
+
+		if err := s.database.WriteMeasurementTemplate(db.MeasurementTemplate{Name: name, Spec: string(spec)}); err != nil {
+			s.internalServerError(w, r, err)
+			return
+		}
+
+		status := http.StatusCreated
+		if replaced := s.templates.put(name, spec); replaced {
+			status = http.StatusOK
+		}
+		s.log.info("[template %s] stored", name)
+		s.httpWriteResponseObject(w, r, status, &templateResp{Name: name, Spec: spec})
+
+	// HTTP DELETE
+	case http.MethodDelete:
+		if _, ok := s.templates.get(name); !ok {
+			s.httpWriteResponseObject(w, r, http.StatusNotFound, ResourceNotFound)
+			return
+		}
+
+		if err := s.database.DeleteMeasurementTemplate(name); err != nil {
+			s.internalServerError(w, r, err)
+			return
+		}
+
+		s.templates.delete(name)
+		s.log.info("[template %s] deleted", name)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Reads a template specification from the request body: a fragment
+// of a measurement request. Only fields of a measurement request are
+// allowed, and times must be valid time specifications.
+// Returns the specification in compact form, or a client-facing error message.
+func readTemplateSpec(w http.ResponseWriter, r *http.Request) (json.RawMessage, bool, string) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxTemplateSpecSize))
+	if err != nil {
+		return nil, false, CFReqDecodingFailed
+	}
+
+	fragment := &measurementReq{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(fragment); err != nil {
+		return nil, false, CFReqDecodingFailed
+	}
+
+	if fragment.Template != "" {
+		return nil, false, CFTemplateInTemplate
+	}
+
+	now := time.Now()
+	for _, spec := range []string{fragment.Start, fragment.StartTimeRFC3339, fragment.Stop, fragment.StopTimeRFC3339} {
+		if spec == "" {
+			continue
+		}
+		if _, err = parseTimeSpec(spec, now, now); err != nil {
+			return nil, false, fmt.Sprintf(CFInvalidTimeValueFmt, spec)
+		}
+	}
+
+	if fragment.Duration != "" {
+		if _, err = parseDurationSpec(fragment.Duration); err != nil {
+			return nil, false, fmt.Sprintf(CFInvalidDurationValueFmt, fragment.Duration)
+		}
+	}
+
+	compact := &bytes.Buffer{}
+	if err = json.Compact(compact, body); err != nil {
+		return nil, false, CFReqDecodingFailed
+	}
+	return compact.Bytes(), true, ""
+}
+
+// Decodes a measurement request, and applies the template it names, if any.
+func (s *server) decodeMeasurementReq(w http.ResponseWriter, r *http.Request, req *measurementReq) bool {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		s.badRequest(w, r, CFReqDecodingFailed)
+		return false
+	}
+
+	ok, errMsg, err := s.resolveMeasurementReq(body, req)
+	if err != nil {
+		s.internalServerError(w, r, err)
+		return false
+	}
+	if !ok {
+		s.badRequest(w, r, errMsg)
+		return false
+	}
+
+	return true
+}
+
+// Decodes a measurement request from JSON. If the request names a template,
+// it is decoded over the template specification, so that fields present
+// in the request override those of the template. Start or stop time in
+// the request replaces all fields that specify it in the template.
+// Returns a client-facing error message if the request cannot be decoded.
+func (s *server) resolveMeasurementReq(body []byte, req *measurementReq) (bool, string, error) {
+	if err := json.Unmarshal(body, req); err != nil {
+		return false, CFReqDecodingFailed, nil
+	}
+
+	if req.Template == "" {
+		return true, "", nil
+	}
+
+	spec, ok := s.templates.get(req.Template)
+	if !ok {
+		return false, fmt.Sprintf(CFTemplateNotFoundFmt, req.Template), nil
+	}
+
+	var (
+		name     = req.Template
+		override = *req
+	)
+	*req = measurementReq{}
+	if err := json.Unmarshal(spec, req); err != nil {
+		return false, "", fmt.Errorf("failed to apply template %s: %v", name, err)
+	}
+
+	if override.Start != "" || override.StartTimeRFC3339 != "" {
+		req.Start, req.StartTimeRFC3339 = "", ""
+	}
+	if override.Stop != "" || override.StopTimeRFC3339 != "" || override.Duration != "" {
+		req.Stop, req.StopTimeRFC3339, req.Duration = "", "", ""
+	}
+
+	if err := json.Unmarshal(body, req); err != nil {
+		return false, "", fmt.Errorf("failed to apply template %s: %v", name, err)
+	}
+
+	return true, "", nil
+}
@@ -1,52 +1,86 @@
 package websvc
 
 import (
+	"container/heap"
+	"context"
+	"errors"
 	"fmt"
+	"math/rand"
+	"sort"
 	"sync"
 	"time"
+
+	"github.com/cicovic-andrija/dante/conf"
 )
 
+// Default task scheduling options, used for options not set in configuration.
 const (
-	stopChBufferSz = 32
+	defaultTaskWorkers    = 16
+	defaultTaskTimeoutSec = 300
+
+	// scheduler wakes up at least this often, even with no tasks due
+	schedulerIdleWait = time.Minute
 )
 
-type taskFn func(args ...interface{}) (string, bool)
+// Errors returned by task control methods.
+var (
+	errTaskNotFound = errors.New("task not found")
+	errTaskRunning  = errors.New("task is running")
+)
+
+type taskFn func(ctx context.Context, args ...interface{}) (string, bool)
+
+// taskSchedule computes run times of tasks that do not run periodically.
+type taskSchedule interface {
+	next(after time.Time) time.Time
+	String() string
+}
 
 type timerTask struct {
 	name    string
 	execute taskFn
 	period  time.Duration
 	log     *logstruct
-	quit    chan struct{}
+
+	// runs the task at times computed by a schedule instead of periodically;
+	// period, jitter and immediate are ignored if set
+	schedule taskSchedule
+	// maximum random delay added to each period
+	jitter time.Duration
+	// first iteration runs without waiting for a period
+	immediate bool
+	// maximum duration of an iteration, manager default if zero;
+	// the context passed to execute is canceled when it elapses
+	timeout time.Duration
+
+	// scheduling state, guarded by the manager lock
+	args    []interface{}
+	next    time.Time
+	index   int // position in the queue, -1 if not queued
+	iter    int
+	running bool
+	paused  bool
+	stopped bool
+
+	// outcome of the last iteration, guarded by the manager lock
+	lastRun      time.Time
+	lastDuration time.Duration
+	lastStatus   string
 }
 
-func (t *timerTask) run(wg *sync.WaitGroup, args ...interface{}) {
-	t.quit = make(chan struct{})
-	go func() {
-		ticker := time.NewTicker(t.period)
-		iter := 0
-		t.log.info("[task %s] started", t.name)
-		for {
-			select {
-			case <-ticker.C:
-				iter += 1
-				status, failed := t.execute(args...)
-				t.log.info("[task %s] iteration %d: %s", t.name, iter, status)
-				if failed {
-					t.log.err("[task %s] iteration %d: %s", t.name, iter, status)
-				}
-			case <-t.quit:
-				ticker.Stop()
-				t.log.info("[task %s] stopped", t.name)
-				wg.Done()
-				return
-			}
-		}
-	}()
+func (t *timerTask) randomJitter() time.Duration {
+	if t.jitter <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(t.jitter)))
 }
 
-func (t *timerTask) stop() {
-	close(t.quit)
+// Returns the time of the next run, after a run at a given time.
+func (t *timerTask) nextRun(now time.Time) time.Time {
+	if t.schedule != nil {
+		return t.schedule.next(now)
+	}
+	return now.Add(t.period + t.randomJitter())
 }
 
 func timerTaskSuccess(message string) (string, bool) {
@@ -57,98 +91,411 @@ func timerTaskFailure(err error) (string, bool) {
 	return fmt.Sprintf("failed with error: %v", err), true
 }
 
+// taskQueue is a min-heap of tasks ordered by the time of their next run.
+type taskQueue []*timerTask
+
+func (q taskQueue) Len() int           { return len(q) }
+func (q taskQueue) Less(i, j int) bool { return q[i].next.Before(q[j].next) }
+
+func (q taskQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *taskQueue) Push(x interface{}) {
+	task := x.(*timerTask)
+	task.index = len(*q)
+	*q = append(*q, task)
+}
+
+func (q *taskQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	task := old[n-1]
+	old[n-1] = nil
+	task.index = -1
+	*q = old[:n-1]
+	return task
+}
+
+// timerTaskManager runs periodic tasks on a fixed number of workers.
+// A single scheduler keeps tasks in a queue ordered by the time of their
+// next run, and hands tasks that are due over to the workers.
+// An iteration that is due while the previous one is still running is skipped.
 type timerTaskManager struct {
 	sync.Mutex
 
-	// self management
-	quit chan struct{}
+	queue   taskQueue
+	tasks   map[string]*timerTask
+	busy    int
+	workers int
+	timeout time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wake   chan struct{}
+	work   chan *timerTask
+	quit   chan struct{}
 
-	// task management
-	tasks  map[string]*timerTask
-	stopCh chan string
-	wg     *sync.WaitGroup
+	schedulerWg *sync.WaitGroup
+	workersWg   *sync.WaitGroup
+
+	log     *logstruct
+	metrics *telemetry
 }
 
-func newTimerTaskManager() *timerTaskManager {
-	return &timerTaskManager{
-		quit:   make(chan struct{}),
-		tasks:  make(map[string]*timerTask),
-		stopCh: make(chan string, stopChBufferSz),
-		wg:     &sync.WaitGroup{},
+func newTimerTaskManager(ctx context.Context, cfg *conf.TasksConf, log *logstruct, metrics *telemetry) *timerTaskManager {
+	workers := cfg.Workers
+	if workers <= 0 {
+		workers = defaultTaskWorkers
+	}
+	timeoutSec := cfg.TimeoutSec
+	if timeoutSec <= 0 {
+		timeoutSec = defaultTaskTimeoutSec
+	}
+
+	t := &timerTaskManager{
+		tasks:       make(map[string]*timerTask),
+		workers:     workers,
+		timeout:     time.Duration(timeoutSec) * time.Second,
+		wake:        make(chan struct{}, 1),
+		work:        make(chan *timerTask),
+		quit:        make(chan struct{}),
+		schedulerWg: &sync.WaitGroup{},
+		workersWg:   &sync.WaitGroup{},
+		log:         log,
+		metrics:     metrics,
 	}
+	t.ctx, t.cancel = context.WithCancel(ctx)
+
+	return t
 }
 
-// Run task manager itself.
-func (t *timerTaskManager) run(wg *sync.WaitGroup) {
-	go func() {
-		for {
-			select {
-			case taskName := <-t.stopCh:
-				t.Lock()
-				if task, ok := t.tasks[taskName]; ok {
-					task.stop()
-					delete(t.tasks, taskName) // is this ok?
-				}
-				t.Unlock()
-			case <-t.quit:
-				wg.Done()
-				return
-			}
-		}
-	}()
+// Run task manager itself: the scheduler and the workers.
+func (t *timerTaskManager) run() {
+	t.workersWg.Add(t.workers)
+	for i := 0; i < t.workers; i++ {
+		go t.runWorker()
+	}
+
+	t.schedulerWg.Add(1)
+	go t.runScheduler()
 }
 
-// Stop task manager itself.
+// Stop task manager itself, and wait for running iterations to finish.
+// Running iterations are canceled through their context.
 func (t *timerTaskManager) stop() {
+	t.cancel()
 	close(t.quit)
-	close(t.stopCh)
+	t.schedulerWg.Wait()
+
+	close(t.work)
+	t.workersWg.Wait()
+
+	t.Lock()
+	for name, task := range t.tasks {
+		task.stopped = true
+		delete(t.tasks, name)
+	}
+	t.queue = nil
+	t.Unlock()
 }
 
+// scheduleTask adds a task to the queue. It can be called before the manager
+// is run, in which case the task runs once the manager is started.
+// A task scheduled under the name of an existing task replaces it.
 func (t *timerTaskManager) scheduleTask(task *timerTask, args ...interface{}) {
 	t.Lock()
+	if old, ok := t.tasks[task.name]; ok {
+		t.stopLocked(old)
+	}
+
+	if task.timeout <= 0 {
+		task.timeout = t.timeout
+	}
+	task.args = args
+	task.index = -1
+	task.iter = 0
+	task.running = false
+	task.paused = false
+	task.stopped = false
+
+	switch {
+	case task.schedule != nil:
+		task.next = task.schedule.next(time.Now())
+	case task.immediate:
+		task.next = time.Now().Add(task.randomJitter())
+	default:
+		task.next = task.nextRun(time.Now())
+	}
+
 	t.tasks[task.name] = task
-	t.wg.Add(1)
-	task.run(t.wg, args...)
+	heap.Push(&t.queue, task)
 	t.Unlock()
+
+	task.log.info("[task %s] started", task.name)
+	t.wakeScheduler()
 }
 
+// stopTask removes a task from the queue. An iteration that is running
+// finishes, but the task is not run again. It is safe to call stopTask
+// from the task itself.
 func (t *timerTaskManager) stopTask(name string) {
 	t.Lock()
+	defer t.Unlock()
 	if task, ok := t.tasks[name]; ok {
-		task.stop()
-		delete(t.tasks, name)
+		t.stopLocked(task)
+	}
+}
+
+// Must be called with the lock held.
+func (t *timerTaskManager) stopLocked(task *timerTask) {
+	task.stopped = true
+	if task.index >= 0 {
+		heap.Remove(&t.queue, task.index)
+	}
+	delete(t.tasks, task.name)
+	task.log.info("[task %s] stopped", task.name)
+}
+
+// runNow moves the next run of a task to the present. A paused task
+// runs once, and stays paused.
+func (t *timerTaskManager) runNow(name string) error {
+	t.Lock()
+	task, ok := t.tasks[name]
+	if !ok {
+		t.Unlock()
+		return errTaskNotFound
+	}
+	if task.running {
+		t.Unlock()
+		return errTaskRunning
+	}
+
+	task.next = time.Now()
+	if task.index >= 0 {
+		heap.Fix(&t.queue, task.index)
+	} else {
+		heap.Push(&t.queue, task)
 	}
 	t.Unlock()
+
+	t.wakeScheduler()
+	return nil
 }
 
-func (t *timerTaskManager) stopAll() {
+// pause removes a task from the queue until it is resumed.
+// An iteration that is running finishes.
+func (t *timerTaskManager) pause(name string) error {
 	t.Lock()
-	for _, task := range t.tasks {
-		task.stop()
+	defer t.Unlock()
+	task, ok := t.tasks[name]
+	if !ok {
+		return errTaskNotFound
+	}
+
+	task.paused = true
+	if task.index >= 0 {
+		heap.Remove(&t.queue, task.index)
+	}
+	return nil
+}
+
+// resume puts a paused task back in the queue,
+// to run after one period, or at the next scheduled time.
+func (t *timerTaskManager) resume(name string) error {
+	t.Lock()
+	task, ok := t.tasks[name]
+	if !ok {
+		t.Unlock()
+		return errTaskNotFound
+	}
+
+	task.paused = false
+	if task.index < 0 {
+		task.next = task.nextRun(time.Now())
+		heap.Push(&t.queue, task)
 	}
 	t.Unlock()
+
+	t.wakeScheduler()
+	return nil
+}
+
+// describe returns the state of all scheduled tasks, ordered by name.
+func (t *timerTaskManager) describe() []*taskResp {
+	t.Lock()
+	defer t.Unlock()
+
+	tasks := make([]*taskResp, 0, len(t.tasks))
+	for _, task := range t.tasks {
+		resp := &taskResp{
+			Name:       task.name,
+			Iterations: task.iter,
+			Running:    task.running,
+			Paused:     task.paused,
+			LastStatus: task.lastStatus,
+		}
+		if task.schedule != nil {
+			resp.Schedule = task.schedule.String()
+		} else {
+			resp.Period = task.period.String()
+		}
+		if !task.lastRun.IsZero() {
+			lastRun := task.lastRun
+			resp.LastRun = &lastRun
+			resp.LastDuration = task.lastDuration.String()
+		}
+		if task.index >= 0 {
+			next := task.next
+			resp.NextRun = &next
+		}
+		tasks = append(tasks, resp)
+	}
+
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].Name < tasks[j].Name })
+	return tasks
 }
 
 // This is just a help method to be used during server boot.
 // It should not be used later.
 func (t *timerTaskManager) addTask(name string, fn taskFn, period time.Duration, log *logstruct) {
-	t.Lock()
-	t.tasks[name] = &timerTask{
+	t.scheduleTask(&timerTask{
 		name:    name,
 		execute: fn,
 		period:  period,
 		log:     log,
+	})
+}
+
+// stats returns the number of scheduled tasks,
+// and the number of workers running an iteration.
+func (t *timerTaskManager) stats() (scheduled int, busy int) {
+	t.Lock()
+	defer t.Unlock()
+	return len(t.tasks), t.busy
+}
+
+func (t *timerTaskManager) wakeScheduler() {
+	select {
+	case t.wake <- struct{}{}:
+	default:
 	}
-	t.Unlock()
 }
 
-// This is just a help method to be used during server boot.
-// It should not be used later.
-func (t *timerTaskManager) runAllTasks() {
+func (t *timerTaskManager) runScheduler() {
+	defer t.schedulerWg.Done()
+
+	for {
+		due, wait := t.dueTasks(time.Now())
+
+		for _, task := range due {
+			select {
+			case t.work <- task:
+			case <-t.quit:
+				return
+			}
+		}
+
+		// tasks could have become due while handing over to busy workers
+		if len(due) > 0 {
+			continue
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-t.wake:
+			timer.Stop()
+		case <-t.quit:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// Takes the tasks that are due from the queue and schedules their next run.
+// Tasks with an iteration still running are skipped. Returns tasks to run,
+// and the time until the next task is due.
+func (t *timerTaskManager) dueTasks(now time.Time) ([]*timerTask, time.Duration) {
 	t.Lock()
-	for _, task := range t.tasks {
-		task.run(t.wg)
-		t.wg.Add(1)
+	defer t.Unlock()
+
+	var due []*timerTask
+	for len(t.queue) > 0 && !t.queue[0].next.After(now) {
+		task := t.queue[0]
+		if task.paused {
+			// forced run of a paused task, run only once
+			heap.Pop(&t.queue)
+		} else {
+			task.next = task.nextRun(now)
+			heap.Fix(&t.queue, 0)
+		}
+
+		if task.running {
+			task.log.info("[task %s] iteration skipped: previous iteration still running", task.name)
+			if t.metrics != nil {
+				t.metrics.taskSkips.inc(task.name)
+			}
+			continue
+		}
+
+		task.running = true
+		due = append(due, task)
+	}
+
+	wait := schedulerIdleWait
+	if len(t.queue) > 0 {
+		if untilNext := t.queue[0].next.Sub(now); untilNext < wait {
+			wait = untilNext
+		}
 	}
+
+	return due, wait
+}
+
+func (t *timerTaskManager) runWorker() {
+	defer t.workersWg.Done()
+	for task := range t.work {
+		t.execute(task)
+	}
+}
+
+func (t *timerTaskManager) execute(task *timerTask) {
+	t.Lock()
+	if task.stopped {
+		task.running = false
+		t.Unlock()
+		return
+	}
+	task.iter += 1
+	iter := task.iter
+	t.busy++
 	t.Unlock()
+
+	ctx, cancel := context.WithTimeout(t.ctx, task.timeout)
+	start := time.Now()
+	status, failed := task.execute(ctx, task.args...)
+	duration := time.Since(start)
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		status, failed = fmt.Sprintf("timed out after %v: %s", task.timeout, status), true
+	}
+	cancel()
+
+	t.Lock()
+	task.running = false
+	task.lastRun = start
+	task.lastDuration = duration
+	task.lastStatus = status
+	t.busy--
+	t.Unlock()
+
+	if t.metrics != nil {
+		t.metrics.observeTask(task.name, duration, failed)
+	}
+	task.log.info("[task %s] iteration %d: %s", task.name, iter, status)
+	if failed {
+		task.log.err("[task %s] iteration %d: %s", task.name, iter, status)
+	}
 }
@@ -0,0 +1,90 @@
+package websvc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Time specifications accepted in measurement requests,
+// besides timestamps in RFC 3339 format.
+const (
+	timeSpecNow          = "now"
+	timeSpecOffsetPrefix = "+"
+)
+
+// Names of measurement request fields that hold time specifications.
+const (
+	reqFieldStart            = "start"
+	reqFieldStartTimeRFC3339 = "start_time_rfc3339"
+	reqFieldStop             = "stop"
+	reqFieldStopTimeRFC3339  = "stop_time_rfc3339"
+)
+
+// Returns the time specification of one of two request fields that specify
+// the same point in time, or an empty string if neither is set.
+// Returns a client-facing error message if both are set.
+func oneTimeSpec(spec string, field string, altSpec string, altField string) (string, bool, string) {
+	switch {
+	case spec != "" && altSpec != "":
+		return "", false, fmt.Sprintf(CFConflictingFieldsFmt, field, altField)
+	case spec != "":
+		return spec, true, ""
+	default:
+		return altSpec, true, ""
+	}
+}
+
+// Returns true if a time specification is empty, or relative to the time
+// at which it is parsed.
+func relativeTimeSpec(spec string) bool {
+	return spec == "" || spec == timeSpecNow || strings.HasPrefix(spec, timeSpecOffsetPrefix)
+}
+
+// Parses a point in time, specified either as an RFC 3339 timestamp,
+// as "now", or as an offset from a base time, such as "+30m" or "+7d".
+func parseTimeSpec(spec string, now time.Time, base time.Time) (time.Time, error) {
+	switch {
+	case spec == timeSpecNow:
+		return now, nil
+	case strings.HasPrefix(spec, timeSpecOffsetPrefix):
+		offset, err := parseDurationSpec(strings.TrimPrefix(spec, timeSpecOffsetPrefix))
+		if err != nil {
+			return time.Time{}, err
+		}
+		return base.Add(offset), nil
+	default:
+		return time.Parse(time.RFC3339, spec)
+	}
+}
+
+// Parses a positive duration, in the format accepted by time.ParseDuration,
+// or as a whole number of days or weeks, such as "7d" or "2w".
+func parseDurationSpec(spec string) (time.Duration, error) {
+	var (
+		d   time.Duration
+		err error
+	)
+
+	if n := len(spec); n > 1 && (spec[n-1] == 'd' || spec[n-1] == 'w') {
+		unit := 24 * time.Hour
+		if spec[n-1] == 'w' {
+			unit *= 7
+		}
+		var count int64
+		if count, err = strconv.ParseInt(spec[:n-1], 10, 32); err == nil {
+			d = time.Duration(count) * unit
+		}
+	} else {
+		d, err = time.ParseDuration(spec)
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", spec)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("duration %q is not positive", spec)
+	}
+	return d, nil
+}
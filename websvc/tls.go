@@ -0,0 +1,83 @@
+package websvc
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/cicovic-andrija/dante/conf"
+)
+
+// tlsReloader holds the TLS configuration of the HTTP server,
+// built from certificate files that can be read again while
+// the server is running.
+type tlsReloader struct {
+	sync.RWMutex
+
+	files   conf.TLSConf
+	current *tls.Config
+}
+
+func newTLSReloader(files *conf.TLSConf) (*tlsReloader, error) {
+	r := &tlsReloader{files: *files}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reload reads the certificate files, and replaces the configuration used
+// for new connections. The previous configuration stays in use on error.
+func (r *tlsReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(r.files.CertFile, r.files.KeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate: %v", err)
+	}
+
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+
+	if r.files.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.files.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read client CA bundle: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return errors.New("failed to parse client CA bundle: no certificates found")
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	r.Lock()
+	r.current = config
+	r.Unlock()
+
+	return nil
+}
+
+// serverConfig returns the configuration to set on the HTTP server.
+// It defers to the most recently loaded configuration on each handshake.
+func (r *tlsReloader) serverConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return &r.config().Certificates[0], nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.config(), nil
+		},
+	}
+}
+
+func (r *tlsReloader) config() *tls.Config {
+	r.RLock()
+	defer r.RUnlock()
+	return r.current
+}
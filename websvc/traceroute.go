@@ -0,0 +1,305 @@
+package websvc
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cicovic-andrija/dante/atlas"
+	"github.com/cicovic-andrija/dante/db"
+)
+
+const (
+	// maximum number of IP addresses cached by asnTable
+	maxCachedASNs = 100000
+
+	// a failed origin lookup is not repeated for an address for this long
+	asnLookupRetryDelay = time.Minute
+)
+
+type asnEntry struct {
+	asn int64
+	err error
+
+	// failed lookups expire
+	expires time.Time
+}
+
+// asnTable caches origin AS numbers of IP addresses seen in traceroute hops.
+// AS number 0 is cached for addresses that are not announced. Failed lookups
+// are cached briefly, so that they are not repeated for every hop while the
+// lookup service is unavailable. When full, an arbitrary address is evicted
+// to make room for a new one.
+type asnTable struct {
+	sync.RWMutex
+
+	asns map[string]asnEntry
+}
+
+func newASNTable() *asnTable {
+	return &asnTable{
+		asns: make(map[string]asnEntry),
+	}
+}
+
+// lookup returns the cached origin of an address,
+// or the error of a recent failed lookup.
+func (t *asnTable) lookup(ip string) (entry asnEntry, ok bool) {
+	t.RLock()
+	entry, ok = t.asns[ip]
+	t.RUnlock()
+	if ok && entry.err != nil && time.Now().After(entry.expires) {
+		return asnEntry{}, false
+	}
+	return
+}
+
+func (t *asnTable) insert(ip string, asn int64) {
+	t.store(ip, asnEntry{asn: asn})
+}
+
+func (t *asnTable) insertFailure(ip string, err error) {
+	t.store(ip, asnEntry{err: err, expires: time.Now().Add(asnLookupRetryDelay)})
+}
+
+func (t *asnTable) store(ip string, entry asnEntry) {
+	t.Lock()
+	if _, ok := t.asns[ip]; !ok && len(t.asns) >= maxCachedASNs {
+		for evicted := range t.asns {
+			delete(t.asns, evicted)
+			break
+		}
+	}
+	t.asns[ip] = entry
+	t.Unlock()
+}
+
+const (
+	// maximum number of (backend measurement, probe) pairs tracked by pathTable
+	maxTrackedPaths = 100000
+
+	// AS paths of results this far behind the latest result of a probe are
+	// kept, so that results fetched again in the overlap of two fetch windows
+	// are compared to the same preceding path as when first processed
+	pathHistoryWindow = 2 * resultsFetchOverlap
+)
+
+type pathEntry struct {
+	timestamp int64
+	path      string
+}
+
+// pathTable keeps recent AS paths for each (backend measurement, probe)
+// pair, ordered by result timestamp. When full, an arbitrary
+// pair is evicted to make room for a new one.
+type pathTable struct {
+	sync.Mutex
+
+	paths map[string][]pathEntry
+}
+
+func newPathTable() *pathTable {
+	return &pathTable{
+		paths: make(map[string][]pathEntry),
+	}
+}
+
+// update stores the AS path of a result, and returns a flag indicating
+// whether it differs from the path of the preceding result of the probe.
+// Results may be processed more than once, and out of order. The flag is not
+// known for a result older than all kept paths, as there is nothing to compare
+// it to; the first result of a probe is not considered a change.
+func (t *pathTable) update(backendID int64, probeID int64, timestamp int64, path string) (changed bool, known bool) {
+	key := fmt.Sprintf("%d/%d", backendID, probeID)
+	t.Lock()
+	defer t.Unlock()
+
+	history, ok := t.paths[key]
+	if !ok && len(t.paths) >= maxTrackedPaths {
+		for evicted := range t.paths {
+			delete(t.paths, evicted)
+			break
+		}
+	}
+
+	i := sort.Search(len(history), func(i int) bool { return history[i].timestamp >= timestamp })
+	switch {
+	case i > 0:
+		changed, known = history[i-1].path != path, true
+	case len(history) == 0:
+		known = true
+	}
+
+	if i < len(history) && history[i].timestamp == timestamp {
+		history[i].path = path
+	} else {
+		history = append(history, pathEntry{})
+		copy(history[i+1:], history[i:])
+		history[i] = pathEntry{timestamp: timestamp, path: path}
+	}
+
+	// drop paths out of the window, except the one preceding the window
+	cutoff := history[len(history)-1].timestamp - int64(pathHistoryWindow.Seconds())
+	if j := sort.Search(len(history), func(i int) bool { return history[i].timestamp >= cutoff }); j > 1 {
+		history = history[:copy(history, history[j-1:])]
+	}
+
+	t.paths[key] = history
+	return
+}
+
+// Private address ranges: RFC 1918 for IPv4 and RFC 4193 for IPv6.
+var privateNets = []*net.IPNet{
+	{IP: net.IPv4(10, 0, 0, 0), Mask: net.CIDRMask(8, 32)},
+	{IP: net.IPv4(172, 16, 0, 0), Mask: net.CIDRMask(12, 32)},
+	{IP: net.IPv4(192, 168, 0, 0), Mask: net.CIDRMask(16, 32)},
+	{IP: net.IP{0xfc, 15: 0}, Mask: net.CIDRMask(7, 128)},
+}
+
+func isPrivateIP(ip net.IP) bool {
+	for _, n := range privateNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *server) getOriginASN(ctx context.Context, ip string) (int64, error) {
+	if entry, ok := s.asnInfo.lookup(ip); ok {
+		return entry.asn, entry.err
+	}
+
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return 0, fmt.Errorf("invalid IP address %q", ip)
+	}
+
+	// do not ask for addresses that cannot be announced
+	if isPrivateIP(addr) || addr.IsLoopback() || addr.IsLinkLocalUnicast() || addr.IsUnspecified() {
+		s.asnInfo.insert(ip, 0)
+		return 0, nil
+	}
+
+	info := &atlas.NetworkInfo{}
+	if err := s.httpGet(ctx, atlas.NetworkInfoURL(ip), info); err != nil {
+		// a lookup interrupted by the end of an iteration did not fail
+		if ctx.Err() == nil {
+			s.asnInfo.insertFailure(ip, err)
+		}
+		return 0, err
+	}
+	if info.Status != atlas.StatStatusOK {
+		err := fmt.Errorf("network info lookup for %s failed with status %q", ip, info.Status)
+		s.asnInfo.insertFailure(ip, err)
+		return 0, err
+	}
+
+	var asn int64
+	if len(info.Data.ASNs) > 0 {
+		asn, _ = strconv.ParseInt(info.Data.ASNs[0], 10, 64)
+	}
+
+	s.asnInfo.insert(ip, asn)
+	return asn, nil
+}
+
+func (s *server) processProbeTracerouteResults(ctx context.Context, probeResults *atlas.ProbeTracerouteResults, backend *backendMeasurement, bucketName string) error {
+	var (
+		probe *atlas.Probe
+		err   error
+	)
+
+	if probe, err = s.getProbe(ctx, probeResults.ProbeID); err != nil {
+		return fmt.Errorf("probe info request failed for probe %d and measurement %d: %w", probeResults.ProbeID, backend.ID, err)
+	}
+
+	trData := &db.TracerouteData{
+		BackendID:     backend.ID,
+		AddressFamily: backend.AddressFamily,
+		ProbeID:       probe.ID,
+		ASN:           probe.ASN(backend.AddressFamily),
+		Country:       probe.CountryCode,
+		Target:        backend.Target,
+		TargetIP:      backend.TargetIP,
+		Hops:          make([]db.TracerouteHopData, 0, len(probeResults.Hops)),
+		Timestamp:     time.Unix(probeResults.Timestamp, 0),
+	}
+
+	asPath := make([]string, 0, len(probeResults.Hops))
+	// path is unknown if the origin of a hop could not be looked up
+	pathKnown := true
+	for _, hop := range probeResults.Hops {
+		if hop.Error != "" {
+			continue
+		}
+
+		hopData := db.TracerouteHopData{Hop: hop.Hop}
+		rttSum := 0.0
+		for _, reply := range hop.Replies {
+			hopData.Sent++
+			if reply.TimedOut() {
+				continue
+			}
+			hopData.Received++
+			rttSum += reply.RTT
+			if hopData.IP == "" {
+				hopData.IP = reply.From
+			}
+			if reply.From == probeResults.DstAddr {
+				trData.DestinationReached = true
+			}
+		}
+		if hopData.Received > 0 {
+			hopData.RTT = rttSum / float64(hopData.Received)
+		}
+		trData.Hops = append(trData.Hops, hopData)
+
+		if hopData.IP == "" {
+			continue
+		}
+
+		if !pathKnown {
+			continue
+		}
+
+		// hops that are not announced are left out of the path
+		asn, err := s.getOriginASN(ctx, hopData.IP)
+		if err != nil {
+			s.log.err("[mgmt] origin lookup failed for hop %s of probe %d and measurement %d: %v",
+				hopData.IP, probe.ID, backend.ID, err)
+			pathKnown = false
+			continue
+		}
+		if asn == 0 {
+			continue
+		}
+		asnStr := strconv.FormatInt(asn, 10)
+		if len(asPath) == 0 || asPath[len(asPath)-1] != asnStr {
+			asPath = append(asPath, asnStr)
+		}
+	}
+
+	// an unknown path is not stored, and not compared to the last known one
+	if pathKnown {
+		trData.ASPath = strings.Join(asPath, " ")
+		trData.ASPathKnown = true
+		trData.ASPathChanged, trData.ASPathChangeKnown = s.asPaths.update(
+			backend.ID, probe.ID, probeResults.Timestamp, trData.ASPath)
+	}
+	if trData.ASPathChanged {
+		s.log.info("[mgmt] AS path changed for probe %d and measurement %d: %s",
+			probe.ID, backend.ID, trData.ASPath)
+	}
+
+	if err = s.database.WriteTracerouteMeasurementResult(bucketName, trData); err != nil {
+		return fmt.Errorf("writing data point failed for %d: %v", backend.ID, err)
+	}
+
+	return nil
+}
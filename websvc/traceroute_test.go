@@ -0,0 +1,96 @@
+package websvc
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Results fetched again, or uploaded late, are compared
+// to the path of the result that precedes them in time.
+func TestPathTableUpdate(t *testing.T) {
+	paths := newPathTable()
+	window := int64(pathHistoryWindow.Seconds())
+
+	tests := []struct {
+		timestamp int64
+		path      string
+		changed   bool
+		known     bool
+	}{
+		{1000, "1 2", false, true},
+		{1060, "1 3", true, true},
+		// fetched again
+		{1060, "1 3", true, true},
+		// uploaded late
+		{1030, "1 2", false, true},
+		{1060, "1 3", true, true},
+		{1120, "1 3", false, true},
+		// older than all kept paths
+		{900, "1 2", false, false},
+		// the path preceding the window is kept
+		{1120 + window, "1 3", false, true},
+		{1120 + window, "1 3", false, true},
+		{1120, "1 2", true, true},
+	}
+
+	for i, test := range tests {
+		changed, known := paths.update(1, 1000, test.timestamp, test.path)
+		if changed != test.changed || known != test.known {
+			t.Errorf("%d: update(%d, %q) = (%t, %t), want (%t, %t)",
+				i, test.timestamp, test.path, changed, known, test.changed, test.known)
+		}
+	}
+
+	if n := len(paths.paths["1/1000"]); n != 3 {
+		t.Errorf("%d paths kept, want 3", n)
+	}
+}
+
+func TestIsPrivateIP(t *testing.T) {
+	tests := map[string]bool{
+		"10.1.2.3":     true,
+		"172.16.0.1":   true,
+		"172.31.255.1": true,
+		"172.32.0.1":   false,
+		"192.168.1.1":  true,
+		"193.0.14.129": false,
+		"fc00::1":      true,
+		"fdff::1":      true,
+		"fe80::1":      false,
+		"2001:db8::1":  false,
+	}
+	for ip, want := range tests {
+		if got := isPrivateIP(net.ParseIP(ip)); got != want {
+			t.Errorf("isPrivateIP(%s) = %t, want %t", ip, got, want)
+		}
+	}
+}
+
+func TestASNTable(t *testing.T) {
+	asns := newASNTable()
+
+	asns.insert("192.0.2.1", 64500)
+	if entry, ok := asns.lookup("192.0.2.1"); !ok || entry.asn != 64500 || entry.err != nil {
+		t.Errorf("lookup = (%+v, %t), want AS64500", entry, ok)
+	}
+
+	// failed lookups are cached until they expire
+	asns.insertFailure("192.0.2.2", errors.New("unavailable"))
+	if entry, ok := asns.lookup("192.0.2.2"); !ok || entry.err == nil {
+		t.Errorf("lookup = (%+v, %t), want cached failure", entry, ok)
+	}
+	asns.asns["192.0.2.2"] = asnEntry{err: errors.New("unavailable"), expires: time.Now().Add(-time.Second)}
+	if _, ok := asns.lookup("192.0.2.2"); ok {
+		t.Error("expired failure still cached")
+	}
+
+	for i := 0; i < maxCachedASNs+10; i++ {
+		asns.insert(strconv.Itoa(i), int64(i))
+	}
+	if n := len(asns.asns); n != maxCachedASNs {
+		t.Errorf("%d addresses cached, want %d", n, maxCachedASNs)
+	}
+}